@@ -0,0 +1,66 @@
+package bitcaskkv
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/utils"
+)
+
+// startAutoMerge 启动后台goroutine，定期检查可回收数据的占比，达到阈值后自动进行merge
+func (db *DB) startAutoMerge() {
+	if db.MergeRatio <= 0 || db.ReadOnly {
+		return
+	}
+	db.runInBackground(db.MergeCheckInterval, func() {
+		// 后台merge失败时等待下一次检查即可，不影响前台读写
+		_ = db.autoMerge()
+	})
+}
+
+// autoMerge 检查是否满足自动merge的条件，满足则执行merge
+func (db *DB) autoMerge() error {
+	// 上一次merge的结果尚未被加载，无需重复merge
+	if _, err := os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFileName)); err == nil {
+		return nil
+	}
+	if err := db.checkMergeCondition(); err != nil {
+		return err
+	}
+	return db.Merge()
+}
+
+// checkMergeCondition 判断旧数据文件中可回收数据的占比是否达到阈值，以及磁盘剩余空间是否足够
+func (db *DB) checkMergeCondition() error {
+	db.mu.RLock()
+	// merge只会重写旧数据文件，活跃文件中的可回收数据不计入
+	var olderFilesSize, reclaimSize int64
+	for fid, dataFile := range db.olderFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			db.mu.RUnlock()
+			return err
+		}
+		olderFilesSize += size
+		reclaimSize += db.reclaimSizes[fid]
+	}
+	db.mu.RUnlock()
+
+	if olderFilesSize == 0 || float32(reclaimSize)/float32(olderFilesSize) < db.MergeRatio {
+		return ErrMergeRatioUnreached
+	}
+	// merge需要将旧数据文件中的有效数据重新写入一份，预估所需空间后再判断剩余空间是否足够
+	availableSize, err := utils.AvailableDiskSize(db.DirPath)
+	if err != nil {
+		return err
+	}
+	var liveSize uint64
+	if olderFilesSize > reclaimSize {
+		liveSize = uint64(olderFilesSize - reclaimSize)
+	}
+	if availableSize < liveSize+db.MergeMinFreeDisk {
+		return ErrNoEnoughSpaceForMerge
+	}
+	return nil
+}
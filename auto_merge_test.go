@@ -0,0 +1,109 @@
+package bitcaskkv
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckMergeCondition(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024), WithDBMergeRatio(0.5))
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.ErrorIs(t, db.checkMergeCondition(), ErrMergeRatioUnreached)
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	require.NoError(t, db.checkMergeCondition())
+
+	db.MergeMinFreeDisk = math.MaxUint64 / 2
+	require.ErrorIs(t, db.checkMergeCondition(), ErrNoEnoughSpaceForMerge)
+}
+
+func TestCheckMergeConditionIgnoresActiveFile(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(64*1024), WithDBMergeRatio(0.5))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// 旧数据文件中没有可回收的数据
+	for i := 0; db.activeFile == nil || db.activeFile.FileID == 0; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	activeFid := db.activeFile.FileID
+	require.NoError(t, db.Put([]byte("hot"), utils.GetRandomValue(64)))
+	// 反复覆盖的数据都位于活跃文件中，merge无法回收
+	for db.activeFile.FileID == activeFid && db.activeFile.WriteOff < 60*1024 {
+		require.NoError(t, db.Put([]byte("hot"), utils.GetRandomValue(64)))
+	}
+	require.Equal(t, activeFid, db.activeFile.FileID)
+	stat, err := db.Stat()
+	require.NoError(t, err)
+	require.Greater(t, stat.ReclaimableSize, int64(32*1024))
+	require.ErrorIs(t, db.checkMergeCondition(), ErrMergeRatioUnreached)
+}
+
+func TestAutoMerge(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{
+		WithDBDirPath(dirPath),
+		WithDBMaxDataFileSize(4 * 1024),
+		WithDBMergeRatio(0.5),
+		WithDBMergeCheckInterval(10 * time.Millisecond),
+	}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	defer os.RemoveAll(db.getMergePath())
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	for i := 0; i < 150; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	stat, err := db.Stat()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(db.DirPath, data.MergeFinishedFileName))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	mergedStat, err := db.Stat()
+	require.NoError(t, err)
+	require.Less(t, mergedStat.DiskSize, stat.DiskSize)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 50, db.index.Size())
+	for i := 150; i < 200; i++ {
+		_, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestAutoMergeDisabledInReadOnly(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBIndexSnapshotInterval(-1))
+	require.NoError(t, err)
+	require.Nil(t, db.closeCh)
+	require.NoError(t, db.Put(utils.GetRandomKey(1), utils.GetRandomValue(10)))
+	require.NoError(t, db.Close())
+
+	db, err = Open(WithDBDirPath(dirPath), WithReadOnly(), WithDBMergeRatio(0.1))
+	require.NoError(t, err)
+	require.Nil(t, db.closeCh)
+	require.NoError(t, db.Close())
+}
@@ -4,9 +4,9 @@ import (
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/GGjahon/bitcask-kv/data"
-	"github.com/GGjahon/bitcask-kv/index"
 )
 
 const nonTransactionSeqNo uint64 = 0
@@ -20,11 +20,8 @@ type WriteBatch struct {
 	pendingWrites map[string]*data.LogRecord
 }
 
+// NewWriteBatch 创建批量写入实例，只读模式下该实例的所有写入操作均返回ErrReadOnly
 func (db *DB) NewWriteBatch(opts ...WriteBatchOption) *WriteBatch {
-	if db.IndexType == index.BPtree && !db.seqNoFExists && !db.isInitial {
-		panic("cannot use write batch , seq no file not exists")
-	}
-
 	writeBatch := &WriteBatch{
 		options: WriteBatchOptions{
 			MaxBatchNum: DefaultMaxBatchNum,
@@ -41,9 +38,24 @@ func (db *DB) NewWriteBatch(opts ...WriteBatchOption) *WriteBatch {
 	return writeBatch
 }
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	return wb.put(key, value, 0)
+}
+
+// PutWithTTL 将key - value暂存至批量写入中，提交后在ttl时间后过期
+func (wb *WriteBatch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return wb.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+func (wb *WriteBatch) put(key []byte, value []byte, expireAt int64) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if wb.db.ReadOnly {
+		return ErrReadOnly
+	}
 	if uint(len(wb.pendingWrites)) == wb.options.MaxBatchNum {
 		return ErrExceedMaxBatchNum
 	}
@@ -51,8 +63,9 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	defer wb.mu.Unlock()
 
 	logRecord := &data.LogRecord{
-		Key:   key,
-		Value: value,
+		Key:      key,
+		Value:    value,
+		ExpireAt: expireAt,
 	}
 	wb.pendingWrites[string(key)] = logRecord
 	return nil
@@ -61,6 +74,9 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if wb.db.ReadOnly {
+		return ErrReadOnly
+	}
 	//判断当前key是否存在于索引中
 	logRecordPos := wb.db.index.Get(key)
 	if logRecordPos == nil {
@@ -79,6 +95,9 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	return nil
 }
 func (wb *WriteBatch) Commit() error {
+	if wb.db.ReadOnly {
+		return ErrReadOnly
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 	if len(wb.pendingWrites) == 0 {
@@ -88,18 +107,27 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
-	// 获取当前事务序列号
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	return wb.db.write(wb.needSync(), wb.commit)
+}
 
+// needSync 提交时是否需要持久化
+func (wb *WriteBatch) needSync() bool {
+	return wb.options.SyncWrites || wb.db.SyncWrites
+}
+
+// commit 将预写数据写入数据文件并更新索引，调用方需持有wb.mu和db.mu，持久化由调用方完成
+func (wb *WriteBatch) commit() error {
+	// 获取当前事务序列号
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
 	//暂存插入数据的pos信息，待插入完成后再写入到内存
 	positions := make(map[string]*data.LogRecordPos)
 	for _, logRecord := range wb.pendingWrites {
 		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(logRecord.Key, seqNo),
-			Value: logRecord.Value,
-			Type:  logRecord.Type,
+			Key:         logRecordKeyWithSeq(logRecord.Key, seqNo),
+			Value:       logRecord.Value,
+			Type:        logRecord.Type,
+			ExpireAt:    logRecord.ExpireAt,
+			Compression: wb.db.Compression,
 		})
 		if err != nil {
 			return err
@@ -110,27 +138,34 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	_, err := wb.db.appendLogRecord(finishedRecord)
+	finishedPos, err := wb.db.appendLogRecord(finishedRecord)
 	if err != nil {
 		return err
 	}
-	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
-		}
-	}
 	// 完成索引信息的插入
+	keys := make([][]byte, 0, len(wb.pendingWrites))
+	indexPositions := make([]*data.LogRecordPos, 0, len(wb.pendingWrites))
 	for _, logRecord := range wb.pendingWrites {
-		key := logRecord.Key
-		pos := positions[string(key)]
-		if logRecord.Type == data.LogRecordNormal {
-			wb.db.index.Put(logRecord.Key, pos)
-		}
+		pos := positions[string(logRecord.Key)]
 		if logRecord.Type == data.LogRecordDeleted {
-			wb.db.index.Delete(key)
+			//删除标记本身在merge时也会被清理
+			wb.db.addReclaimSize(pos)
+			pos = nil
 		}
+		keys = append(keys, logRecord.Key)
+		indexPositions = append(indexPositions, pos)
 	}
+	oldPositions, err := wb.db.applyToIndex(keys, indexPositions, finishedPos)
+	if err != nil {
+		return err
+	}
+	for _, oldPos := range oldPositions {
+		if oldPos != nil {
+			wb.db.addReclaimSize(oldPos)
+		}
+	}
+	wb.db.addReclaimSize(finishedPos)
+	wb.db.oracle.track(keys...)
 
 	//清空预写数据
 	wb.pendingWrites = make(map[string]*data.LogRecord)
@@ -144,7 +179,9 @@ func logRecordKeyWithSeq(key []byte, seqNo uint64) []byte {
 	copy(encKey[n:], key)
 	return encKey
 }
-func parseLogRecordKey(key []byte) ([]byte, uint64) {
+
+// ParseLogRecordKey 解析数据文件中记录的key，返回真正的key及其事务序列号，非事务写入的序列号为0
+func ParseLogRecordKey(key []byte) ([]byte, uint64) {
 	seqNo, n := binary.Uvarint(key)
 	realKey := key[n:]
 	return realKey, seqNo
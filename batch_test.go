@@ -1,10 +1,15 @@
 package bitcaskkv
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/index"
 	"github.com/GGjahon/bitcask-kv/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBatchWithOption(t *testing.T) {
@@ -188,3 +193,61 @@ func TestBatchDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteBatchBPTreeReplay(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBIndexType(index.BPtree)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+
+	// B+树索引在首次打开和重启后均可以使用WriteBatch
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Put(utils.GetRandomKey(1), []byte("batch")))
+	require.NoError(t, wb.Put(utils.GetRandomKey(2), []byte("batch")))
+	require.NoError(t, wb.Commit())
+	require.NoError(t, db.Put(utils.GetRandomKey(3), []byte("put")))
+
+	// 模拟数据写入数据文件后、写入B+树索引前进程退出
+	db.mu.Lock()
+	seqNo := db.seqNo + 1
+	for _, logRecord := range []*data.LogRecord{
+		{Key: logRecordKeyWithSeq(utils.GetRandomKey(4), nonTransactionSeqNo), Value: []byte("lost")},
+		{Key: logRecordKeyWithSeq(utils.GetRandomKey(3), nonTransactionSeqNo), Type: data.LogRecordDeleted},
+		{Key: logRecordKeyWithSeq(utils.GetRandomKey(5), seqNo), Value: []byte("lost-batch")},
+		{Key: logRecordKeyWithSeq(txnFinKey, seqNo), Type: data.LogRecordTxnFinished},
+		// 未提交的事务数据不会被重放
+		{Key: logRecordKeyWithSeq(utils.GetRandomKey(6), seqNo+1), Value: []byte("uncommitted")},
+	} {
+		_, err := db.appendLogRecord(logRecord)
+		require.NoError(t, err)
+	}
+	db.mu.Unlock()
+	require.Nil(t, db.index.Get(utils.GetRandomKey(4)))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, seqNo+1, db.seqNo)
+	for i, expect := range []string{"", "batch", "batch", "", "lost", "lost-batch", ""} {
+		value, err := db.Get(utils.GetRandomKey(i))
+		if expect == "" {
+			require.ErrorIs(t, err, ErrKeyIsNotFound)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, []byte(expect), value)
+	}
+	// 重放完成后记录新的checkpoint，下次启动无需再次重放
+	cp := db.index.(*index.BPlusTree).Checkpoint()
+	require.Equal(t, db.activeFile.FileID, cp.Fid)
+	require.Equal(t, db.activeFile.WriteOff, cp.Offset)
+	require.Equal(t, db.seqNo, cp.SeqNo)
+
+	wb = db.NewWriteBatch()
+	require.NoError(t, wb.Put(utils.GetRandomKey(7), []byte("batch")))
+	require.NoError(t, wb.Commit())
+	require.Equal(t, seqNo+2, db.seqNo)
+	_, err = os.Stat(filepath.Join(dirPath, data.SeqNoFileName))
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, db.Close())
+}
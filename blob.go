@@ -0,0 +1,324 @@
+package bitcaskkv
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/index"
+	"github.com/GGjahon/bitcask-kv/utils"
+)
+
+// 大value单独存储在blob文件中，数据文件中只保留其在blob文件中的位置，merge时无需重写大value
+// blob文件中的无效数据由blob gc单独回收：将仍然有效的value重新写入，之后删除整个blob文件
+
+// loadBlobFiles 打开数据目录下的所有blob文件，id最大的blob文件作为活跃blob文件继续写入
+func (db *DB) loadBlobFiles() error {
+	entries, err := os.ReadDir(db.DirPath)
+	if err != nil {
+		return err
+	}
+	var fileIds []int
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.BlobFileSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.BlobFileSuffix))
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Ints(fileIds)
+	// blob文件只会按位置读取，启动时无需扫描，只读模式下使用mmap，不修改文件
+	ioType := fio.StandardFIO
+	if db.ReadOnly {
+		ioType = fio.MemoryMap
+	}
+	for _, fid := range fileIds {
+		blobFile, err := data.OpenBlobFile(db.DirPath, uint32(fid), ioType, db.ioOptions()...)
+		if err != nil {
+			return err
+		}
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			_ = blobFile.Close()
+			return err
+		}
+		// 进程退出时写入不完整的blob记录不会被任何数据引用，之后的写入直接追加即可
+		blobFile.WriteOff = size
+		db.blobFiles[uint32(fid)] = blobFile
+		db.activeBlobFile = blobFile
+	}
+	return nil
+}
+
+// isBlobValue 判断写入的value是否需要存储在blob文件中
+func (db *DB) isBlobValue(logRecord *data.LogRecord) bool {
+	return db.BlobThreshold > 0 && logRecord.Type == data.LogRecordNormal && int64(len(logRecord.Value)) >= db.BlobThreshold
+}
+
+// appendBlobRecord 将数据写入活跃blob文件，返回数据文件中替代该数据的记录，调用方需持有db.mu
+func (db *DB) appendBlobRecord(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	encBlobRecord, blobRecordSize := data.EnCodeLogRecord(logRecord)
+	if db.activeBlobFile == nil || db.activeBlobFile.WriteOff > 0 && db.activeBlobFile.WriteOff+blobRecordSize > db.MaxDataFileSize {
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encBlobRecord); err != nil {
+		return nil, err
+	}
+	db.blobDirty = true
+	db.bytesWrite += uint(blobRecordSize)
+	return &data.LogRecord{
+		Key: logRecord.Key,
+		Value: data.EncodeBlobPointer(&data.BlobPointer{
+			Fid:    db.activeBlobFile.FileID,
+			Offset: writeOff,
+			Size:   uint32(blobRecordSize),
+		}),
+		Type:      data.LogRecordBlob,
+		Timestamp: logRecord.Timestamp,
+		ExpireAt:  logRecord.ExpireAt,
+	}, nil
+}
+
+// setActiveBlobFile 持久化当前的活跃blob文件，并打开新的活跃blob文件
+func (db *DB) setActiveBlobFile() error {
+	var fileId uint32 = 0
+	if db.activeBlobFile != nil {
+		if err := db.syncActiveBlobFile(); err != nil {
+			return err
+		}
+		fileId = db.activeBlobFile.FileID + 1
+	}
+	blobFile, err := data.OpenBlobFile(db.DirPath, fileId, fio.StandardFIO, db.ioOptions()...)
+	if err != nil {
+		return err
+	}
+	if err := utils.SyncDir(db.DirPath); err != nil {
+		_ = blobFile.Close()
+		return err
+	}
+	db.blobFiles[fileId] = blobFile
+	db.activeBlobFile = blobFile
+	return nil
+}
+
+// syncActiveBlobFile 持久化活跃blob文件，调用方需持有db.mu
+func (db *DB) syncActiveBlobFile() error {
+	if !db.blobDirty {
+		return nil
+	}
+	if err := db.activeBlobFile.Sync(); err != nil {
+		return err
+	}
+	db.blobDirty = false
+	return nil
+}
+
+// newLogRecordPos 构建数据文件中记录的位置，value存储在blob文件中时同时记录其在blob文件中的位置
+func newLogRecordPos(fid uint32, offset int64, size int64, logRecord *data.LogRecord) *data.LogRecordPos {
+	pos := &data.LogRecordPos{
+		Fid:      fid,
+		Offset:   offset,
+		ExpireAt: logRecord.ExpireAt,
+		Size:     uint32(size),
+	}
+	if logRecord.Type == data.LogRecordBlob {
+		ptr := data.DecodeBlobPointer(logRecord.Value)
+		pos.BlobFid, pos.BlobOffset, pos.BlobSize = ptr.Fid, ptr.Offset, ptr.Size
+	}
+	return pos
+}
+
+// readBlobValue 从blob文件中读取value
+func readBlobValue(blobFiles map[uint32]*data.DataFile, ptr *data.BlobPointer) ([]byte, error) {
+	blobFile := blobFiles[ptr.Fid]
+	if blobFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	encBlobRecord, _, blobRecordHeader, err := blobFile.Get(ptr.Offset)
+	if err != nil {
+		return nil, err
+	}
+	blobRecord, err := data.DecodeLogRecord(encBlobRecord, blobRecordHeader)
+	if err != nil {
+		return nil, err
+	}
+	if isExpired(blobRecord.ExpireAt, time.Now().UnixNano()) {
+		return nil, ErrKeyIsNotFound
+	}
+	return blobRecord.Value, nil
+}
+
+// startBlobGC 启动后台goroutine，定期回收无效数据占比达到阈值的blob文件
+func (db *DB) startBlobGC() {
+	if db.BlobGCRatio <= 0 || db.ReadOnly {
+		return
+	}
+	db.runInBackground(db.BlobGCInterval, func() {
+		// 回收失败时等待下一次检查即可，不影响前台读写
+		_ = db.BlobGC()
+	})
+}
+
+// blobEntry blob文件中仍然有效的一条数据
+type blobEntry struct {
+	key []byte
+	pos *data.LogRecordPos
+}
+
+// BlobGC 回收已封存的blob文件中的无效数据，无效数据占比达到BlobGCRatio的blob文件中的有效数据会被重新写入，之后删除该文件
+// BlobGCRatio为0时回收所有包含无效数据的blob文件，期间db可以正常读写
+func (db *DB) BlobGC() error {
+	if db.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	if db.isBlobGCing {
+		db.mu.Unlock()
+		return ErrBlobGCIsProgress
+	}
+	db.isBlobGCing = true
+	defer func() {
+		db.mu.Lock()
+		db.isBlobGCing = false
+		db.mu.Unlock()
+	}()
+	idx := db.index.Snapshot()
+	blobFileSizes := make(map[uint32]int64, len(db.blobFiles))
+	for fid, blobFile := range db.blobFiles {
+		if blobFile == db.activeBlobFile {
+			continue
+		}
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			db.mu.Unlock()
+			_ = idx.Close()
+			return err
+		}
+		blobFileSizes[fid] = size
+	}
+	db.mu.Unlock()
+
+	gcFiles := collectBlobGCFiles(idx, blobFileSizes, db.BlobGCRatio)
+	_ = idx.Close()
+	fids := make([]uint32, 0, len(gcFiles))
+	for fid := range gcFiles {
+		fids = append(fids, fid)
+	}
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+	for _, fid := range fids {
+		if err := db.rewriteBlobFile(fid, gcFiles[fid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectBlobGCFiles 统计各blob文件中有效数据的大小，返回需要回收的blob文件及其中的有效数据
+func collectBlobGCFiles(idx index.Index, blobFileSizes map[uint32]int64, ratio float32) map[uint32][]*blobEntry {
+	liveEntries := make(map[uint32][]*blobEntry)
+	liveSizes := make(map[uint32]int64)
+	now := time.Now().UnixNano()
+	iter := idx.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		pos := iter.Value()
+		if pos.BlobSize == 0 || isExpired(pos.ExpireAt, now) {
+			continue
+		}
+		if _, ok := blobFileSizes[pos.BlobFid]; !ok {
+			continue
+		}
+		liveEntries[pos.BlobFid] = append(liveEntries[pos.BlobFid], &blobEntry{key: iter.Key(), pos: pos})
+		liveSizes[pos.BlobFid] += int64(pos.BlobSize)
+	}
+
+	gcFiles := make(map[uint32][]*blobEntry)
+	for fid, size := range blobFileSizes {
+		garbageSize := size - liveSizes[fid]
+		if garbageSize <= 0 || float32(garbageSize)/float32(size) < ratio {
+			continue
+		}
+		gcFiles[fid] = liveEntries[fid]
+	}
+	return gcFiles
+}
+
+// rewriteBlobFile 将blob文件中的有效数据重新写入，持久化后删除该blob文件
+func (db *DB) rewriteBlobFile(fid uint32, entries []*blobEntry) error {
+	db.mu.RLock()
+	blobFile := db.blobFiles[fid]
+	db.mu.RUnlock()
+	if blobFile == nil {
+		return nil
+	}
+	for _, entry := range entries {
+		encBlobRecord, _, blobRecordHeader, err := blobFile.Get(entry.pos.BlobOffset)
+		if err != nil {
+			return err
+		}
+		blobRecord, err := data.DecodeLogRecord(encBlobRecord, blobRecordHeader)
+		if err != nil {
+			return err
+		}
+		if err := db.write(false, func() error {
+			// 统计之后被重新写入或删除的key无需处理
+			pos := db.index.Get(entry.key)
+			if pos == nil || pos.BlobSize == 0 || pos.BlobFid != fid || pos.BlobOffset != entry.pos.BlobOffset {
+				return nil
+			}
+			// 按当前配置重新写入，value可能写入新的blob文件，也可能直接写入数据文件
+			newPos, err := db.appendLogRecord(&data.LogRecord{
+				Key:         logRecordKeyWithSeq(entry.key, nonTransactionSeqNo),
+				Value:       blobRecord.Value,
+				Type:        data.LogRecordNormal,
+				Timestamp:   blobRecord.Timestamp,
+				ExpireAt:    pos.ExpireAt,
+				Compression: db.Compression,
+			})
+			if err != nil {
+				return err
+			}
+			oldPositions, err := db.applyToIndex([][]byte{entry.key}, []*data.LogRecordPos{newPos}, newPos)
+			if err != nil {
+				return err
+			}
+			if oldPositions[0] != nil {
+				db.addReclaimSize(oldPositions[0])
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 重新写入的数据持久化之后才能删除blob文件
+	if db.activeFile != nil {
+		if err := db.syncActiveFile(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(data.GetBlobFileName(db.DirPath, fid)); err != nil {
+		return err
+	}
+	delete(db.blobFiles, fid)
+	db.obsoleteFiles = append(db.obsoleteFiles, blobFile)
+	if err := utils.SyncDir(db.DirPath); err != nil {
+		return err
+	}
+	//存在未释放的快照时，该blob文件可能仍在被读取，待快照全部释放后再关闭
+	if db.snapshotNum == 0 {
+		return db.closeObsoleteFiles()
+	}
+	return nil
+}
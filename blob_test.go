@@ -0,0 +1,142 @@
+package bitcaskkv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobValue(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(64 * 1024), WithDBBlobThreshold(1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	largeValues := make(map[int][]byte)
+	for i := 0; i < 100; i++ {
+		largeValues[i] = utils.GetRandomValue(4 * 1024)
+		require.NoError(t, db.Put(utils.GetRandomKey(i), largeValues[i]))
+	}
+	for i := 100; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("jahoon")))
+	}
+	// 大value存储在blob文件中，数据文件中只有其位置
+	require.Less(t, db.activeFile.WriteOff, int64(64*1024))
+	require.Greater(t, len(db.blobFiles), 1)
+	pos := db.index.Get(utils.GetRandomKey(0))
+	require.NotZero(t, pos.BlobSize)
+	require.Zero(t, db.index.Get(utils.GetRandomKey(100)).BlobSize)
+
+	// 批量写入同样写入blob文件
+	wb := db.NewWriteBatch()
+	largeValues[200] = utils.GetRandomValue(2 * 1024)
+	require.NoError(t, wb.Put(utils.GetRandomKey(200), largeValues[200]))
+	require.NoError(t, wb.Commit())
+
+	// merge不重写blob文件
+	blobFileInfo, err := os.Stat(data.GetBlobFileName(dirPath, 0))
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(100+i)))
+	}
+	require.NoError(t, db.Merge())
+	newBlobFileInfo, err := os.Stat(data.GetBlobFileName(dirPath, 0))
+	require.NoError(t, err)
+	require.Equal(t, blobFileInfo.ModTime(), newBlobFileInfo.ModTime())
+	checkBlobValues(t, db, largeValues)
+	stat, err := db.Stat()
+	require.NoError(t, err)
+	require.Equal(t, uint(len(db.blobFiles)), stat.BlobFileNum)
+	require.NoError(t, db.Close())
+
+	// 从索引快照、hint文件及数据文件重新加载
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	checkBlobValues(t, db, largeValues)
+	require.NoError(t, db.Close())
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	checkBlobValues(t, db, largeValues)
+	require.NoError(t, db.Close())
+}
+
+func TestBlobFilesWithoutDataFile(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBBlobThreshold(1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	require.NoError(t, db.Put(utils.GetRandomKey(0), utils.GetRandomValue(4*1024)))
+	require.NoError(t, db.Close())
+
+	// 只有blob文件而没有数据文件时，活跃文件不存在，关闭时不应panic
+	require.NoError(t, os.Remove(data.GetDataFileName(dirPath, 0)))
+	require.NoError(t, os.RemoveAll(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Nil(t, db.activeFile)
+	require.Len(t, db.blobFiles, 1)
+	require.NoError(t, db.Close())
+}
+
+func checkBlobValues(t *testing.T, db *DB, largeValues map[int][]byte) {
+	for i, largeValue := range largeValues {
+		value, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+		require.Equal(t, largeValue, value)
+	}
+}
+
+func TestBlobGC(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(64 * 1024), WithDBBlobThreshold(1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	largeValues := make(map[int][]byte)
+	for i := 0; i < 100; i++ {
+		largeValues[i] = utils.GetRandomValue(4 * 1024)
+		require.NoError(t, db.Put(utils.GetRandomKey(i), largeValues[i]))
+	}
+	// 覆盖写入及删除的value成为blob文件中的无效数据
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("jahoon")))
+		largeValues[i] = []byte("jahoon")
+	}
+	snap := db.Snapshot()
+	for i := 50; i < 60; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+		delete(largeValues, i)
+	}
+	gcFid := snap.index.Get(utils.GetRandomKey(55)).BlobFid
+	liveFid := db.index.Get(utils.GetRandomKey(80)).BlobFid
+	require.NotEqual(t, gcFid, db.activeBlobFile.FileID)
+	require.NotEqual(t, liveFid, db.activeBlobFile.FileID)
+
+	require.NoError(t, db.BlobGC())
+	// 包含无效数据的blob文件被回收，只包含有效数据的blob文件保留
+	_, err = os.Stat(data.GetBlobFileName(dirPath, gcFid))
+	require.True(t, os.IsNotExist(err))
+	require.Nil(t, db.blobFiles[gcFid])
+	_, err = os.Stat(data.GetBlobFileName(dirPath, liveFid))
+	require.NoError(t, err)
+	checkBlobValues(t, db, largeValues)
+	// 快照依然可以读取被回收的blob文件
+	value, err := snap.Get(utils.GetRandomKey(55))
+	require.NoError(t, err)
+	require.Len(t, value, 4*1024)
+	snap.Release()
+	require.NoError(t, db.Close())
+
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	checkBlobValues(t, db, largeValues)
+	// 没有无效数据时无需回收
+	blobFileNum := len(db.blobFiles)
+	require.NoError(t, db.BlobGC())
+	require.Equal(t, blobFileNum, len(db.blobFiles))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,440 @@
+// bitcask-cli 查看及管理db目录的命令行工具
+//
+//	bitcask-cli [-dir /tmp/bitcask-kv] [-key <hex>] <command> [arguments]
+//
+// 支持的命令：
+//
+//	get <key>                                读取key对应的value
+//	put [-ttl 1h] <key> <value>              写入key - value
+//	del <key>                                删除key
+//	scan [--prefix p] [--reverse] [--limit n] [--keys-only]
+//	                                         按顺序遍历key - value
+//	merge                                    立即进行一次merge
+//	stat                                     输出db的统计信息
+//	verify                                   校验所有文件中记录的crc及索引的正确性，发现问题时以非0状态退出
+//	repair                                   隔离数据文件中无法读取的数据，使db可以重新打开，输出丢失的key
+//	dump-file [--blob] [--values] <fid>      依次解码数据文件（或blob文件）中的每条记录，输出其位置、类型、事务序列号及crc校验结果
+//	dump-hint [fid]                          输出merge生成的hint文件，指定fid时输出该数据文件的hint文件
+//
+// get、scan、stat、verify及dump命令以只读方式访问db，可以在db被其他进程只读打开时使用
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// cli 命令执行时共用的参数
+type cli struct {
+	dirPath       string
+	encryptionKey []byte
+	out           io.Writer
+}
+
+var commands = map[string]func(c *cli, args []string) error{
+	"get":       (*cli).get,
+	"put":       (*cli).put,
+	"del":       (*cli).del,
+	"scan":      (*cli).scan,
+	"merge":     (*cli).merge,
+	"stat":      (*cli).stat,
+	"verify":    (*cli).verify,
+	"repair":    (*cli).repair,
+	"dump-file": (*cli).dumpFile,
+	"dump-hint": (*cli).dumpHint,
+}
+
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("bitcask-cli", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dirPath := flags.String("dir", bitcaskkv.DefaultDirPath, "db directory")
+	keyHex := flags.String("key", "", "encryption key in hex, empty if not encrypted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: bitcask-cli [-dir dir] [-key hex] get|put|del|scan|merge|stat|verify|repair|dump-file|dump-hint [arguments]")
+	}
+	encryptionKey, err := hex.DecodeString(*keyHex)
+	if err != nil {
+		return fmt.Errorf("invalid encryption key: %w", err)
+	}
+	command := commands[flags.Arg(0)]
+	if command == nil {
+		return fmt.Errorf("unknown command %q", flags.Arg(0))
+	}
+	c := &cli{dirPath: *dirPath, encryptionKey: encryptionKey, out: out}
+	return command(c, flags.Args()[1:])
+}
+
+// open 打开db，readOnly为true时以只读方式打开
+func (c *cli) open(readOnly bool) (*bitcaskkv.DB, error) {
+	if _, err := os.Stat(c.dirPath); err != nil {
+		return nil, err
+	}
+	opts := []bitcaskkv.DBOption{bitcaskkv.WithDBDirPath(c.dirPath), bitcaskkv.WithDBEncryptionKey(c.encryptionKey)}
+	if readOnly {
+		opts = append(opts, bitcaskkv.WithReadOnly())
+	}
+	return bitcaskkv.Open(opts...)
+}
+
+// parseArgs 解析子命令的参数，要求剩余的位置参数个数为n
+func parseArgs(flags *flag.FlagSet, args []string, n int, usage string) error {
+	flags.Usage = func() {}
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("usage: %s", usage)
+	}
+	if flags.NArg() != n {
+		return fmt.Errorf("usage: %s", usage)
+	}
+	return nil
+}
+
+func (c *cli) get(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	if err := parseArgs(flags, args, 1, "get <key>"); err != nil {
+		return err
+	}
+	db, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	value, err := db.Get([]byte(flags.Arg(0)))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "%s\n", value)
+	return err
+}
+
+func (c *cli) put(args []string) error {
+	flags := flag.NewFlagSet("put", flag.ContinueOnError)
+	ttl := flags.Duration("ttl", 0, "expire the key after ttl")
+	if err := parseArgs(flags, args, 2, "put [-ttl duration] <key> <value>"); err != nil {
+		return err
+	}
+	db, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	key, value := []byte(flags.Arg(0)), []byte(flags.Arg(1))
+	if *ttl > 0 {
+		err = db.PutWithTTL(key, value, *ttl)
+	} else {
+		err = db.Put(key, value)
+	}
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+func (c *cli) del(args []string) error {
+	flags := flag.NewFlagSet("del", flag.ContinueOnError)
+	if err := parseArgs(flags, args, 1, "del <key>"); err != nil {
+		return err
+	}
+	db, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	if err := db.Delete([]byte(flags.Arg(0))); err != nil {
+		_ = db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+func (c *cli) scan(args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "only scan keys with the prefix")
+	reverse := flags.Bool("reverse", false, "scan in descending order")
+	limit := flags.Int("limit", 0, "max number of keys to output, 0 means no limit")
+	keysOnly := flags.Bool("keys-only", false, "only output keys")
+	if err := parseArgs(flags, args, 0, "scan [--prefix p] [--reverse] [--limit n] [--keys-only]"); err != nil {
+		return err
+	}
+	db, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	opts := []bitcaskkv.IterOption{bitcaskkv.WithIterPrefix([]byte(*prefix))}
+	if *reverse {
+		opts = append(opts, bitcaskkv.WithIterReverse())
+	}
+	iter := db.NewIterator(opts...)
+	defer iter.Close()
+	count := 0
+	for iter.Rewind(); iter.Valid() && (*limit <= 0 || count < *limit); iter.Next() {
+		count++
+		if *keysOnly {
+			fmt.Fprintf(c.out, "%q\n", iter.Key())
+			continue
+		}
+		value, err := iter.Value()
+		if err != nil {
+			return fmt.Errorf("read value of key %q: %w", iter.Key(), err)
+		}
+		fmt.Fprintf(c.out, "%q\t%q\n", iter.Key(), value)
+	}
+	return nil
+}
+
+func (c *cli) merge(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	if err := parseArgs(flags, args, 0, "merge"); err != nil {
+		return err
+	}
+	db, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	if err := db.Merge(); err != nil {
+		_ = db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+func (c *cli) stat(args []string) error {
+	flags := flag.NewFlagSet("stat", flag.ContinueOnError)
+	if err := parseArgs(flags, args, 0, "stat"); err != nil {
+		return err
+	}
+	db, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	stat, err := db.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "keys:             %d\n", stat.KeyNum)
+	fmt.Fprintf(c.out, "data files:       %d\n", stat.DataFileNum)
+	fmt.Fprintf(c.out, "blob files:       %d\n", stat.BlobFileNum)
+	fmt.Fprintf(c.out, "reclaimable size: %d\n", stat.ReclaimableSize)
+	fmt.Fprintf(c.out, "disk size:        %d\n", stat.DiskSize)
+	return nil
+}
+
+func (c *cli) verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	if err := parseArgs(flags, args, 0, "verify"); err != nil {
+		return err
+	}
+	db, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	report, err := db.Verify(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, problem := range report.Problems {
+		fmt.Fprintln(c.out, problem)
+	}
+	fmt.Fprintf(c.out, "files=%d records=%d index_entries=%d problems=%d\n",
+		report.Files, report.Records, report.IndexEntries, len(report.Problems))
+	if !report.OK() {
+		return fmt.Errorf("found %d problems in %s", len(report.Problems), c.dirPath)
+	}
+	return nil
+}
+
+func (c *cli) repair(args []string) error {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	if err := parseArgs(flags, args, 0, "repair"); err != nil {
+		return err
+	}
+	report, err := bitcaskkv.Repair(c.dirPath, bitcaskkv.WithDBEncryptionKey(c.encryptionKey))
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(c.out, report)
+	return nil
+}
+
+// openFile 以只读方式打开db目录下的文件，文件不存在时返回错误而不是创建该文件
+func (c *cli) openFile(fileName string, open func(ioType fio.FileIOType, opts ...fio.IOOption) (*data.DataFile, error)) (*data.DataFile, error) {
+	if _, err := os.Stat(fileName); err != nil {
+		return nil, err
+	}
+	var opts []fio.IOOption
+	if len(c.encryptionKey) > 0 {
+		opts = append(opts, fio.WithEncryptionKey(c.encryptionKey))
+	}
+	return open(fio.MemoryMap, opts...)
+}
+
+// dumpFile 依次解码数据文件中的每条记录，存在crc校验失败的记录时返回错误
+func (c *cli) dumpFile(args []string) error {
+	flags := flag.NewFlagSet("dump-file", flag.ContinueOnError)
+	blob := flags.Bool("blob", false, "dump the blob file instead of the data file")
+	values := flags.Bool("values", false, "output values")
+	if err := parseArgs(flags, args, 1, "dump-file [--blob] [--values] <fid>"); err != nil {
+		return err
+	}
+	fid, err := strconv.ParseUint(flags.Arg(0), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file id %q", flags.Arg(0))
+	}
+	fileName, openFile := data.GetDataFileName(c.dirPath, uint32(fid)), data.OpenDataFile
+	if *blob {
+		fileName, openFile = data.GetBlobFileName(c.dirPath, uint32(fid)), data.OpenBlobFile
+	}
+	dataFile, err := c.openFile(fileName, func(ioType fio.FileIOType, opts ...fio.IOOption) (*data.DataFile, error) {
+		return openFile(c.dirPath, uint32(fid), ioType, opts...)
+	})
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+
+	var offset int64
+	var records, corrupted int
+	for {
+		encLogRecord, size, header, err := dataFile.Get(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// 无法确定记录的长度，之后的数据无法继续解码
+			fmt.Fprintf(c.out, "offset=%d error=%q\n", offset, err)
+			corrupted++
+			break
+		}
+		records++
+		logRecord, err := data.DecodeLogRecord(encLogRecord, header)
+		if err != nil {
+			fmt.Fprintf(c.out, "offset=%d size=%d type=%s crc=invalid error=%q\n", offset, size, recordTypeName(header.Type()), err)
+			corrupted++
+			offset += size
+			continue
+		}
+		key, seqNo := bitcaskkv.ParseLogRecordKey(logRecord.Key)
+		fmt.Fprintf(c.out, "offset=%d size=%d type=%s seq=%d crc=ok key=%q", offset, size, recordTypeName(logRecord.Type), seqNo, key)
+		switch {
+		case logRecord.Type == data.LogRecordBlob:
+			ptr := data.DecodeBlobPointer(logRecord.Value)
+			fmt.Fprintf(c.out, " blob_fid=%d blob_offset=%d blob_size=%d", ptr.Fid, ptr.Offset, ptr.Size)
+		case *values:
+			fmt.Fprintf(c.out, " value=%q", logRecord.Value)
+		default:
+			fmt.Fprintf(c.out, " value_size=%d", len(logRecord.Value))
+		}
+		if logRecord.ExpireAt > 0 {
+			fmt.Fprintf(c.out, " expire_at=%d", logRecord.ExpireAt)
+		}
+		fmt.Fprintln(c.out)
+		offset += size
+	}
+	fmt.Fprintf(c.out, "records=%d corrupted=%d end=%d\n", records, corrupted, offset)
+	if corrupted > 0 {
+		return fmt.Errorf("found %d corrupted records in %s", corrupted, fileName)
+	}
+	return nil
+}
+
+// dumpHint 输出hint文件中每个key对应的数据位置
+func (c *cli) dumpHint(args []string) error {
+	flags := flag.NewFlagSet("dump-hint", flag.ContinueOnError)
+	flags.Usage = func() {}
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		return errors.New("usage: dump-hint [fid]")
+	}
+	// 数据文件的hint文件中保存的是带事务序列号的key，merge生成的hint文件中保存的是真正的key
+	fileName := filepath.Join(c.dirPath, data.HintFileName)
+	open := func(ioType fio.FileIOType, opts ...fio.IOOption) (*data.DataFile, error) {
+		return data.OpenHintFile(c.dirPath, ioType, opts...)
+	}
+	withSeqNo := flags.NArg() == 1
+	if withSeqNo {
+		fid, err := strconv.ParseUint(flags.Arg(0), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid file id %q", flags.Arg(0))
+		}
+		fileName = data.GetDataHintFileName(c.dirPath, uint32(fid))
+		open = func(ioType fio.FileIOType, opts ...fio.IOOption) (*data.DataFile, error) {
+			return data.OpenDataHintFile(c.dirPath, uint32(fid), ioType, opts...)
+		}
+	}
+	hintFile, err := c.openFile(fileName, open)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	var offset int64
+	var records int
+	for {
+		encHintRecord, size, header, err := hintFile.Get(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read hint record at offset %d: %w", offset, err)
+		}
+		hintRecord, err := data.DecodeLogRecord(encHintRecord, header)
+		if err != nil {
+			return fmt.Errorf("decode hint record at offset %d: %w", offset, err)
+		}
+		records++
+		pos := data.DecCodeLogRecordPos(hintRecord.Value)
+		key := hintRecord.Key
+		if withSeqNo {
+			var seqNo uint64
+			key, seqNo = bitcaskkv.ParseLogRecordKey(key)
+			fmt.Fprintf(c.out, "type=%s seq=%d ", recordTypeName(hintRecord.Type), seqNo)
+		}
+		fmt.Fprintf(c.out, "key=%q fid=%d offset=%d size=%d", key, pos.Fid, pos.Offset, pos.Size)
+		if pos.BlobSize > 0 {
+			fmt.Fprintf(c.out, " blob_fid=%d blob_offset=%d blob_size=%d", pos.BlobFid, pos.BlobOffset, pos.BlobSize)
+		}
+		if pos.ExpireAt > 0 {
+			fmt.Fprintf(c.out, " expire_at=%d", pos.ExpireAt)
+		}
+		fmt.Fprintln(c.out)
+		offset += size
+	}
+	fmt.Fprintf(c.out, "records=%d\n", records)
+	return nil
+}
+
+func recordTypeName(typ data.LogRecordType) string {
+	switch typ {
+	case data.LogRecordNormal:
+		return "normal"
+	case data.LogRecordDeleted:
+		return "deleted"
+	case data.LogRecordTxnFinished:
+		return "txn-fin"
+	case data.LogRecordBlob:
+		return "blob"
+	default:
+		return strconv.Itoa(int(typ))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func runCli(t *testing.T, dirPath string, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(append([]string{"-dir", dirPath}, args...), &out)
+	return out.String(), err
+}
+
+func TestCli(t *testing.T) {
+	dirPath := t.TempDir()
+	_, err := runCli(t, dirPath, "put", "user:1", "jahoon")
+	require.NoError(t, err)
+	_, err = runCli(t, dirPath, "put", "-ttl", "1h", "user:2", "other")
+	require.NoError(t, err)
+	_, err = runCli(t, dirPath, "put", "order:1", "value")
+	require.NoError(t, err)
+	_, err = runCli(t, dirPath, "del", "order:1")
+	require.NoError(t, err)
+
+	out, err := runCli(t, dirPath, "get", "user:1")
+	require.NoError(t, err)
+	require.Equal(t, "jahoon\n", out)
+	_, err = runCli(t, dirPath, "get", "order:1")
+	require.ErrorIs(t, err, bitcaskkv.ErrKeyIsNotFound)
+	out, err = runCli(t, dirPath, "scan", "--prefix", "user:", "--reverse")
+	require.NoError(t, err)
+	require.Equal(t, "\"user:2\"\t\"other\"\n\"user:1\"\t\"jahoon\"\n", out)
+	out, err = runCli(t, dirPath, "stat")
+	require.NoError(t, err)
+	require.Contains(t, out, "keys:             2\n")
+
+	out, err = runCli(t, dirPath, "dump-file", "--values", "0")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 5)
+	require.Equal(t, `offset=0 size=30 type=normal seq=0 crc=ok key="user:1" value="jahoon"`, lines[0])
+	require.Contains(t, lines[1], "expire_at=")
+	require.Contains(t, lines[3], `type=deleted seq=0 crc=ok key="order:1"`)
+	require.Equal(t, "records=4 corrupted=0 end=122", lines[4])
+
+	// crc校验失败的记录
+	fileName := data.GetDataFileName(dirPath, 0)
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	buf[20] ^= 0xff
+	require.NoError(t, os.WriteFile(fileName, buf, 0644))
+	out, err = runCli(t, dirPath, "dump-file", "0")
+	require.Error(t, err)
+	require.Contains(t, out, "offset=0 size=30 type=normal crc=invalid")
+	require.Contains(t, out, "records=4 corrupted=1 end=122")
+
+	_, err = runCli(t, dirPath, "dump-file", "1")
+	require.True(t, os.IsNotExist(err))
+	_, err = runCli(t, dirPath, "unknown")
+	require.Error(t, err)
+}
+
+func TestCliDumpHint(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := bitcaskkv.Open(bitcaskkv.WithDBDirPath(dirPath), bitcaskkv.WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(100)))
+	}
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Put(utils.GetRandomKey(0), utils.GetRandomValue(100)))
+	require.NoError(t, wb.Commit())
+	require.NoError(t, db.Close())
+
+	// 数据文件的hint文件在db关闭前生成
+	out, err := runCli(t, dirPath, "dump-hint", "0")
+	require.NoError(t, err)
+	require.Contains(t, out, `type=normal seq=0 key="bitcask-kv-key000000000" fid=0 offset=0`)
+
+	_, err = runCli(t, dirPath, "merge")
+	require.NoError(t, err)
+	out, err = runCli(t, dirPath, "dump-hint")
+	require.NoError(t, err)
+	require.Contains(t, out, "records=100\n")
+	require.NotContains(t, out, "seq=")
+
+	out, err = runCli(t, dirPath, "verify")
+	require.NoError(t, err)
+	require.Contains(t, out, "index_entries=100 problems=0\n")
+	// 修改merge后数据文件中的一个字节
+	fileName := data.GetDataFileName(dirPath, 0)
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	buf[len(buf)-1]++
+	require.NoError(t, os.WriteFile(fileName, buf, 0644))
+	out, err = runCli(t, dirPath, "verify")
+	require.Error(t, err)
+	require.Contains(t, out, "000000000.data offset=")
+	require.Contains(t, out, data.ErrorInvalidCRC.Error())
+
+	out, err = runCli(t, dirPath, "repair")
+	require.NoError(t, err)
+	require.Contains(t, out, "repaired 000000000.data\n")
+	require.Contains(t, out, "lost key=")
+	out, err = runCli(t, dirPath, "verify")
+	require.NoError(t, err)
+	require.Contains(t, out, "index_entries=99 problems=0\n")
+}
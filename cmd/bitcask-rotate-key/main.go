@@ -0,0 +1,39 @@
+// bitcask-rotate-key 使用新的密钥重新加密db目录下的文件，执行期间db不能被打开
+//
+//	bitcask-rotate-key -dir /tmp/bitcask-kv -old-key <hex> -new-key <hex>
+//
+// 密钥以十六进制表示，old-key为空表示原文件未加密，new-key为空表示解密为明文
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+)
+
+func main() {
+	dirPath := flag.String("dir", bitcaskkv.DefaultDirPath, "db directory")
+	oldKeyHex := flag.String("old-key", "", "current encryption key in hex, empty if not encrypted")
+	newKeyHex := flag.String("new-key", "", "new encryption key in hex, empty to decrypt")
+	flag.Parse()
+
+	oldKey, err := hex.DecodeString(*oldKeyHex)
+	if err != nil {
+		exit(fmt.Errorf("invalid old key: %w", err))
+	}
+	newKey, err := hex.DecodeString(*newKeyHex)
+	if err != nil {
+		exit(fmt.Errorf("invalid new key: %w", err))
+	}
+	if err := bitcaskkv.RotateEncryptionKey(*dirPath, oldKey, newKey); err != nil {
+		exit(err)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
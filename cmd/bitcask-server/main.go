@@ -0,0 +1,54 @@
+// bitcask-server 兼容redis RESP2协议的服务端，可直接使用redis客户端访问db
+//
+//	bitcask-server -addr :6379 -dir /tmp/bitcask-kv
+//
+// 支持GET、SET（EX/PX/NX/XX）、DEL、EXISTS、TYPE、KEYS、SCAN、MULTI/EXEC/DISCARD及PING等命令，
+// 以及Hash（HSET/HGET/HDEL）、Set（SADD/SISMEMBER/SREM）、List（LPUSH/RPOP/LRANGE）、ZSet（ZADD/ZSCORE/ZRANGEBYSCORE）
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+	"github.com/GGjahon/bitcask-kv/redis"
+)
+
+func main() {
+	addr := flag.String("addr", ":6379", "listen address")
+	dirPath := flag.String("dir", bitcaskkv.DefaultDirPath, "db directory")
+	syncWrites := flag.Bool("sync", false, "sync every write to disk")
+	flag.Parse()
+
+	db, err := bitcaskkv.Open(bitcaskkv.WithDBDirPath(*dirPath), bitcaskkv.WithDBSync(*syncWrites))
+	if err != nil {
+		exit(err)
+	}
+	server := redis.NewServer(db)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		_ = server.Close()
+	}()
+
+	log.Printf("bitcask-server is listening on %s, db directory %s", *addr, *dirPath)
+	if err := server.ListenAndServe(*addr); err != redis.ErrServerClosed {
+		_ = db.Close()
+		exit(err)
+	}
+	// 所有连接关闭后再关闭db，保证已回复的写入全部持久化
+	if err := db.Close(); err != nil {
+		exit(err)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+var (
+	ErrorUnknownCompression = errors.New("unknown compression type of the log record")
+	ErrorInvalidCompressed  = errors.New("the compressed value is invalid")
+)
+
+// CompressionType value的压缩方式，记录在LogRecord header中，同一文件中可以混合存放不同压缩方式的数据
+type CompressionType = byte
+
+const (
+	NoCompression CompressionType = iota
+	FlateCompression
+	LZCompression
+
+	maxCompressionType = LZCompression
+)
+
+// compressValue 使用指定的压缩方式压缩value，压缩后没有变小时不进行压缩
+func compressValue(compression CompressionType, value []byte) ([]byte, CompressionType) {
+	if len(value) == 0 {
+		return value, NoCompression
+	}
+	var compressed []byte
+	switch compression {
+	case FlateCompression:
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		_, _ = w.Write(value)
+		_ = w.Close()
+		compressed = buf.Bytes()
+	case LZCompression:
+		compressed = lzCompress(value)
+	default:
+		return value, NoCompression
+	}
+	if len(compressed) >= len(value) {
+		return value, NoCompression
+	}
+	return compressed, compression
+}
+
+// decompressValue 解压缩value
+func decompressValue(compression CompressionType, value []byte) ([]byte, error) {
+	switch compression {
+	case NoCompression:
+		return value, nil
+	case FlateCompression:
+		r := flate.NewReader(bytes.NewReader(value))
+		defer r.Close()
+		decompressed, err := io.ReadAll(r)
+		if err != nil {
+			return nil, ErrorInvalidCompressed
+		}
+		return decompressed, nil
+	case LZCompression:
+		return lzDecompress(value)
+	default:
+		return nil, ErrorUnknownCompression
+	}
+}
+
+// IsValidCompression 判断是否为支持的压缩方式
+func IsValidCompression(compression CompressionType) bool {
+	return compression <= maxCompressionType
+}
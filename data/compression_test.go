@@ -0,0 +1,76 @@
+package data
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLZCompress(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	testCases := []struct {
+		name  string
+		value []byte
+	}{
+		{name: "empty", value: []byte{}},
+		{name: "short", value: []byte("abc")},
+		{name: "random", value: random},
+		{name: "run", value: bytes.Repeat([]byte("a"), 1000)},
+		{name: "json", value: []byte(strings.Repeat(`{"name":"jahoon","age":18,"tags":["bitcask","kv"]},`, 100))},
+		{name: "long literals", value: append(append([]byte{}, random[:300]...), bytes.Repeat(random[:64], 20)...)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			compressed := lzCompress(tc.value)
+			decompressed, err := lzDecompress(compressed)
+			require.NoError(t, err)
+			require.Equal(t, len(tc.value), len(decompressed))
+			require.True(t, bytes.Equal(tc.value, decompressed))
+		})
+	}
+
+	json := []byte(strings.Repeat(`{"name":"jahoon","age":18}`, 100))
+	compressed := lzCompress(json)
+	require.Less(t, len(compressed)*5, len(json))
+	_, err := lzDecompress(compressed[:len(compressed)/2])
+	require.ErrorIs(t, err, ErrorInvalidCompressed)
+}
+
+func TestLogRecordCompression(t *testing.T) {
+	value := []byte(strings.Repeat(`{"name":"jahoon","age":18}`, 100))
+	for _, compression := range []CompressionType{NoCompression, FlateCompression, LZCompression} {
+		logRecord := &LogRecord{
+			Key:         []byte("name"),
+			Value:       value,
+			Type:        LogRecordDeleted,
+			Compression: compression,
+		}
+		encLogRecord, size := EnCodeLogRecord(logRecord)
+		if compression == NoCompression {
+			require.Greater(t, size, int64(len(value)))
+		} else {
+			require.Less(t, size, int64(len(value)))
+		}
+		header := decodeLogRecordHeader(encLogRecord)
+		require.Equal(t, LogRecordDeleted, header.recordType)
+		require.Equal(t, compression, header.compression)
+		decoded, err := DecodeLogRecord(encLogRecord, header)
+		require.NoError(t, err)
+		require.Equal(t, value, decoded.Value)
+		require.Equal(t, LogRecordDeleted, decoded.Type)
+	}
+
+	// 压缩后没有变小的value不进行压缩
+	encLogRecord, _ := EnCodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("jahoon"), Compression: LZCompression})
+	header := decodeLogRecordHeader(encLogRecord)
+	require.Equal(t, NoCompression, header.compression)
+
+	// 未知的压缩方式
+	encLogRecord[4] |= 0xf0
+	_, err := DecodeLogRecord(encLogRecord, decodeLogRecordHeader(encLogRecord))
+	require.ErrorIs(t, err, ErrorUnknownCompression)
+}
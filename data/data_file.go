@@ -16,9 +16,15 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
+	DataHintFileSuffix    = ".hint"
+	BlobFileSuffix        = ".blob"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	IndexSnapshotFileName = "index-snapshot"
+	QuarantineFileSuffix  = ".quarantine"
+	RepairReportFileName  = "repair-report"
+	tempFileSuffix        = ".tmp"
 )
 
 type DataFile struct {
@@ -27,30 +33,75 @@ type DataFile struct {
 	IoManager fio.IOManager
 }
 
-func OpenDataFile(dirpath string, fileId uint32) (*DataFile, error) {
+func OpenDataFile(dirpath string, fileId uint32, ioType fio.FileIOType, opts ...fio.IOOption) (*DataFile, error) {
 	fileName := GetDataFileName(dirpath, fileId)
-	return openFile(fileName, fileId)
+	return openFile(fileName, fileId, ioType, opts...)
 }
 func GetDataFileName(dirpath string, fileId uint32) string {
 	return filepath.Join(dirpath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
-func OpenHintFile(dirpath string) (*DataFile, error) {
+
+// 已封存数据文件对应的hint文件，记录该数据文件中每条数据的key和位置
+func OpenDataHintFile(dirpath string, fileId uint32, ioType fio.FileIOType, opts ...fio.IOOption) (*DataFile, error) {
+	return openFile(GetDataHintFileName(dirpath, fileId), fileId, ioType, opts...)
+}
+
+// 写入hint文件时使用的临时文件，写入完成后重命名为hint文件，加密时以hint文件名进行认证
+func OpenDataHintTempFile(dirpath string, fileId uint32, opts ...fio.IOOption) (*DataFile, error) {
+	opts = append(opts, fio.WithFrameFileName(filepath.Base(GetDataHintFileName(dirpath, fileId))))
+	return openFile(GetDataHintTempFileName(dirpath, fileId), fileId, fio.StandardFIO, opts...)
+}
+func GetDataHintFileName(dirpath string, fileId uint32) string {
+	return filepath.Join(dirpath, fmt.Sprintf("%09d", fileId)+DataHintFileSuffix)
+}
+func GetDataHintTempFileName(dirpath string, fileId uint32) string {
+	return GetDataHintFileName(dirpath, fileId) + tempFileSuffix
+}
+
+// 存储大value的blob文件，与数据文件使用各自的文件id
+func OpenBlobFile(dirpath string, fileId uint32, ioType fio.FileIOType, opts ...fio.IOOption) (*DataFile, error) {
+	return openFile(GetBlobFileName(dirpath, fileId), fileId, ioType, opts...)
+}
+func GetBlobFileName(dirpath string, fileId uint32) string {
+	return filepath.Join(dirpath, fmt.Sprintf("%09d", fileId)+BlobFileSuffix)
+}
+
+// 修复数据文件时，保存从该数据文件中移除的无法读取的数据
+func GetQuarantineFileName(dirpath string, fileId uint32) string {
+	return filepath.Join(dirpath, fmt.Sprintf("%09d", fileId)+QuarantineFileSuffix)
+}
+func OpenHintFile(dirpath string, ioType fio.FileIOType, opts ...fio.IOOption) (*DataFile, error) {
 	fileName := filepath.Join(dirpath, HintFileName)
-	return openFile(fileName, 0)
+	return openFile(fileName, 0, ioType, opts...)
 }
-func OpenMergeFinishedFile(dirpath string) (*DataFile, error) {
+func OpenMergeFinishedFile(dirpath string, ioType fio.FileIOType, opts ...fio.IOOption) (*DataFile, error) {
 	fileName := filepath.Join(dirpath, MergeFinishedFileName)
-	return openFile(fileName, 0)
+	return openFile(fileName, 0, ioType, opts...)
 }
 
 // 存储事务序列号文件
-func OpenSeqNoFile(dirpath string) (*DataFile, error) {
+func OpenSeqNoFile(dirpath string, ioType fio.FileIOType, opts ...fio.IOOption) (*DataFile, error) {
 	fileName := filepath.Join(dirpath, SeqNoFileName)
-	return openFile(fileName, 0)
+	return openFile(fileName, 0, ioType, opts...)
+}
+
+// 存储索引快照文件
+func OpenIndexSnapshotFile(dirpath string, ioType fio.FileIOType, opts ...fio.IOOption) (*DataFile, error) {
+	fileName := filepath.Join(dirpath, IndexSnapshotFileName)
+	return openFile(fileName, 0, ioType, opts...)
+}
+
+// 写入索引快照时使用的临时文件，写入完成后重命名为索引快照文件，加密时以索引快照文件名进行认证
+func OpenIndexSnapshotTempFile(dirpath string, opts ...fio.IOOption) (*DataFile, error) {
+	opts = append(opts, fio.WithFrameFileName(IndexSnapshotFileName))
+	return openFile(GetIndexSnapshotTempFileName(dirpath), 0, fio.StandardFIO, opts...)
+}
+func GetIndexSnapshotTempFileName(dirpath string) string {
+	return filepath.Join(dirpath, IndexSnapshotFileName+tempFileSuffix)
 }
 
-func openFile(fileName string, fileId uint32) (*DataFile, error) {
-	ioManager, err := fio.NewIoManager(fileName)
+func openFile(fileName string, fileId uint32, ioType fio.FileIOType, opts ...fio.IOOption) (*DataFile, error) {
+	ioManager, err := fio.NewIoManager(fileName, ioType, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -62,6 +113,19 @@ func openFile(fileName string, fileId uint32) (*DataFile, error) {
 	}, nil
 }
 
+// SetIOManager 关闭当前的IOManager，以指定的IO类型重新打开数据文件
+func (df *DataFile) SetIOManager(dirpath string, ioType fio.FileIOType, opts ...fio.IOOption) error {
+	if err := df.IoManager.Close(); err != nil {
+		return err
+	}
+	ioManager, err := fio.NewIoManager(GetDataFileName(dirpath, df.FileID), ioType, opts...)
+	if err != nil {
+		return err
+	}
+	df.IoManager = ioManager
+	return nil
+}
+
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
 }
@@ -82,8 +146,8 @@ func (df *DataFile) Get(offset int64) ([]byte, int64, *LogRecordHeader, error) {
 	if err != nil {
 		return nil, 0, nil, err
 	}
-	var headerBytes int64 = maxLogRecordHeaderSize
-	if offset+maxLogRecordHeaderSize > dataFileSize {
+	var headerBytes int64 = MaxLogRecordHeaderSize
+	if offset+MaxLogRecordHeaderSize > dataFileSize {
 		headerBytes = dataFileSize - offset
 	}
 
@@ -102,6 +166,10 @@ func (df *DataFile) Get(offset int64) ([]byte, int64, *LogRecordHeader, error) {
 		return nil, 0, nil, ErrorEmptyKeyInFile
 	}
 	var recordSize = int64(header.headerSize) + keySize + valueSize
+	// 数据未完整写入文件（如写入过程中进程崩溃）
+	if offset+recordSize > dataFileSize {
+		return nil, 0, nil, io.ErrUnexpectedEOF
+	}
 
 	encLogRecord, err := df.readNBytes(recordSize, offset)
 	if err != nil {
@@ -110,6 +178,16 @@ func (df *DataFile) Get(offset int64) ([]byte, int64, *LogRecordHeader, error) {
 
 	return encLogRecord, recordSize, header, nil
 }
+
+// Truncate 将数据文件截断至指定大小
+func (df *DataFile) Truncate(size int64) error {
+	if err := df.IoManager.Truncate(size); err != nil {
+		return err
+	}
+	df.WriteOff = size
+	return nil
+}
+
 func (df *DataFile) readNBytes(n int64, offset int64) (buf []byte, err error) {
 	buf = make([]byte, n)
 	_, err = df.IoManager.Read(buf, offset)
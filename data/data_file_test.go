@@ -1,18 +1,21 @@
 package data
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"testing"
 
+	"github.com/GGjahon/bitcask-kv/fio"
 	"github.com/stretchr/testify/require"
 )
 
 func TestOpenAndCloseDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0)
+	dataFile1, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
 	t.Log(os.TempDir())
 	require.NoError(t, err)
 	require.NotNil(t, dataFile1)
-	dataFile2, err := OpenDataFile(os.TempDir(), 1)
+	dataFile2, err := OpenDataFile(os.TempDir(), 1, fio.StandardFIO)
 	require.NoError(t, err)
 	require.NotNil(t, dataFile2)
 
@@ -23,7 +26,7 @@ func TestOpenAndCloseDataFile(t *testing.T) {
 	require.NoError(t, err)
 }
 func TestWriteData(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 2)
+	dataFile1, err := OpenDataFile(os.TempDir(), 2, fio.StandardFIO)
 	require.NoError(t, err)
 	require.NotNil(t, dataFile1)
 	err = dataFile1.Write([]byte{176, 207, 127, 237, 0, 8, 12, 110, 97, 109, 101, 106, 97, 104, 111, 111, 110})
@@ -71,7 +74,7 @@ func TestGetData(t *testing.T) {
 	var index int64
 	for i := range TestCases {
 		tc := TestCases[i]
-		dataFile, err := OpenDataFile(os.TempDir(), 3)
+		dataFile, err := OpenDataFile(os.TempDir(), 3, fio.StandardFIO)
 		require.NoError(t, err)
 		encLogRecord, size := EnCodeLogRecord(tc.logRecord)
 		err = dataFile.Write(encLogRecord)
@@ -94,3 +97,71 @@ func TestGetData(t *testing.T) {
 		// require.NoError(t, err)
 	}
 }
+
+func TestGetTornData(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0, fio.StandardFIO)
+	require.NoError(t, err)
+	defer dataFile.Close()
+
+	encLogRecord, size := EnCodeLogRecord(&LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("jahoon"),
+	})
+	require.NoError(t, dataFile.Write(encLogRecord))
+	require.NoError(t, dataFile.Write(encLogRecord[:size-2]))
+
+	_, recordSize, _, err := dataFile.Get(0)
+	require.NoError(t, err)
+	require.Equal(t, size, recordSize)
+	_, _, _, err = dataFile.Get(size)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	require.NoError(t, dataFile.Truncate(size))
+	require.Equal(t, size, dataFile.WriteOff)
+	_, _, _, err = dataFile.Get(size)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+// BenchmarkDataFileGet 启动时顺序读取整个数据文件，比较标准文件IO与mmap的读取速度
+func BenchmarkDataFileGet(b *testing.B) {
+	dirPath := b.TempDir()
+	dataFile, err := OpenDataFile(dirPath, 0, fio.StandardFIO)
+	require.NoError(b, err)
+	value := make([]byte, 128)
+	for i := 0; i < 10000; i++ {
+		encLogRecord, _ := EnCodeLogRecord(&LogRecord{
+			Key:   []byte(fmt.Sprintf("bitcask-key-%09d", i)),
+			Value: value,
+		})
+		require.NoError(b, dataFile.Write(encLogRecord))
+	}
+	require.NoError(b, dataFile.Close())
+
+	for _, ioType := range []struct {
+		name string
+		typ  fio.FileIOType
+	}{{"StandardFIO", fio.StandardFIO}, {"MemoryMap", fio.MemoryMap}} {
+		b.Run(ioType.name, func(b *testing.B) {
+			dataFile, err := OpenDataFile(dirPath, 0, ioType.typ)
+			require.NoError(b, err)
+			defer dataFile.Close()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				//BenchmarkDataFileGet/StandardFIO     50    31144780 ns/op    4640319 B/op    40001 allocs/op
+				//BenchmarkDataFileGet/MemoryMap       50     3010144 ns/op    2560000 B/op    30000 allocs/op
+				var offset int64
+				for {
+					_, size, _, err := dataFile.Get(offset)
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+					offset += size
+				}
+			}
+		})
+	}
+}
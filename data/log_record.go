@@ -17,52 +17,95 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	// LogRecordBlob value存储在blob文件中，数据文件中的value为其在blob文件中的位置
+	LogRecordBlob
 )
 
 // LogRecordPos : the index of key in memory. LogRecordPos describe the position of data position in disk
 type LogRecordPos struct {
-	Fid    uint32 // the id of file in disk
-	Offset int64  // the offset of data in the file
+	Fid      uint32 // the id of file in disk
+	Offset   int64  // the offset of data in the file
+	ExpireAt int64  // the expire time of data(unix nano), 0 means never expire
+	Size     uint32 // the size of the encoded log record in disk
+	// value存储在blob文件中时，blob记录所在的文件、偏移及大小，BlobSize为0表示value存储在数据文件中
+	BlobFid    uint32
+	BlobOffset int64
+	BlobSize   uint32
+}
+
+// BlobPointer blob记录在blob文件中的位置
+type BlobPointer struct {
+	Fid    uint32
+	Offset int64
+	Size   uint32
 }
 
 // LogRecord the data to write in disk
-// 编码后的组成： crc校验(4字节) + recordType(1字节) + keySize(5变长字节) + valueSize(5变长字节)
-const maxLogRecordHeaderSize = binary.MaxVarintLen32 + binary.MaxVarintLen32 + 5
+// 编码后的组成： crc校验(4字节) + recordType(低3位)、格式标志(第4位)与compression(高4位)(1字节) + keySize(5变长字节)
+// + valueSize(5变长字节) + timestamp(10变长字节) + expireAt(10变长字节)
+// 格式标志为0的记录由旧版本写入，header中只有crc、recordType、keySize及valueSize，没有压缩，timestamp与expireAt均为0
+const MaxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64*2 + 5
+
+const (
+	recordTypeMask = 0x07
+	// extendedHeaderFlag header中包含timestamp、expireAt及压缩方式
+	extendedHeaderFlag = 0x08
+)
 
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key         []byte
+	Value       []byte // 未压缩的value，编码时根据Compression进行压缩
+	Type        LogRecordType
+	Timestamp   int64           // 写入时间(unix nano)
+	ExpireAt    int64           // 过期时间(unix nano)，为0表示永不过期
+	Compression CompressionType // value的压缩方式
 }
+
 type TransactionRecord struct {
 	Record *LogRecord
 	Pos    *LogRecordPos
 }
 
 type LogRecordHeader struct {
-	crc        uint32
-	recordType LogRecordType
-	headerSize uint32
-	keySize    uint32
-	valueSize  uint32
+	crc         uint32
+	recordType  LogRecordType
+	compression CompressionType
+	headerSize  uint32
+	keySize     uint32
+	valueSize   uint32
+	timestamp   int64
+	expireAt    int64
+}
+
+// Type 记录的类型，crc校验失败时依然可以获取
+func (h *LogRecordHeader) Type() LogRecordType {
+	return h.recordType
 }
 
 // EnCodeLogRecord 将LogRecord进行编码，返回byte数组和数组长度
 func EnCodeLogRecord(LogRecord *LogRecord) ([]byte, int64) {
+	// 压缩value，压缩后没有变小的value不进行压缩
+	// blob记录的位置不进行压缩，加载索引时无需解压缩即可读取
+	value, compression := LogRecord.Value, NoCompression
+	if LogRecord.Type != LogRecordBlob {
+		value, compression = compressValue(LogRecord.Compression, LogRecord.Value)
+	}
 	// 构建header数组
-	header := make([]byte, maxLogRecordHeaderSize)
-	header[4] = LogRecord.Type
+	header := make([]byte, MaxLogRecordHeaderSize)
+	header[4] = LogRecord.Type | extendedHeaderFlag | compression<<4
 	var index = 5
 	index += binary.PutVarint(header[index:], int64(len(LogRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(LogRecord.Value)))
+	index += binary.PutVarint(header[index:], int64(len(value)))
+	index += binary.PutVarint(header[index:], LogRecord.Timestamp)
+	index += binary.PutVarint(header[index:], LogRecord.ExpireAt)
 
-	encLogRecordSize := index + len(LogRecord.Key) + len(LogRecord.Value)
+	encLogRecordSize := index + len(LogRecord.Key) + len(value)
 	//构造encLogecord数组
 	encLogRecord := make([]byte, encLogRecordSize)
 	//将header复制进encLogRecord
 	copy(encLogRecord[:index], header[:index])
 	copy(encLogRecord[index:], LogRecord.Key)
-	copy(encLogRecord[(index+len(LogRecord.Key)):], LogRecord.Value)
+	copy(encLogRecord[(index+len(LogRecord.Key)):], value)
 
 	//校验
 	crc := crc32.ChecksumIEEE(encLogRecord[4:])
@@ -72,12 +115,31 @@ func EnCodeLogRecord(LogRecord *LogRecord) ([]byte, int64) {
 
 }
 
+// DecodeLogRecord 解码LogRecord并校验crc，value为解压缩后的数据
 func DecodeLogRecord(buf []byte, header *LogRecordHeader) (*LogRecord, error) {
+	logRecord, err := DecodeLogRecordWithoutValue(buf, header)
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Value, err = decompressValue(header.compression, logRecord.Value); err != nil {
+		return nil, err
+	}
+	return logRecord, nil
+}
+
+// DecodeLogRecordWithoutValue 解码LogRecord并校验crc，不对value进行解压缩，用于只需要key和位置信息的场景
+func DecodeLogRecordWithoutValue(buf []byte, header *LogRecordHeader) (*LogRecord, error) {
 	if header == nil {
 		return nil, ErrorInvalidHeader
 	}
+	if !IsValidCompression(header.compression) {
+		return nil, ErrorUnknownCompression
+	}
 	logRecord := &LogRecord{
-		Type: header.recordType,
+		Type:        header.recordType,
+		Timestamp:   header.timestamp,
+		ExpireAt:    header.expireAt,
+		Compression: header.compression,
 	}
 	index := int64(header.headerSize)
 
@@ -97,21 +159,41 @@ func DecodeLogRecord(buf []byte, header *LogRecordHeader) (*LogRecord, error) {
 	}
 	return logRecord, nil
 }
+
+// DecodeLogRecordKey 不校验crc，直接返回记录中的key，用于从损坏的记录中尽量找回key
+func DecodeLogRecordKey(buf []byte, header *LogRecordHeader) []byte {
+	return buf[header.headerSize : header.headerSize+header.keySize]
+}
+
 func decodeLogRecordHeader(buf []byte) *LogRecordHeader {
 	if len(buf) < 5 {
 		return nil
 	}
 	header := &LogRecordHeader{
-		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		crc:         binary.LittleEndian.Uint32(buf[:4]),
+		recordType:  buf[4] & recordTypeMask,
+		compression: buf[4] >> 4,
 	}
 	var index = 5
-	keySize, n := binary.Varint(buf[index:])
-	header.keySize = uint32(keySize)
-	index += n
-	valueSize, n := binary.Varint(buf[index:])
-	header.valueSize = uint32(valueSize)
-	index += n
+	// 依次解码 keySize valueSize timestamp expireAt，任意一项解码失败说明header不完整
+	var fields [4]int64
+	numFields := len(fields)
+	// 旧版本写入的header只有keySize及valueSize
+	if buf[4]&extendedHeaderFlag == 0 {
+		numFields = 2
+	}
+	for i := range fields[:numFields] {
+		v, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil
+		}
+		fields[i] = v
+		index += n
+	}
+	header.keySize = uint32(fields[0])
+	header.valueSize = uint32(fields[1])
+	header.timestamp = fields[2]
+	header.expireAt = fields[3]
 	header.headerSize = uint32(index)
 
 	return header
@@ -135,19 +217,71 @@ func EncPosLogRecordWithKeyAndPos(key []byte, pos *LogRecordPos) []byte {
 
 }
 func EncCodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*4+binary.MaxVarintLen64*3)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], pos.ExpireAt)
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	// value存储在blob文件中时才记录blob的位置
+	if pos.BlobSize > 0 {
+		index += binary.PutVarint(buf[index:], int64(pos.BlobFid))
+		index += binary.PutVarint(buf[index:], pos.BlobOffset)
+		index += binary.PutVarint(buf[index:], int64(pos.BlobSize))
+	}
 	return buf[:index]
 }
 func DecCodeLogRecordPos(buf []byte) *LogRecordPos {
 	var index = 0
 	fid, n := binary.Varint(buf[index:])
 	index += n
-	offset, _ := binary.Varint(buf[index:])
-	return &LogRecordPos{
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	pos := &LogRecordPos{
+		Fid:    uint32(fid),
+		Offset: offset,
+	}
+	// 兼容未记录过期时间的旧索引数据
+	if index < len(buf) {
+		pos.ExpireAt, n = binary.Varint(buf[index:])
+		index += n
+	}
+	// 兼容未记录数据大小的旧索引数据
+	if index < len(buf) {
+		size, n := binary.Varint(buf[index:])
+		index += n
+		pos.Size = uint32(size)
+	}
+	if index < len(buf) {
+		blobFid, n := binary.Varint(buf[index:])
+		index += n
+		pos.BlobOffset, n = binary.Varint(buf[index:])
+		index += n
+		blobSize, _ := binary.Varint(buf[index:])
+		pos.BlobFid, pos.BlobSize = uint32(blobFid), uint32(blobSize)
+	}
+	return pos
+}
+
+// EncodeBlobPointer 编码blob记录的位置，作为数据文件中LogRecordBlob类型记录的value
+func EncodeBlobPointer(ptr *BlobPointer) []byte {
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(ptr.Fid))
+	index += binary.PutVarint(buf[index:], ptr.Offset)
+	index += binary.PutVarint(buf[index:], int64(ptr.Size))
+	return buf[:index]
+}
+func DecodeBlobPointer(buf []byte) *BlobPointer {
+	var index = 0
+	fid, n := binary.Varint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	size, _ := binary.Varint(buf[index:])
+	return &BlobPointer{
 		Fid:    uint32(fid),
 		Offset: offset,
+		Size:   uint32(size),
 	}
 }
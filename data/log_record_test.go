@@ -1,6 +1,8 @@
 package data
 
 import (
+	"encoding/binary"
+	"hash/crc32"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -40,6 +42,23 @@ func TestLogRecord(t *testing.T) {
 				require.Equal(t, logRecord.Type, afterLogRecord.Type)
 			},
 		},
+		{
+			name: "with timestamp and expire",
+			logRecord: &LogRecord{
+				Key:       []byte("name"),
+				Value:     []byte("jahoon"),
+				Type:      LogRecordNormal,
+				Timestamp: 1700000000000000000,
+				ExpireAt:  1700000000000000000 + 60*1e9,
+			},
+			check: func(t *testing.T, logRecord *LogRecord, afterLogRecord *LogRecord, err error) {
+				require.NoError(t, err)
+				require.Equal(t, logRecord.Key, afterLogRecord.Key)
+				require.Equal(t, logRecord.Value, afterLogRecord.Value)
+				require.Equal(t, logRecord.Timestamp, afterLogRecord.Timestamp)
+				require.Equal(t, logRecord.ExpireAt, afterLogRecord.ExpireAt)
+			},
+		},
 		{
 			name: "crc is not equal",
 			logRecord: &LogRecord{
@@ -104,3 +123,44 @@ func TestLogRecord(t *testing.T) {
 
 	}
 }
+
+func TestEncCodeLogRecordPos(t *testing.T) {
+	pos := &LogRecordPos{Fid: 3, Offset: 1024, ExpireAt: 1700000000000000000, Size: 56}
+	require.Equal(t, pos, DecCodeLogRecordPos(EncCodeLogRecordPos(pos)))
+
+	// 兼容未记录过期时间的旧数据
+	legacy := EncCodeLogRecordPos(&LogRecordPos{Fid: 3, Offset: 1024})
+	require.Equal(t, &LogRecordPos{Fid: 3, Offset: 1024}, DecCodeLogRecordPos(legacy[:len(legacy)-2]))
+}
+
+func TestEncodeBlobPointer(t *testing.T) {
+	ptr := &BlobPointer{Fid: 7, Offset: 1 << 40, Size: 4 * 1024 * 1024}
+	require.Equal(t, ptr, DecodeBlobPointer(EncodeBlobPointer(ptr)))
+
+	// value存储在blob文件中时，位置信息中同时记录blob的位置
+	pos := &LogRecordPos{Fid: 3, Offset: 1024, Size: 56, BlobFid: 7, BlobOffset: 1 << 40, BlobSize: 4 * 1024 * 1024}
+	require.Equal(t, pos, DecCodeLogRecordPos(EncCodeLogRecordPos(pos)))
+
+	// blob位置不进行压缩
+	encRecord, _ := EnCodeLogRecord(&LogRecord{
+		Key:         []byte("jahoon"),
+		Value:       EncodeBlobPointer(ptr),
+		Type:        LogRecordBlob,
+		Compression: FlateCompression,
+	})
+	require.Equal(t, LogRecordBlob|extendedHeaderFlag, encRecord[4])
+}
+
+func TestDecodeLegacyLogRecord(t *testing.T) {
+	// 旧版本写入的记录：crc + type + keySize + valueSize，没有时间戳、过期时间及压缩方式
+	key, value := []byte("name"), []byte("jahoon")
+	buf := []byte{0, 0, 0, 0, LogRecordDeleted, byte(len(key) << 1), byte(len(value) << 1)}
+	buf = append(append(buf, key...), value...)
+	binary.LittleEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(buf[4:]))
+
+	header := decodeLogRecordHeader(buf)
+	require.Equal(t, uint32(7), header.headerSize)
+	logRecord, err := DecodeLogRecord(buf, header)
+	require.NoError(t, err)
+	require.Equal(t, &LogRecord{Key: key, Value: value, Type: LogRecordDeleted}, logRecord)
+}
@@ -0,0 +1,144 @@
+package data
+
+import "encoding/binary"
+
+// 内置的LZ77类压缩算法，格式与LZ4 block类似：
+// 原始数据长度(uvarint) + 若干个sequence
+// sequence：token(高4位为字面量长度，低4位为匹配长度-4) + [字面量长度扩展] + 字面量 + 匹配偏移(2字节) + [匹配长度扩展]
+// 最后一个sequence只包含字面量
+const (
+	lzMinMatch  = 4
+	lzHashLog   = 14
+	lzMaxOffset = 1<<16 - 1
+)
+
+func lzCompress(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, len(src)+len(src)/255+16)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(src)))]
+
+	// 记录每个4字节序列最近一次出现的位置+1，为0表示未出现过
+	var table [1 << lzHashLog]int32
+	anchor, i := 0, 0
+	for i+lzMinMatch <= len(src) {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 2654435761) >> (32 - lzHashLog)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > lzMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != seq {
+			i++
+			continue
+		}
+		matchLen := lzMinMatch
+		for i+matchLen < len(src) && src[candidate+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+		dst = lzAppendSequence(dst, src[anchor:i], i-candidate, matchLen)
+		i += matchLen
+		anchor = i
+	}
+	return lzAppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// lzAppendSequence 编码一个sequence，matchLen为0表示最后一个只包含字面量的sequence
+func lzAppendSequence(dst []byte, literals []byte, offset, matchLen int) []byte {
+	tokenPos := len(dst)
+	dst = append(dst, 0)
+	var token byte
+	if len(literals) >= 15 {
+		token = 0xf0
+		dst = lzAppendLength(dst, len(literals)-15)
+	} else {
+		token = byte(len(literals) << 4)
+	}
+	dst = append(dst, literals...)
+	if matchLen > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+		if ml := matchLen - lzMinMatch; ml >= 15 {
+			token |= 0x0f
+			dst = lzAppendLength(dst, ml-15)
+		} else {
+			token |= byte(ml)
+		}
+	}
+	dst[tokenPos] = token
+	return dst
+}
+
+func lzAppendLength(dst []byte, length int) []byte {
+	for length >= 255 {
+		dst = append(dst, 255)
+		length -= 255
+	}
+	return append(dst, byte(length))
+}
+
+func lzReadLength(src []byte, i int) (int, int, error) {
+	var length int
+	for {
+		if i >= len(src) {
+			return 0, 0, ErrorInvalidCompressed
+		}
+		b := src[i]
+		i++
+		length += int(b)
+		if b != 255 {
+			return length, i, nil
+		}
+	}
+}
+
+func lzDecompress(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, ErrorInvalidCompressed
+	}
+	dst := make([]byte, 0, size)
+	i := n
+	for i < len(src) {
+		token := src[i]
+		i++
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			ext, next, err := lzReadLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			litLen, i = litLen+ext, next
+		}
+		if i+litLen > len(src) || uint64(len(dst)+litLen) > size {
+			return nil, ErrorInvalidCompressed
+		}
+		dst = append(dst, src[i:i+litLen]...)
+		i += litLen
+		// 最后一个sequence只包含字面量
+		if i == len(src) {
+			break
+		}
+		if i+2 > len(src) {
+			return nil, ErrorInvalidCompressed
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		matchLen := int(token & 0x0f)
+		if matchLen == 15 {
+			ext, next, err := lzReadLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			matchLen, i = matchLen+ext, next
+		}
+		matchLen += lzMinMatch
+		if offset == 0 || offset > len(dst) || uint64(len(dst)+matchLen) > size {
+			return nil, ErrorInvalidCompressed
+		}
+		// 匹配区域可能与正在写入的区域重叠，需要逐字节复制
+		start := len(dst) - offset
+		for k := 0; k < matchLen; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, ErrorInvalidCompressed
+	}
+	return dst, nil
+}
@@ -1,84 +1,226 @@
 package bitcaskkv
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
 	"github.com/GGjahon/bitcask-kv/index"
+	"github.com/GGjahon/bitcask-kv/utils"
 )
 
-const seqNoKey = "seq-No"
+const (
+	fileLockName = "flock"
+)
 
 // DB is a implement of bitcask for user
 type DB struct {
 	Options
-	mu           *sync.RWMutex
-	index        index.Index
-	activeFile   *data.DataFile
-	fileIds      []int // 仅用于加载索引
-	olderFiles   map[uint32]*data.DataFile
-	seqNo        uint64
-	isMerging    bool //标识是否正在进行merge 同一深刻下仅可有一个merge线程
-	seqNoFExists bool //标识seqFile是否存在于db数据目录下
-	isInitial    bool
+	mu         *sync.RWMutex
+	index      index.Index
+	activeFile *data.DataFile
+	fileIds    []int // 仅用于加载索引
+	olderFiles map[uint32]*data.DataFile
+	seqNo      uint64
+	isMerging  bool //标识是否正在进行merge 同一深刻下仅可有一个merge线程
+	//启动时从活跃文件末尾截断的不完整数据的字节数
+	discardedTailBytes int64
+	//记录事务开始后被修改过的key，用于事务的冲突检测
+	oracle *txnOracle
+	//尚未释放的快照数量
+	snapshotNum int
+	//目录锁，保证同一时刻只有一个进程打开db
+	fileLock *fio.FileLock
+	//各数据文件中已失效(被覆盖或删除)的数据量，merge后可回收
+	reclaimSizes map[uint32]int64
+	//merge后不再使用的旧数据文件，待快照全部释放后关闭
+	obsoleteFiles []*data.DataFile
+	//merge完成替换的次数，用于判断后台保存的索引快照、hint文件是否已失效
+	mergeVersion uint64
+	//等待后台生成hint文件的goroutine退出
+	hintWg sync.WaitGroup
+	//保证hint文件的生成与merge替换数据文件互斥
+	hintMu sync.Mutex
+	//所有blob文件，包括活跃blob文件
+	blobFiles map[uint32]*data.DataFile
+	//当前写入大value的blob文件
+	activeBlobFile *data.DataFile
+	//活跃blob文件中存在尚未持久化的数据
+	blobDirty bool
+	//标识是否正在进行blob gc
+	isBlobGCing bool
+	//需要持久化的写入在此排队进行组提交
+	writeQueue *writeQueue
+	//组提交期间尚未应用到B+树索引的写入，数据文件持久化后统一应用
+	pendingIndex *pendingIndex
+	//活跃文件中尚未持久化的数据量
+	bytesWrite uint
+	//通知后台goroutine退出
+	closeCh chan struct{}
+	//等待后台goroutine退出
+	bgWg sync.WaitGroup
+}
+
+// Stat 存储引擎的统计信息
+type Stat struct {
+	KeyNum          uint  // key的总数量
+	DataFileNum     uint  // 数据文件的数量
+	BlobFileNum     uint  // blob文件的数量
+	ReclaimableSize int64 // 可以进行merge回收的数据量，字节为单位
+	DiskSize        int64 // 数据目录所占磁盘空间大小
 }
 
 func Open(opts ...DBOption) (*DB, error) {
 	db := DB{
-		Options:    Options{},
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		isInitial:  true,
+		Options:      Options{},
+		mu:           new(sync.RWMutex),
+		olderFiles:   make(map[uint32]*data.DataFile),
+		blobFiles:    make(map[uint32]*data.DataFile),
+		oracle:       newTxnOracle(),
+		writeQueue:   newWriteQueue(),
+		reclaimSizes: make(map[uint32]int64),
 	}
 	for _, opt := range opts {
 		opt(&db.Options)
 	}
 
 	repaireDB(&db.Options)
-	db.index = index.NewIndex(db.Options.IndexType, db.DirPath, db.SyncWrites)
+	if !data.IsValidCompression(db.Compression) {
+		return nil, ErrInvalidCompression
+	}
+	if err := checkEncryptionKey(db.EncryptionKey); err != nil {
+		return nil, err
+	}
+	// B+树索引文件中保存了明文的key，无法加密
+	if len(db.EncryptionKey) > 0 && db.IndexType == index.BPtree {
+		return nil, ErrEncryptionUnsupported
+	}
 	//判断用户输入的路径是否存在，若不存在，则帮用户创建该目录,若路径为db的默认路径，则无需创建
-	if db.Options.DirPath != DefaultDirPath {
+	//只读模式下不创建任何文件，目录必须已经存在
+	if db.ReadOnly {
+		if _, err := os.Stat(db.Options.DirPath); err != nil {
+			return nil, err
+		}
+	} else if db.Options.DirPath != DefaultDirPath {
 		if _, err := os.Stat(db.Options.DirPath); os.IsNotExist(err) {
 			if err := os.Mkdir(db.Options.DirPath, os.ModePerm); err != nil {
 				return nil, err
 			}
 		}
 	}
-	// 启动DB前，若目标目录中有老的 .data文件，需要加载至db。
-	// 先将merge文件夹的所有数据导入至bitcask-kv-data（存储db数据）的文件夹下
-	if err := db.loadMergeFiles(); err != nil {
+	// 获取目录锁，防止多个进程同时写入同一个db目录，只读模式下获取共享锁，可与其他只读进程共存
+	fileLock, err := fio.NewFileLock(filepath.Join(db.DirPath, fileLockName), db.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	if db.ReadOnly {
+		locked, err = fileLock.TryRLock()
+	} else {
+		locked, err = fileLock.TryLock()
+	}
+	if err != nil {
+		fileLock.Unlock()
 		return nil, err
 	}
+	if !locked {
+		fileLock.Unlock()
+		return nil, ErrDatabaseIsUsing
+	}
+	db.fileLock = fileLock
+
+	if err := db.load(); err != nil {
+		if db.index != nil {
+			_ = db.index.Close()
+		}
+		db.fileLock.Unlock()
+		return nil, err
+	}
+	if !db.ReadOnly {
+		db.writeMissingDataHints()
+	}
+	db.startAutoMerge()
+	db.startIndexSnapshot()
+	db.startBackgroundSync()
+	db.startBlobGC()
+	return &db, nil
+}
+
+// load 加载数据文件并构建索引
+func (db *DB) load() error {
+	if err := db.openIndex(); err != nil {
+		return err
+	}
+	// 启动DB前，若目标目录中有老的 .data文件，需要加载至db。
+	// 先将merge文件夹的所有数据导入至bitcask-kv-data（存储db数据）的文件夹下，只读模式下不进行任何修改
+	if !db.ReadOnly {
+		if err := db.loadMergeFiles(); err != nil {
+			return err
+		}
+		if err := db.remapBPTreeIndex(); err != nil {
+			return err
+		}
+	}
 
 	// 加载数据文件
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
 	}
-	// 若db的索引类型是B+树，则无需从hintFile/dataFile内加载索引，直接使用目标文件内存储的索引即可
+	if err := db.loadBlobFiles(); err != nil {
+		return err
+	}
+	// 若db的索引类型是B+树，则无需从hintFile/dataFile内加载全部索引，直接使用目标文件内存储的索引即可
 	if db.IndexType != index.BPtree {
-		// 循环读取dataFile前，若存在merge文件夹，则先读取hint文件，直接添加hint文件索引
-		// 在后续读取dataFile时直接跳过以及被merge的文件。
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
-		}
+		// 优先从索引快照中加载，只需继续加载快照保存之后写入的数据
+		if header := db.loadIndexFromSnapshot(); header != nil {
+			if err := db.loadIndexFromDataFiles(header.fid, header.offset); err != nil {
+				return err
+			}
+		} else {
+			// 循环读取dataFile前，若存在merge文件夹，则先读取hint文件，直接添加hint文件索引
+			// 在后续读取dataFile时直接跳过以及被merge的文件。
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return err
+			}
+			noMergeFileId, err := db.loadNoMergeFileId()
+			if err != nil {
+				return err
+			}
 
-		// 循环读取datafile，将key读取至索引，储存在内存中
-		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
+			// 循环读取datafile，将key读取至索引，储存在内存中
+			if err := db.loadIndexFromDataFiles(noMergeFileId, 0); err != nil {
+				return err
+			}
+		}
+	} else if db.ReadOnly {
+		// 只读模式下无法修改B+树索引，仍需确定活跃文件的写入位置
+		if err := db.loadActiveFileOffset(); err != nil {
+			return err
 		}
 	} else {
-		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+		// 重放checkpoint之后写入的数据，补全进程退出前未写入B+树索引的数据
+		if err := db.loadIndexFromCheckpoint(); err != nil {
+			return err
 		}
 	}
 
-	return &db, nil
+	// 索引加载完成后，若启动时使用了mmap，需要将数据文件的IO类型切换回标准文件IO，以便后续写入
+	// 只读模式下无需写入，继续使用mmap即可
+	if db.loadIOType() == fio.MemoryMap && !db.ReadOnly {
+		if err := db.resetIoType(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (db *DB) loadDataFiles() error {
@@ -104,9 +246,10 @@ func (db *DB) loadDataFiles() error {
 	sort.Ints(fileIds)
 	// 为了之后有序加载index，将排序后的fileIds添加到DB结构体中
 	db.fileIds = fileIds
-	db.isInitial = false
+	// 若开启了启动时mmap加载，则以内存映射的方式打开数据文件，加快索引的构建
+	ioType := db.loadIOType()
 	for i, fid := range fileIds {
-		dataFile, err := data.OpenDataFile(db.DirPath, uint32(fid))
+		dataFile, err := data.OpenDataFile(db.DirPath, uint32(fid), ioType, db.ioOptions()...)
 		if err != nil {
 			return err
 		}
@@ -120,136 +263,471 @@ func (db *DB) loadDataFiles() error {
 	return nil
 }
 
-func (db *DB) loadIndexFromDataFiles() error {
-	fileNums := len(db.fileIds)
-	if fileNums == 0 {
+// loadIOType 启动时加载数据文件使用的IO类型，只读模式下始终使用mmap
+func (db *DB) loadIOType() fio.FileIOType {
+	if db.MMapAtStartup || db.ReadOnly {
+		return fio.MemoryMap
+	}
+	return fio.StandardFIO
+}
+
+// ioOptions 打开db目录下的文件时使用的IO配置
+func (db *DB) ioOptions() []fio.IOOption {
+	return encryptionIOOptions(db.EncryptionKey)
+}
+
+// resetIoType 将所有数据文件的IO类型重置为标准文件IO
+func (db *DB) resetIoType() error {
+	if db.activeFile == nil {
 		return nil
 	}
-	//判断是否发生过merge ， 即查看是否存在mergeFinishedFile
-	hasMerge, noMergeDataFileId := false, uint32(0)
+	if err := db.activeFile.SetIOManager(db.DirPath, fio.StandardFIO, db.ioOptions()...); err != nil {
+		return err
+	}
+	for _, dataFile := range db.olderFiles {
+		if err := dataFile.SetIOManager(db.DirPath, fio.StandardFIO, db.ioOptions()...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadNoMergeFileId 判断是否发生过merge ， 即查看是否存在mergeFinishedFile，返回未参与merge的第一个文件id
+func (db *DB) loadNoMergeFileId() (uint32, error) {
 	mergeFFName := filepath.Join(db.Options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFFName); err == nil {
-		fid, err := db.getNoMergeFileId(db.DirPath)
-		if err != nil {
+	if _, err := os.Stat(mergeFFName); os.IsNotExist(err) {
+		return 0, nil
+	}
+	return db.getNoMergeFileId(db.DirPath)
+}
+
+// openIndex 创建索引，只读模式下B+树索引文件不存在时，改为在内存中从数据文件重建索引
+func (db *DB) openIndex() error {
+	idx, err := index.NewIndex(db.IndexType, db.DirPath, db.SyncWrites, db.ReadOnly)
+	if err == nil {
+		db.index = idx
+		return nil
+	}
+	if os.IsNotExist(err) {
+		db.IndexType = index.Btree
+		db.index = index.NewBTree()
+		return nil
+	}
+	return fmt.Errorf("%w: failed to open the b+ tree index: %v", ErrDataDirectoryCorrupted, err)
+}
+
+// loadIndexFromCheckpoint B+树索引持久化在磁盘中，只需从索引记录的checkpoint开始重放之后写入的数据，
+// 重放完成后记录新的checkpoint。未记录过checkpoint的旧版本索引文件，需要重放全部数据文件
+func (db *DB) loadIndexFromCheckpoint() error {
+	bpt := db.index.(*index.BPlusTree)
+	cp := bpt.Checkpoint()
+	if cp == nil {
+		cp = &index.Checkpoint{}
+	}
+	db.seqNo = cp.SeqNo
+	if err := db.loadIndexFromDataFiles(cp.Fid, cp.Offset); err != nil {
+		return err
+	}
+	if db.activeFile != nil {
+		//重放的数据可能尚未持久化，需要先于checkpoint持久化
+		if err := db.syncActiveFile(); err != nil {
 			return err
 		}
-		hasMerge = true
-		noMergeDataFileId = fid
+		bpt.Apply(nil, nil, &index.Checkpoint{Fid: db.activeFile.FileID, Offset: db.activeFile.WriteOff, SeqNo: db.seqNo})
+	}
+	// 事务序列号已记录在checkpoint中，旧版本遗留的seqNo文件不再需要
+	seqNoFileName := filepath.Join(db.DirPath, data.SeqNoFileName)
+	if _, err := os.Stat(seqNoFileName); err == nil {
+		return os.Remove(seqNoFileName)
+	}
+	return nil
+}
+
+// loadIndexFromDataFiles 从数据文件中加载索引，startFid之前的文件和startFid文件中startOffset之前的数据已加载过，直接跳过
+func (db *DB) loadIndexFromDataFiles(startFid uint32, startOffset int64) error {
+	fileNums := len(db.fileIds)
+	if fileNums == 0 {
+		return nil
+	}
+	// 活跃文件必须被加载，以确定其写入位置
+	if db.activeFile.FileID < startFid {
+		return ErrDataDirectoryCorrupted
 	}
+	now := time.Now().UnixNano()
 	updateIndex := func(key []byte, typ data.LogRecordType, logRecordPos *data.LogRecordPos) {
-		var ok bool
-		if typ == data.LogRecordDeleted {
-			ok = db.index.Delete(key)
+		var oldPos *data.LogRecordPos
+		// 已删除或已过期的数据均从索引中移除，key可能本就不存在于索引中，该条数据本身也是可回收的
+		if typ == data.LogRecordDeleted || isExpired(logRecordPos.ExpireAt, now) {
+			oldPos, _ = db.index.Delete(key)
+			db.addReclaimSize(logRecordPos)
 		} else {
-			ok = db.index.Put(key, logRecordPos)
+			oldPos = db.index.Put(key, logRecordPos)
 		}
-		if !ok {
-			panic("failed to update index at start up")
+		if oldPos != nil {
+			db.addReclaimSize(oldPos)
 		}
 	}
 	//若读取到通过事务提交的数据，则暂存在该map中
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = nonTransactionSeqNo
-	//遍历所有文件，处理文件中的记录，将key加载至index中
-	for i, fid := range db.fileIds {
+	var currentSeqNo = db.seqNo
+	applyLogRecord := func(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos) {
+		//解码从文件中读出数据的真正key
+		realKey, seqNo := ParseLogRecordKey(logRecord.Key)
+		if seqNo == nonTransactionSeqNo {
+			updateIndex(realKey, logRecord.Type, logRecordPos)
+		} else {
+			//若是通过事务提交的，将数据暂存在map数组中，等待读取到事务结束标志，再进行索引更新
+			if logRecord.Type == data.LogRecordTxnFinished {
+				//更新暂存数据的索引
+				for _, txnRecord := range transactionRecords[seqNo] {
+					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				}
+				//更新完成后删除map内的数据
+				delete(transactionRecords, seqNo)
+				//事务结束标志在索引中没有对应的key，直接计入可回收的数据量
+				db.addReclaimSize(logRecordPos)
+			} else {
+				//当前数据通过事务进行提交，还未读取到相应的结束标志，先暂存。
+				logRecord.Key = realKey
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record: logRecord,
+					Pos:    logRecordPos,
+				})
+			}
+		}
+		if seqNo > currentSeqNo {
+			currentSeqNo = seqNo
+		}
+	}
+
+	//需要加载的数据文件，fileId<startFid的文件此前已经从Hint文件或B+树索引中加载过索引了，无需再次加载
+	var dataFiles []*data.DataFile
+	for _, fid := range db.fileIds {
 		var fileId = uint32(fid)
-		// 若fileId<noMergeDataFileId,说明此前在Hint文件中已经加载过索引了，无需再次加载，直接跳过本次循环即可
-		if hasMerge && fileId < noMergeDataFileId {
+		if fileId < startFid {
 			continue
 		}
-		var dataFile *data.DataFile
 		if fileId == db.activeFile.FileID {
-			dataFile = db.activeFile
+			dataFiles = append(dataFiles, db.activeFile)
 		} else {
-			dataFile = db.olderFiles[fileId]
+			dataFiles = append(dataFiles, db.olderFiles[fileId])
 		}
-		var offset int64 = 0
-		for {
-			encLogRecord, size, logRecordHeader, err := dataFile.Get(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				} else {
-					return err
-				}
+	}
+
+	//多个goroutine并行解码数据文件，解码结果按照文件id的顺序依次更新至索引中，保证与顺序读取的结果一致
+	//已解码但尚未更新至索引的文件数量不超过workerNum，避免占用过多内存
+	workerNum := runtime.GOMAXPROCS(0)
+	results := make([]chan *decodedDataFile, len(dataFiles))
+	for i := range results {
+		results[i] = make(chan *decodedDataFile, 1)
+	}
+	tokens := make(chan struct{}, workerNum)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, dataFile := range dataFiles {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
 			}
-			logRecord, err := data.DecodeLogRecord(encLogRecord, logRecordHeader)
-			if err != nil {
-				return err
+			var offset int64 = 0
+			if dataFile.FileID == startFid {
+				offset = startOffset
 			}
-			//根据解码后的logRecord构建内存中将要存储的目标key-fileid,offset,存储至db的index中
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
+			go func(i int, dataFile *data.DataFile, offset int64) {
+				results[i] <- db.decodeDataFile(dataFile, offset, i == len(dataFiles)-1)
+			}(i, dataFile, offset)
+		}
+	}()
+
+	for i, dataFile := range dataFiles {
+		result := <-results[i]
+		<-tokens
+		if result.err != nil {
+			return result.err
+		}
+		for _, entry := range result.entries {
+			applyLogRecord(entry.record, entry.pos)
+		}
+
+		// 若读取到最后一个文件，即activeFile，需要截断末尾不完整的数据，并将该activeFile的offset写入db结构体内
+		if i == len(dataFiles)-1 {
+			if err := db.truncateTornTail(dataFile, result.endOffset); err != nil {
+				return err
 			}
-			//解码从文件中读出数据的真正key
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				//若是通过事务提交的，将数据暂存在map数组中，等待读取到事务结束标志，再进行索引更新
-				if logRecord.Type == data.LogRecordTxnFinished {
-					//更新暂存数据的索引
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					//更新完成后删除map内的数据
-					delete(transactionRecords, seqNo)
-				} else {
-					//当前数据通过事务进行提交，还未读取到相应的结束标志，先暂存。
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
+		}
+	}
+
+	db.seqNo = currentSeqNo
+
+	return nil
+}
+
+// decodedDataFile 一个数据文件的解码结果
+type decodedDataFile struct {
+	entries   []*hintEntry
+	endOffset int64 //最后一条完整数据的结束位置
+	err       error
+}
+
+// decodeDataFile 从offset开始解码数据文件中的数据，已封存的数据文件优先读取其hint文件
+func (db *DB) decodeDataFile(dataFile *data.DataFile, offset int64, isActive bool) *decodedDataFile {
+	result := &decodedDataFile{}
+	if offset > 0 {
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			result.err = err
+			return result
+		}
+		// 记录的加载位置超出了文件大小，说明已应用到索引中的数据丢失了
+		if offset > fileSize {
+			result.err = ErrDataDirectoryCorrupted
+			return result
+		}
+	}
+	// 已封存的数据文件无需读取完整的数据
+	if !isActive {
+		if entries := db.readDataHint(dataFile); entries != nil {
+			for _, entry := range entries {
+				if entry.pos.Offset >= offset {
+					result.entries = append(result.entries, entry)
 				}
 			}
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
+			return result
+		}
+	}
+	for {
+		encLogRecord, size, logRecordHeader, err := dataFile.Get(offset)
+		var logRecord *data.LogRecord
+		if err == nil {
+			logRecord, err = data.DecodeLogRecordWithoutValue(encLogRecord, logRecordHeader)
+		}
+		if err != nil {
+			// 最后一个文件末尾可能存在写入不完整的数据，读取到此处即可，之后进行截断
+			if isActive {
+				result.err = checkTornTail(dataFile, offset, size, err)
+				break
+			}
+			if err == io.EOF {
+				// 已封存的文件不应在文件末尾之前读取到EOF
+				result.err = checkDataFileEnd(dataFile, offset)
+				break
+			}
+			result.err = err
+			break
+		}
+		//构建索引中将要存储的位置信息，value无需保留
+		pos := newLogRecordPos(dataFile.FileID, offset, size, logRecord)
+		logRecord.Value = nil
+		result.entries = append(result.entries, &hintEntry{record: logRecord, pos: pos})
+		offset += size
+	}
+	result.endOffset = offset
+	return result
+}
+
+// loadActiveFileOffset 扫描活跃文件，获取其中最后一条完整数据的结束位置
+func (db *DB) loadActiveFileOffset() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	var offset int64 = 0
+	for {
+		encLogRecord, size, logRecordHeader, err := db.activeFile.Get(offset)
+		if err == nil {
+			_, err = data.DecodeLogRecordWithoutValue(encLogRecord, logRecordHeader)
+		}
+		if err != nil {
+			if err := checkTornTail(db.activeFile, offset, size, err); err != nil {
+				return err
 			}
+			break
+		}
+		offset += size
+	}
+	return db.truncateTornTail(db.activeFile, offset)
+}
 
-			offset += size
+// truncateTornTail 进程在写入过程中崩溃时，活跃文件末尾会残留不完整的数据，
+// 将文件截断至最后一条完整数据的结束位置validSize，并记录丢弃的字节数
+func (db *DB) truncateTornTail(dataFile *data.DataFile, validSize int64) error {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	// 只读模式下不修改文件，读取到最后一条完整的数据即可
+	if fileSize > validSize && !db.ReadOnly {
+		// mmap不支持截断，先切换为标准文件IO
+		if db.loadIOType() == fio.MemoryMap {
+			if err := dataFile.SetIOManager(db.DirPath, fio.StandardFIO, db.ioOptions()...); err != nil {
+				return err
+			}
+		}
+		if err := dataFile.Truncate(validSize); err != nil {
+			return err
 		}
+		db.discardedTailBytes = fileSize - validSize
+	}
+	dataFile.WriteOff = validSize
+	return nil
+}
+
+// addReclaimSize 记录pos处的数据已失效，调用方需持有db.mu
+func (db *DB) addReclaimSize(pos *data.LogRecordPos) {
+	db.reclaimSizes[pos.Fid] += int64(pos.Size)
+}
 
-		// 若读取到最后一个文件，即activeFile，需要将该activeFile的offset写入db结构体内
-		if i == fileNums-1 {
-			db.activeFile.WriteOff = offset
+// reclaimSize 所有数据文件中可回收的数据量，调用方需持有db.mu
+func (db *DB) reclaimSize() int64 {
+	var size int64
+	for _, reclaimSize := range db.reclaimSizes {
+		size += reclaimSize
+	}
+	return size
+}
+
+// DiscardedTailBytes 返回启动时从活跃文件末尾截断的不完整数据的字节数
+func (db *DB) DiscardedTailBytes() int64 {
+	return db.discardedTailBytes
+}
+
+// checkTornTail 判断活跃文件中offset处读取失败的数据是否为写入不完整的数据，size为读取到的数据大小，
+// 只有数据延伸至文件末尾或之后只有0时才是写入不完整的数据，否则其之后的完整数据会在截断时丢失，需要使用Repair进行修复
+func checkTornTail(dataFile *data.DataFile, offset, size int64, err error) error {
+	if !isCorruptedRecord(err) {
+		return err
+	}
+	if err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	// 不完整的header
+	if size == 0 && fileSize-offset < data.MaxLogRecordHeaderSize {
+		return nil
+	}
+	buf := make([]byte, 4096)
+	for start := offset + size; start < fileSize; start += int64(len(buf)) {
+		if int64(len(buf)) > fileSize-start {
+			buf = buf[:fileSize-start]
+		}
+		if _, err := dataFile.IoManager.Read(buf, start); err != nil {
+			return err
+		}
+		for _, b := range buf {
+			if b != 0 {
+				return fmt.Errorf("%w: data file %d has a corrupted record at offset %d, use Repair to recover the records after it",
+					ErrDataDirectoryCorrupted, dataFile.FileID, offset)
+			}
 		}
 	}
+	return nil
+}
 
-	db.seqNo = currentSeqNo
+// isCorruptedRecord 判断读取记录时的错误是否由记录损坏或写入不完整导致
+func isCorruptedRecord(err error) bool {
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF, data.ErrorEmptyKeyInFile, data.ErrorInvalidCRC,
+		data.ErrorUnknownCompression, data.ErrorInvalidCompressed, fio.ErrDecryptFailed, fio.ErrEncryptedFileCorrupted:
+		return true
+	}
+	return false
+}
 
+// checkDataFileEnd 判断在offset处读取到的EOF是否为文件真正的末尾
+func checkDataFileEnd(dataFile *data.DataFile, offset int64) error {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if offset < fileSize {
+		return ErrDataDirectoryCorrupted
+	}
 	return nil
 }
 
 // Put 逻辑，将 key - value写入到数据文件，并将key - offset 写入内存索引
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.put(key, value, 0)
+}
+
+// put 写入key - value，expireAt为数据的过期时间，为0表示永不过期
+func (db *DB) put(key []byte, value []byte, expireAt int64) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.ReadOnly {
+		return ErrReadOnly
+	}
 
 	// 构建即将要写入的 LogRecord   普通put ，将key编码为 uint64(0) + key
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:         logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:       value,
+		Type:        data.LogRecordNormal,
+		ExpireAt:    expireAt,
+		Compression: db.Compression,
 	}
 
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	// 写入数据文件与更新索引在同一把锁内完成，保证索引的更新顺序与数据文件中的写入顺序一致
+	return db.write(db.SyncWrites, func() error {
+		pos, err := db.appendLogRecord(logRecord)
+		if err != nil {
+			return err
+		}
+		oldPositions, err := db.applyToIndex([][]byte{key}, []*data.LogRecordPos{pos}, pos)
+		if err != nil {
+			return err
+		}
+		if oldPositions[0] != nil {
+			db.addReclaimSize(oldPositions[0])
+		}
+		db.oracle.track(key)
+		return nil
+	})
+}
 
-	if err != nil {
-		return err
+// applyToIndex 将写入数据文件的一组记录应用到索引中，positions中为nil的key表示删除，返回各key原有的位置。
+// end为这组记录中最后写入的一条记录的位置，B+树索引会在同一个事务内记录其结束位置作为checkpoint，调用方需持有db.mu
+func (db *DB) applyToIndex(keys [][]byte, positions []*data.LogRecordPos, end *data.LogRecordPos) ([]*data.LogRecordPos, error) {
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		if db.pendingIndex != nil {
+			return db.pendingIndex.add(bpt, keys, positions, end), nil
+		}
+		//同步提交的事务会立即持久化checkpoint，需要先持久化数据文件，否则掉电后checkpoint可能超出数据文件的末尾
+		if !bpt.Tree.NoSync {
+			if err := db.syncActiveFile(); err != nil {
+				return nil, err
+			}
+		}
+		return bpt.Apply(keys, positions, &index.Checkpoint{
+			Fid:    end.Fid,
+			Offset: end.Offset + int64(end.Size),
+			SeqNo:  db.seqNo,
+		}), nil
 	}
-	if ok := db.index.Put(key, pos); !ok {
-		return ErrIndexUpdateFailed
+	oldPositions := make([]*data.LogRecordPos, len(keys))
+	for i, key := range keys {
+		if positions[i] == nil {
+			oldPositions[i], _ = db.index.Delete(key)
+		} else {
+			oldPositions[i] = db.index.Put(key, positions[i])
+		}
 	}
-
-	return nil
+	return oldPositions, nil
 }
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+
+// getIndexPos 读取key在索引中的位置，包括组提交中尚未应用到B+树索引的写入，调用方需持有db.mu
+func (db *DB) getIndexPos(key []byte) *data.LogRecordPos {
+	if db.pendingIndex != nil {
+		if pos, ok := db.pendingIndex.get(key); ok {
+			return pos
+		}
+	}
+	return db.index.Get(key)
 }
 
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
@@ -261,16 +739,29 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	//此后将执行实际LogRecord写入文件
+	//记录写入时间，merge重写数据时保留原有的写入时间
+	if logRecord.Timestamp == 0 {
+		logRecord.Timestamp = time.Now().UnixNano()
+	}
+	//大value写入blob文件，数据文件中只写入其位置
+	if db.isBlobValue(logRecord) {
+		blobPointerRecord, err := db.appendBlobRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		logRecord = blobPointerRecord
+	}
 	//将LogRecord结构体进行编码
 	encLogRecord, logRecordSize := data.EnCodeLogRecord(logRecord)
 	//判断当前活跃文件是否有足够空间写入当前logRecord
 	if db.activeFile.WriteOff+logRecordSize > db.Options.MaxDataFileSize {
 		//对当前活跃文件进行持久化
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
-		//旧活跃文件添加至map
+		//旧活跃文件添加至map，并在后台为其生成hint文件
 		db.olderFiles[db.activeFile.FileID] = db.activeFile
+		db.writeDataHintAsync([]*data.DataFile{db.activeFile})
 
 		//打开新的活跃文件
 		if err := db.setActiveFile(); err != nil {
@@ -282,17 +773,15 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	if err := db.activeFile.Write(encLogRecord); err != nil {
 		return nil, err
 	}
-
-	if db.Options.SyncWrites {
-		if err := db.activeFile.Sync(); err != nil {
+	//根据配置，未持久化的数据量达到阈值后进行持久化
+	db.bytesWrite += uint(logRecordSize)
+	if db.BytesPerSync > 0 && db.bytesWrite >= db.BytesPerSync {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
 	}
-	pos := &data.LogRecordPos{
-		Fid:    db.activeFile.FileID,
-		Offset: writeOff,
-	}
-	return pos, nil
+
+	return newLogRecordPos(db.activeFile.FileID, writeOff, logRecordSize, logRecord), nil
 }
 
 func (db *DB) Get(key []byte) ([]byte, error) {
@@ -304,7 +793,7 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	}
 
 	logRecordPos := db.index.Get(key)
-	if logRecordPos == nil {
+	if logRecordPos == nil || isExpired(logRecordPos.ExpireAt, time.Now().UnixNano()) {
 		return nil, ErrKeyIsNotFound
 	}
 
@@ -314,23 +803,36 @@ func (db *DB) ListKeys(reverse bool) [][]byte {
 
 	iterator := db.index.Iterator(reverse)
 	defer iterator.Close()
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	keys := make([][]byte, 0, db.index.Size())
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx += 1
+		if isExpired(iterator.Value().ExpireAt, now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
 func (db *DB) getLogRecordValue(logRecordPos *data.LogRecordPos) ([]byte, error) {
 	var dataFile *data.DataFile
 	// 判断该数据的存储文件是否为当前活跃文件
-	if logRecordPos.Fid == db.activeFile.FileID {
+	if db.activeFile != nil && logRecordPos.Fid == db.activeFile.FileID {
 		dataFile = db.activeFile
 	} else {
 		dataFile = db.olderFiles[logRecordPos.Fid]
 	}
+	return readLogRecordValue(dataFile, db.blobFiles, logRecordPos)
+}
 
+// readLogRecordValue 从数据文件中读取pos处的数据，value存储在blob文件中时从blob文件中读取
+func readLogRecordValue(dataFile *data.DataFile, blobFiles map[uint32]*data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
+	if logRecordPos.BlobSize > 0 {
+		return readBlobValue(blobFiles, &data.BlobPointer{
+			Fid:    logRecordPos.BlobFid,
+			Offset: logRecordPos.BlobOffset,
+			Size:   logRecordPos.BlobSize,
+		})
+	}
 	// 判断文件是否存在
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
@@ -346,9 +848,12 @@ func (db *DB) getLogRecordValue(logRecordPos *data.LogRecordPos) ([]byte, error)
 	if err != nil {
 		return nil, err
 	}
-	if logRecord.Type == data.LogRecordDeleted {
+	if logRecord.Type == data.LogRecordDeleted || isExpired(logRecord.ExpireAt, time.Now().UnixNano()) {
 		return nil, ErrKeyIsNotFound
 	}
+	if logRecord.Type == data.LogRecordBlob {
+		return readBlobValue(blobFiles, data.DecodeBlobPointer(logRecord.Value))
+	}
 	return logRecord.Value, nil
 }
 
@@ -359,9 +864,17 @@ func (db *DB) Fold(ff FoldFunc) error {
 	defer db.mu.RUnlock()
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		//跳过已过期的数据
+		if isExpired(iterator.Value().ExpireAt, now) {
+			continue
+		}
 		value, err := db.getLogRecordValue(iterator.Value())
 		if err != nil {
+			if err == ErrKeyIsNotFound {
+				continue
+			}
 			return err
 		}
 		if !ff(iterator.Key(), value) {
@@ -375,22 +888,35 @@ func (db *DB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
-	pos := db.index.Get(key)
-	if pos == nil {
-		return nil
+	if db.ReadOnly {
+		return ErrReadOnly
 	}
-	deleteLogRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Type: data.LogRecordDeleted,
-	}
-	_, err := db.appendLogRecordWithLock(deleteLogRecord)
-	if err != nil {
+	return db.write(db.SyncWrites, func() error {
+		pos := db.getIndexPos(key)
+		if pos == nil {
+			return nil
+		}
+		deleteLogRecord := &data.LogRecord{
+			Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
+			Type: data.LogRecordDeleted,
+		}
+		deletePos, err := db.appendLogRecord(deleteLogRecord)
+		if err != nil {
+			return err
+		}
+		//删除标记本身在merge时也会被清理
+		db.addReclaimSize(deletePos)
+		oldPositions, err := db.applyToIndex([][]byte{key}, []*data.LogRecordPos{nil}, deletePos)
+		if err != nil {
+			return err
+		}
+		if oldPositions[0] == nil {
+			return ErrIndexUpdateFailed
+		}
+		db.addReclaimSize(oldPositions[0])
+		db.oracle.track(key)
 		return nil
-	}
-	if ok := db.index.Delete(key); !ok {
-		return ErrIndexUpdateFailed
-	}
-	return nil
+	})
 }
 
 // setActiveFile 设置db当前的活跃文件
@@ -399,93 +925,167 @@ func (db *DB) setActiveFile() error {
 	if db.activeFile != nil {
 		initialFileID = db.activeFile.FileID + 1
 	}
-	dataFile, err := data.OpenDataFile(db.Options.DirPath, initialFileID)
+	dataFile, err := data.OpenDataFile(db.Options.DirPath, initialFileID, fio.StandardFIO, db.ioOptions()...)
 	if err != nil {
 		return err
 	}
+	//持久化目录，保证新建的数据文件在宕机后依然存在
+	if err := utils.SyncDir(db.Options.DirPath); err != nil {
+		_ = dataFile.Close()
+		return err
+	}
 	db.activeFile = dataFile
 	return nil
 }
 
+// Close 关闭db，仍有未释放的快照时，数据文件在最后一个快照释放后关闭
 func (db *DB) Close() error {
-	if db.activeFile == nil && len(db.olderFiles) == 0 {
-		return nil
+	defer func() {
+		//释放目录锁，之后其他进程可以打开该db
+		if db.fileLock != nil {
+			db.fileLock.Unlock()
+			db.fileLock = nil
+		}
+	}()
+	//先停止后台任务，避免关闭文件时merge仍在读取
+	db.stopBackgroundTasks()
+	if db.activeFile == nil && len(db.olderFiles) == 0 && len(db.blobFiles) == 0 {
+		return db.index.Close()
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	//等待后台生成hint文件完成
+	db.hintWg.Wait()
 
-	// 若db的index类型为b+树，无需从data文件获取索引，则无法获取当前db的batch写的seqNo，则使用单独的文件来保存seqno
-	if db.IndexType == index.BPtree {
-		//保存当前事务序列号
-		seqNoFile, err := data.OpenSeqNoFile(db.Options.DirPath)
-		if err != nil {
+	//保存内存索引的快照，加快下次启动
+	if !db.ReadOnly && db.IndexType != index.BPtree {
+		if err := db.saveIndexSnapshotLocked(); err != nil {
 			return err
 		}
-		seqRecord := &data.LogRecord{
-			Key:   []byte(seqNoKey),
-			Value: []byte(strconv.FormatUint(db.seqNo, 10)),
+	}
+	//关闭索引
+	if err := db.index.Close(); err != nil {
+		return err
+	}
+	//仍有未释放的快照时，数据文件在最后一个快照释放时关闭
+	if db.snapshotNum > 0 {
+		if db.activeFile != nil {
+			db.obsoleteFiles = append(db.obsoleteFiles, db.activeFile)
+		}
+		for _, dataFile := range db.olderFiles {
+			db.obsoleteFiles = append(db.obsoleteFiles, dataFile)
+		}
+		for _, blobFile := range db.blobFiles {
+			db.obsoleteFiles = append(db.obsoleteFiles, blobFile)
 		}
-		encSeqRecord, _ := data.EnCodeLogRecord(seqRecord)
-		if err := seqNoFile.Write(encSeqRecord); err != nil {
+		return nil
+	}
+	//关闭当前活跃文件，只写入过blob文件时活跃文件尚未创建
+	if db.activeFile != nil {
+		if err := db.activeFile.Close(); err != nil {
 			return err
 		}
-
-		if err := seqNoFile.Sync(); err != nil {
+	}
+	for _, dataFile := range db.olderFiles {
+		if err := dataFile.Close(); err != nil {
 			return err
 		}
-
-		if err := seqNoFile.Close(); err != nil {
+	}
+	for _, blobFile := range db.blobFiles {
+		if err := blobFile.Close(); err != nil {
 			return err
 		}
 	}
+	return db.closeObsoleteFiles()
+}
 
-	//关闭当前活跃文件
-	if err := db.activeFile.Close(); err != nil {
-		return err
+// runInBackground 启动后台goroutine，每隔interval执行一次task，关闭db时退出
+func (db *DB) runInBackground(interval time.Duration, task func()) {
+	if db.closeCh == nil {
+		db.closeCh = make(chan struct{})
 	}
-	for _, dataFile := range db.olderFiles {
-		if err := dataFile.Close(); err != nil {
-			return err
+	db.bgWg.Add(1)
+	go func() {
+		defer db.bgWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				task()
+			case <-db.closeCh:
+				return
+			}
 		}
+	}()
+}
+
+// stopBackgroundTasks 停止所有后台goroutine，若有任务正在执行，等待其完成
+func (db *DB) stopBackgroundTasks() {
+	if db.closeCh == nil {
+		return
 	}
-	return nil
+	close(db.closeCh)
+	db.bgWg.Wait()
+	db.closeCh = nil
 }
+
 func (db *DB) Sync() error {
 	if db.activeFile == nil {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	return db.syncActiveFile()
+}
+
+// syncActiveFile 持久化当前活跃文件，调用方需持有db.mu
+func (db *DB) syncActiveFile() error {
+	//数据文件中的记录引用了blob文件中的数据，需要先持久化blob文件
+	if err := db.syncActiveBlobFile(); err != nil {
+		return err
+	}
 	if err := db.activeFile.Sync(); err != nil {
 		return err
 	}
+	db.bytesWrite = 0
 	return nil
 }
 
-// 从特定的seqFile内加载出db的seqno
-func (db *DB) loadSeqNo() error {
-	fileName := filepath.Join(db.Options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsExist(err) {
-		//若不存在，直接返回，默认seqNo为0
-		return nil
-	}
-	seqFile, err := data.OpenSeqNoFile(db.DirPath)
-	if err != nil {
-		return err
+// startBackgroundSync 启动后台goroutine，定期持久化活跃文件中尚未持久化的数据
+func (db *DB) startBackgroundSync() {
+	if db.SyncInterval <= 0 || db.ReadOnly {
+		return
 	}
-	encRecord, _, header, err := seqFile.Get(0)
-	if err != nil {
-		return err
-	}
-	record, err := data.DecodeLogRecord(encRecord, header)
-	if err != nil {
-		return err
+	db.runInBackground(db.SyncInterval, func() {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if db.activeFile == nil || db.bytesWrite == 0 {
+			return
+		}
+		// 持久化失败时等待下一次持久化即可
+		_ = db.syncActiveFile()
+	})
+}
+
+// Stat 返回数据库的统计信息
+func (db *DB) Stat() (*Stat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var dataFiles = uint(len(db.olderFiles))
+	if db.activeFile != nil {
+		dataFiles += 1
 	}
-	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
+	dirSize, err := utils.DirSize(db.DirPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	db.seqNo = seqNo
-	db.seqNoFExists = true
-	return os.Remove(fileName)
+	return &Stat{
+		KeyNum:          uint(db.index.Size()),
+		DataFileNum:     dataFiles,
+		BlobFileNum:     uint(len(db.blobFiles)),
+		ReclaimableSize: db.reclaimSize(),
+		DiskSize:        dirSize,
+	}, nil
 }
@@ -3,8 +3,13 @@ package bitcaskkv
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
 	"github.com/GGjahon/bitcask-kv/index"
 	"github.com/GGjahon/bitcask-kv/utils"
 	"github.com/stretchr/testify/assert"
@@ -287,3 +292,464 @@ func TestFold(t *testing.T) {
 // 	assert.NoError(t, err)
 // 	assert.Equal(t, mergePath, mergeDB.Options.DirPath)
 // }
+
+func TestOpenWithMMapAtStartup(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	for i := 0; i < 500; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.NoError(t, db.Delete(utils.GetRandomKey(1)))
+	require.NoError(t, db.Close())
+
+	db, err = Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024), WithDBMMapAtStartup(true))
+	require.NoError(t, err)
+	require.Greater(t, len(db.olderFiles), 1)
+	require.Equal(t, 499, db.index.Size())
+	_, err = db.Get(utils.GetRandomKey(1))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	val, err := db.Get(utils.GetRandomKey(2))
+	require.NoError(t, err)
+	require.Len(t, val, 64)
+
+	// 启动完成后数据文件应切换回标准IO，可以继续写入
+	require.NoError(t, db.Put(utils.GetRandomKey(1), []byte("jahoon")))
+	val, err = db.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("jahoon"), val)
+	require.NoError(t, db.Close())
+}
+
+func TestOpenWithTornTail(t *testing.T) {
+	testCases := []struct {
+		name string
+		tail func(encLogRecord []byte) []byte
+	}{
+		{
+			name: "partial record",
+			tail: func(encLogRecord []byte) []byte {
+				return encLogRecord[:len(encLogRecord)/2]
+			},
+		},
+		{
+			name: "partial header",
+			tail: func(encLogRecord []byte) []byte {
+				return encLogRecord[:3]
+			},
+		},
+		{
+			name: "invalid crc",
+			tail: func(encLogRecord []byte) []byte {
+				encLogRecord[0]++
+				return encLogRecord
+			},
+		},
+		{
+			name: "followed by zeros",
+			tail: func(encLogRecord []byte) []byte {
+				encLogRecord[0]++
+				return append(encLogRecord, make([]byte, 100)...)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dirPath := t.TempDir()
+			db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+			require.NoError(t, err)
+			for i := 0; i < 100; i++ {
+				require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+			}
+			activeFileID, writeOff := db.activeFile.FileID, db.activeFile.WriteOff
+			require.NoError(t, db.Close())
+
+			// 模拟进程在写入最后一条数据时崩溃
+			encLogRecord, _ := data.EnCodeLogRecord(&data.LogRecord{
+				Key:   logRecordKeyWithSeq(utils.GetRandomKey(100), nonTransactionSeqNo),
+				Value: utils.GetRandomValue(64),
+			})
+			tail := tc.tail(encLogRecord)
+			dataFile, err := data.OpenDataFile(dirPath, activeFileID, fio.StandardFIO)
+			require.NoError(t, err)
+			require.NoError(t, dataFile.Write(tail))
+			require.NoError(t, dataFile.Close())
+
+			db, err = Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+			require.NoError(t, err)
+			require.Equal(t, int64(len(tail)), db.DiscardedTailBytes())
+			require.Equal(t, writeOff, db.activeFile.WriteOff)
+			require.Equal(t, 100, db.index.Size())
+			_, err = db.Get(utils.GetRandomKey(100))
+			require.ErrorIs(t, err, ErrKeyIsNotFound)
+
+			// 截断后可以继续正常写入
+			require.NoError(t, db.Put(utils.GetRandomKey(100), []byte("jahoon")))
+			require.NoError(t, db.Close())
+			db, err = Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+			require.NoError(t, err)
+			require.Equal(t, int64(0), db.DiscardedTailBytes())
+			val, err := db.Get(utils.GetRandomKey(100))
+			require.NoError(t, err)
+			require.Equal(t, []byte("jahoon"), val)
+			require.NoError(t, db.Close())
+		})
+	}
+}
+
+func TestOpenWithCorruptedOlderFile(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.Greater(t, len(db.olderFiles), 1)
+	require.NoError(t, db.Close())
+
+	// 修改第一个数据文件中的一个字节
+	fileName := data.GetDataFileName(dirPath, 0)
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	buf[len(buf)-1]++
+	require.NoError(t, os.WriteFile(fileName, buf, fio.FilePerm))
+	// 索引快照及hint文件中已包含旧数据文件的索引，需删除后才会重新读取旧数据文件
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+	require.NoError(t, os.Remove(data.GetDataHintFileName(dirPath, 0)))
+
+	_, err = Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.ErrorIs(t, err, data.ErrorInvalidCRC)
+}
+
+func TestOpenWithCorruptedActiveFile(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	pos := db.index.Get(utils.GetRandomKey(1))
+	require.NoError(t, db.Close())
+
+	// 修改活跃文件中间的一个字节，其之后的数据依然完整，不能作为写入不完整的数据截断
+	fileName := data.GetDataFileName(dirPath, pos.Fid)
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	buf[pos.Offset+int64(pos.Size)-1]++
+	require.NoError(t, os.WriteFile(fileName, buf, fio.FilePerm))
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+
+	_, err = Open(WithDBDirPath(dirPath))
+	require.ErrorIs(t, err, ErrDataDirectoryCorrupted)
+	_, err = Open(WithDBDirPath(dirPath), WithReadOnly())
+	require.ErrorIs(t, err, ErrDataDirectoryCorrupted)
+	fileInfo, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(buf)), fileInfo.Size())
+
+	report, err := Repair(dirPath)
+	require.NoError(t, err)
+	require.Len(t, report.LostKeys, 1)
+	db, err = Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, 99, db.index.Size())
+	require.Equal(t, int64(0), db.DiscardedTailBytes())
+}
+
+func TestOpenDirectoryInUse(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	require.NoError(t, db.Put(utils.GetRandomKey(1), utils.GetRandomValue(10)))
+
+	_, err = Open(WithDBDirPath(dirPath))
+	require.ErrorIs(t, err, ErrDatabaseIsUsing)
+
+	require.NoError(t, db.Close())
+	db, err = Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	_, err = db.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dirPath := t.TempDir()
+	_, err := Open(WithDBDirPath(dirPath+"-not-exist"), WithReadOnly())
+	require.Error(t, err)
+
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.NoError(t, db.Close())
+	entries, err := os.ReadDir(dirPath)
+	require.NoError(t, err)
+
+	reader1, err := Open(WithDBDirPath(dirPath), WithReadOnly())
+	require.NoError(t, err)
+	reader2, err := Open(WithDBDirPath(dirPath), WithReadOnly())
+	require.NoError(t, err)
+	// 存在只读进程时，无法以读写模式打开
+	_, err = Open(WithDBDirPath(dirPath))
+	require.ErrorIs(t, err, ErrDatabaseIsUsing)
+
+	for _, reader := range []*DB{reader1, reader2} {
+		require.Equal(t, 100, reader.index.Size())
+		_, err := reader.Get(utils.GetRandomKey(1))
+		require.NoError(t, err)
+
+		require.ErrorIs(t, reader.Put(utils.GetRandomKey(1), []byte("jahoon")), ErrReadOnly)
+		require.ErrorIs(t, reader.Delete(utils.GetRandomKey(1)), ErrReadOnly)
+		require.ErrorIs(t, reader.Merge(), ErrReadOnly)
+		wb := reader.NewWriteBatch()
+		require.ErrorIs(t, wb.Put(utils.GetRandomKey(1), []byte("jahoon")), ErrReadOnly)
+		require.ErrorIs(t, wb.Delete(utils.GetRandomKey(1)), ErrReadOnly)
+		require.ErrorIs(t, wb.Commit(), ErrReadOnly)
+	}
+	require.NoError(t, reader1.Close())
+	require.NoError(t, reader2.Close())
+
+	// 只读模式不会在目录中创建或删除任何文件
+	afterEntries, err := os.ReadDir(dirPath)
+	require.NoError(t, err)
+	require.Equal(t, len(entries), len(afterEntries))
+
+	db, err = Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestOpenReadOnlyWithoutIndexFiles(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.NoError(t, db.Close())
+	// 模拟从其他位置复制来的只有数据文件的目录
+	require.NoError(t, os.Remove(filepath.Join(dirPath, fileLockName)))
+
+	// B+树索引文件不存在时，在内存中重建索引，且不创建锁文件
+	db, err = Open(WithDBDirPath(dirPath), WithReadOnly(), WithDBIndexType(index.BPtree))
+	require.NoError(t, err)
+	require.Equal(t, 100, db.index.Size())
+	_, err = db.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	for _, fileName := range []string{fileLockName, "bptree-index"} {
+		_, err = os.Stat(filepath.Join(dirPath, fileName))
+		require.True(t, os.IsNotExist(err))
+	}
+
+	// 无法打开的B+树索引文件
+	require.NoError(t, os.WriteFile(filepath.Join(dirPath, "bptree-index"), []byte("jahoon"), fio.FilePerm))
+	_, err = Open(WithDBDirPath(dirPath), WithReadOnly(), WithDBIndexType(index.BPtree))
+	require.ErrorIs(t, err, ErrDataDirectoryCorrupted)
+}
+
+func TestStat(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+
+	stat, err := db.Stat()
+	require.NoError(t, err)
+	require.Equal(t, uint(0), stat.KeyNum)
+	require.Equal(t, int64(0), stat.ReclaimableSize)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	stat, err = db.Stat()
+	require.NoError(t, err)
+	require.Equal(t, uint(100), stat.KeyNum)
+	require.Equal(t, int64(0), stat.ReclaimableSize)
+	require.Greater(t, stat.DataFileNum, uint(1))
+	require.Greater(t, stat.DiskSize, int64(0))
+
+	// 覆盖写入与删除的数据均可回收
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	for i := 10; i < 20; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Put(utils.GetRandomKey(20), utils.GetRandomValue(64)))
+	require.NoError(t, wb.Commit())
+	stat, err = db.Stat()
+	require.NoError(t, err)
+	require.Equal(t, uint(90), stat.KeyNum)
+	require.Greater(t, stat.ReclaimableSize, int64(0))
+	reclaimSize := stat.ReclaimableSize
+	require.NoError(t, db.Close())
+
+	// 重启后重新统计的可回收数据量保持一致
+	db, err = Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	stat, err = db.Stat()
+	require.NoError(t, err)
+	require.Equal(t, uint(90), stat.KeyNum)
+	require.Equal(t, reclaimSize, stat.ReclaimableSize)
+	require.NoError(t, db.Close())
+}
+
+func TestLoadIndexFromDataFilesInParallel(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(32)))
+		}
+		// 事务中的数据跨越多个数据文件
+		wb := db.NewWriteBatch(WithMaxBatchNum(100))
+		for i := round * 20; i < round*20+80; i++ {
+			if i%3 == 0 {
+				require.NoError(t, wb.Delete(utils.GetRandomKey(i)))
+			} else {
+				require.NoError(t, wb.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+			}
+		}
+		require.NoError(t, wb.Commit())
+	}
+	require.Greater(t, len(db.olderFiles), 8)
+	expected := make(map[string]data.LogRecordPos)
+	iter := db.index.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		expected[string(iter.Key())] = *iter.Value()
+	}
+	iter.Close()
+	reclaimSize, seqNo := db.reclaimSizes, db.seqNo
+	require.NoError(t, db.Close())
+
+	// 删除索引快照及hint文件，从数据文件中加载全部索引
+	entries, err := os.ReadDir(dirPath)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == data.DataHintFileSuffix || entry.Name() == data.IndexSnapshotFileName {
+			require.NoError(t, os.Remove(filepath.Join(dirPath, entry.Name())))
+		}
+	}
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, len(expected), db.index.Size())
+	for key, pos := range expected {
+		require.Equal(t, pos, *db.index.Get([]byte(key)))
+	}
+	require.Equal(t, reclaimSize, db.reclaimSizes)
+	require.Equal(t, seqNo, db.seqNo)
+	require.NoError(t, db.Close())
+}
+
+func TestBytesPerSync(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBBytesPerSync(1024))
+	require.NoError(t, err)
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+		require.Less(t, db.bytesWrite, uint(1024))
+	}
+	require.NoError(t, db.Sync())
+	require.Zero(t, db.bytesWrite)
+}
+
+func TestSyncInterval(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBSyncInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Put(utils.GetRandomKey(1), utils.GetRandomValue(64)))
+	require.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return db.bytesWrite == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCompression(t *testing.T) {
+	dirPath := t.TempDir()
+	value := []byte(strings.Repeat(`{"name":"jahoon","age":18,"tags":["bitcask","kv"]},`, 20))
+	db, err := Open(WithDBDirPath(dirPath), WithDBCompression(data.LZCompression))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), value))
+	}
+	require.Less(t, db.activeFile.WriteOff*5, int64(100*len(value)))
+	require.NoError(t, db.Close())
+
+	// 切换压缩方式后，新旧数据均可以正常读取
+	db, err = Open(WithDBDirPath(dirPath), WithDBCompression(data.FlateCompression))
+	require.NoError(t, err)
+	for i := 100; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), value))
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	for i := 200; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), value))
+	}
+	require.NoError(t, db.Merge())
+	for i := 0; i < 300; i++ {
+		val, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+		require.Equal(t, value, val)
+	}
+	require.NoError(t, db.Close())
+
+	_, err = Open(WithDBDirPath(dirPath), WithDBCompression(0x0f))
+	require.ErrorIs(t, err, ErrInvalidCompression)
+}
+
+// testdata/legacy-v0 由未记录时间戳及压缩方式的旧版本写入，包含普通数据、删除标记及事务
+func TestOpenLegacyDataFiles(t *testing.T) {
+	dirPath := t.TempDir()
+	entries, err := os.ReadDir("testdata/legacy-v0")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		buf, err := os.ReadFile(filepath.Join("testdata/legacy-v0", entry.Name()))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dirPath, entry.Name()), buf, fio.FilePerm))
+	}
+
+	checkLegacyData := func(db *DB) {
+		for i := 0; i < 30; i++ {
+			value, err := db.Get([]byte(fmt.Sprintf("key-%02d", i)))
+			switch {
+			case i < 5 || i == 6:
+				require.Equal(t, ErrKeyIsNotFound, err)
+			case i == 5:
+				require.NoError(t, err)
+				require.Equal(t, []byte("new-value-05"), value)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), value)
+			}
+		}
+		value, err := db.Get([]byte("txn"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("txn-value"), value)
+	}
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(512))
+	require.NoError(t, err)
+	checkLegacyData(db)
+	// 新旧格式的数据可以共存，merge后全部使用新格式
+	require.NoError(t, db.Put([]byte("new"), []byte("value")))
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	db, err = Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(512))
+	require.NoError(t, err)
+	defer db.Close()
+	checkLegacyData(db)
+	_, err = db.Get([]byte("new"))
+	require.NoError(t, err)
+}
@@ -0,0 +1,165 @@
+package bitcaskkv
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/utils"
+)
+
+const (
+	// 更换密钥时重写文件使用的临时文件后缀
+	rotateTempFileSuffix = ".rotate"
+	// 更换密钥时每次读取的数据大小
+	rotateBufSize = 1024 * 1024
+)
+
+// checkEncryptionKey 密钥为空表示不加密，否则必须是AES-128、AES-192或AES-256的密钥长度
+func checkEncryptionKey(key []byte) error {
+	switch len(key) {
+	case 0, 16, 24, 32:
+		return nil
+	default:
+		return ErrInvalidEncryptionKey
+	}
+}
+
+// RotateEncryptionKey 将dirPath下的数据文件、blob文件、hint文件、merge完成标志文件及索引快照使用newKey重新加密，
+// oldKey为空表示原文件未加密，newKey为空表示解密为明文。执行期间db不能被打开。
+// 每个文件重写完成后原子地替换原文件，中途退出时使用相同的参数再次执行即可继续完成
+func RotateEncryptionKey(dirPath string, oldKey, newKey []byte) error {
+	if err := checkEncryptionKey(oldKey); err != nil {
+		return err
+	}
+	if err := checkEncryptionKey(newKey); err != nil {
+		return err
+	}
+	fileLock, err := fio.NewFileLock(filepath.Join(dirPath, fileLockName), false)
+	if err != nil {
+		return err
+	}
+	defer fileLock.Unlock()
+	locked, err := fileLock.TryLock()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return ErrDatabaseIsUsing
+	}
+
+	if err := rotateDirEncryptionKey(dirPath, oldKey, newKey); err != nil {
+		return err
+	}
+	// 尚未完成替换的merge目录中的文件同样需要重新加密
+	mergePath := getMergePath(dirPath)
+	if _, err := os.Stat(mergePath); err == nil {
+		return rotateDirEncryptionKey(mergePath, oldKey, newKey)
+	}
+	return nil
+}
+
+func rotateDirEncryptionKey(dirPath string, oldKey, newKey []byte) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !isEncryptedFile(entry.Name()) {
+			continue
+		}
+		if err := rotateFileEncryptionKey(filepath.Join(dirPath, entry.Name()), oldKey, newKey); err != nil {
+			return err
+		}
+	}
+	return utils.SyncDir(dirPath)
+}
+
+// isEncryptedFile 判断db目录下的文件是否需要加密，B+树索引文件与目录锁文件不加密
+func isEncryptedFile(name string) bool {
+	switch name {
+	case data.HintFileName, data.MergeFinishedFileName, data.IndexSnapshotFileName, data.RepairReportFileName:
+		return true
+	}
+	return strings.HasSuffix(name, data.DataFileNameSuffix) || strings.HasSuffix(name, data.DataHintFileSuffix) ||
+		strings.HasSuffix(name, data.BlobFileSuffix) || strings.HasSuffix(name, data.QuarantineFileSuffix)
+}
+
+// rotateFileEncryptionKey 使用oldKey读取文件，以newKey写入临时文件后替换原文件
+func rotateFileEncryptionKey(filePath string, oldKey, newKey []byte) error {
+	// 清理上次执行中断时遗留的临时文件
+	tempFilePath := filePath + rotateTempFileSuffix
+	if err := os.RemoveAll(tempFilePath); err != nil {
+		return err
+	}
+	src, err := openRotateSource(filePath, oldKey, newKey)
+	if err != nil || src == nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := fio.NewIoManager(tempFilePath, fio.StandardFIO, append(encryptionIOOptions(newKey), fio.WithFrameFileName(filepath.Base(filePath)))...)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	buf := make([]byte, rotateBufSize)
+	var offset int64
+	for {
+		n, err := src.Read(buf, offset)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tempFilePath, filePath)
+}
+
+// openRotateSource 以oldKey打开待重写的文件，文件已经使用newKey加密时返回nil
+func openRotateSource(filePath string, oldKey, newKey []byte) (fio.IOManager, error) {
+	// 明文文件无法通过解密判断，先尝试使用newKey打开
+	if len(oldKey) == 0 && len(newKey) > 0 {
+		ioManager, err := fio.NewIoManager(filePath, fio.StandardFIO, encryptionIOOptions(newKey)...)
+		if err == nil {
+			return nil, ioManager.Close()
+		}
+		if err != fio.ErrDecryptFailed {
+			return nil, err
+		}
+	}
+	ioManager, err := fio.NewIoManager(filePath, fio.StandardFIO, encryptionIOOptions(oldKey)...)
+	if err != fio.ErrDecryptFailed {
+		return ioManager, err
+	}
+	// 无法使用oldKey解密，若已能使用newKey打开，说明上次执行时已经重写过该文件
+	if len(newKey) > 0 {
+		done, err := fio.NewIoManager(filePath, fio.StandardFIO, encryptionIOOptions(newKey)...)
+		if err != nil {
+			return nil, err
+		}
+		return nil, done.Close()
+	}
+	// 解密为明文时，无法使用oldKey解密的文件视为已经重写过
+	return nil, nil
+}
+
+func encryptionIOOptions(key []byte) []fio.IOOption {
+	if len(key) == 0 {
+		return nil
+	}
+	return []fio.IOOption{fio.WithEncryptionKey(key)}
+}
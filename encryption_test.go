@@ -0,0 +1,148 @@
+package bitcaskkv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/index"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+var encryptionTestValue = []byte("bitcask-kv-encrypted-value")
+
+// putEncryptionTestData 写入数据并完成一次merge，使数据目录下包含数据文件、hint文件、merge完成标志文件及索引快照
+func putEncryptionTestData(t *testing.T, opts ...DBOption) {
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), encryptionTestValue))
+	}
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	require.NoError(t, db.Merge())
+	for i := 300; i < 400; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), encryptionTestValue))
+	}
+	require.NoError(t, db.Close())
+}
+
+func checkEncryptionTestData(t *testing.T, opts ...DBOption) {
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, 300, db.index.Size())
+	for i := 100; i < 400; i++ {
+		value, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+		require.Equal(t, encryptionTestValue, value)
+	}
+}
+
+// checkDirEncrypted 判断目录下需要加密的文件中是否包含明文的value
+func checkDirEncrypted(t *testing.T, dirPath string, encrypted bool) {
+	entries, err := os.ReadDir(dirPath)
+	require.NoError(t, err)
+	var found bool
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
+		require.NoError(t, err)
+		if bytes.Contains(buf, encryptionTestValue) {
+			found = true
+		}
+	}
+	require.Equal(t, !encrypted, found)
+}
+
+func TestEncryption(t *testing.T) {
+	dirPath := t.TempDir()
+	key := []byte("0123456789abcdef")
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024), WithDBEncryptionKey(key)}
+	putEncryptionTestData(t, opts...)
+	checkDirEncrypted(t, dirPath, true)
+	for _, fileName := range []string{data.HintFileName, data.MergeFinishedFileName, data.IndexSnapshotFileName} {
+		_, err := os.Stat(filepath.Join(dirPath, fileName))
+		require.NoError(t, err)
+	}
+	// merge后写满的数据文件生成了hint文件
+	hintFileNames, err := filepath.Glob(filepath.Join(dirPath, "*"+data.DataHintFileSuffix))
+	require.NoError(t, err)
+	require.NotEmpty(t, hintFileNames)
+
+	checkEncryptionTestData(t, opts...)
+	checkEncryptionTestData(t, append(opts, WithDBMMapAtStartup(true))...)
+	// 不读取索引快照及hint文件，直接从数据文件加载
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+	for _, hintFileName := range hintFileNames {
+		require.NoError(t, os.Remove(hintFileName))
+	}
+	checkEncryptionTestData(t, opts...)
+
+	// 使用错误的密钥无法打开
+	_, err = Open(WithDBDirPath(dirPath), WithDBEncryptionKey([]byte("fedcba9876543210")))
+	require.ErrorIs(t, err, fio.ErrDecryptFailed)
+
+	// 不足一个frame的未加密数据库使用密钥打开时同样无法打开，且不会被截断
+	plainDirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(plainDirPath))
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("jahoon"), []byte("value")))
+	require.NoError(t, db.Close())
+	plainFileName := data.GetDataFileName(plainDirPath, 0)
+	plainData, err := os.ReadFile(plainFileName)
+	require.NoError(t, err)
+	_, err = Open(WithDBDirPath(plainDirPath), WithDBEncryptionKey(key))
+	require.ErrorIs(t, err, fio.ErrDecryptFailed)
+	raw, err := os.ReadFile(plainFileName)
+	require.NoError(t, err)
+	require.Equal(t, plainData, raw)
+
+	_, err = Open(WithDBDirPath(t.TempDir()), WithDBEncryptionKey([]byte("jahoon")))
+	require.ErrorIs(t, err, ErrInvalidEncryptionKey)
+	_, err = Open(WithDBDirPath(t.TempDir()), WithDBEncryptionKey(key), WithDBIndexType(index.BPtree))
+	require.ErrorIs(t, err, ErrEncryptionUnsupported)
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	putEncryptionTestData(t, opts...)
+	checkDirEncrypted(t, dirPath, false)
+
+	// 加密未加密的db
+	key1 := []byte("0123456789abcdef")
+	require.NoError(t, RotateEncryptionKey(dirPath, nil, key1))
+	checkDirEncrypted(t, dirPath, true)
+	checkEncryptionTestData(t, append(opts, WithDBEncryptionKey(key1))...)
+
+	// 更换密钥，模拟中途退出后再次执行
+	key2 := []byte("0123456789abcdef0123456789abcdef")
+	require.NoError(t, rotateFileEncryptionKey(data.GetDataFileName(dirPath, 0), key1, key2))
+	require.NoError(t, os.WriteFile(data.GetDataFileName(dirPath, 1)+rotateTempFileSuffix, []byte("jahoon"), fio.FilePerm))
+	require.NoError(t, RotateEncryptionKey(dirPath, key1, key2))
+	_, err := os.Stat(data.GetDataFileName(dirPath, 1) + rotateTempFileSuffix)
+	require.True(t, os.IsNotExist(err))
+	_, err = Open(append(opts, WithDBEncryptionKey(key1))...)
+	require.ErrorIs(t, err, fio.ErrDecryptFailed)
+	checkEncryptionTestData(t, append(opts, WithDBEncryptionKey(key2))...)
+
+	// 解密为明文
+	require.NoError(t, RotateEncryptionKey(dirPath, key2, nil))
+	checkDirEncrypted(t, dirPath, false)
+	checkEncryptionTestData(t, opts...)
+
+	// db打开期间无法更换密钥
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	require.ErrorIs(t, RotateEncryptionKey(dirPath, nil, key1), ErrDatabaseIsUsing)
+	require.NoError(t, db.Close())
+}
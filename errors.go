@@ -10,4 +10,20 @@ var (
 	ErrDataDirectoryCorrupted = errors.New("the database dir maybe corrupted")
 	ErrExceedMaxBatchNum      = errors.New("exceed the max batch num")
 	ErrMErgeIsProgress        = errors.New("merge is in progressing,please try again later")
+	ErrInvalidTTL             = errors.New("the ttl must be greater than zero")
+	ErrTxnConflict            = errors.New("transaction conflict, the keys read by it have been changed")
+	ErrTxnClosed              = errors.New("transaction has been committed or discarded")
+	ErrSnapshotReleased       = errors.New("snapshot has been released")
+	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
+	ErrReadOnly               = errors.New("the database is opened in read only mode")
+	ErrMergeRatioUnreached    = errors.New("the reclaimable data does not reach the merge ratio")
+	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrBlobGCIsProgress       = errors.New("blob gc is in progressing,please try again later")
+	ErrInvalidCompression     = errors.New("the compression type is not supported")
+	ErrInvalidEncryptionKey   = errors.New("the encryption key must be 16, 24 or 32 bytes")
+	ErrEncryptionUnsupported  = errors.New("encryption is not supported by the b+ tree index")
+	ErrUnknownRecordType      = errors.New("the type of the log record is unknown")
+	ErrHintMismatch           = errors.New("the hint does not match the records in the data file")
+	ErrIndexMismatch          = errors.New("the index entry does not point to a valid record of the key")
+	ErrTxnFinMissing          = errors.New("the committed transaction has no txn-fin record")
 )
@@ -0,0 +1,305 @@
+package fio
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrDecryptFailed                 = errors.New("failed to decrypt the file, the key may be wrong or the file is corrupted")
+	ErrEncryptedFileCorrupted        = errors.New("the encrypted file is corrupted, frames after the first one can not be decrypted")
+	ErrEncryptedTruncateNotSupported = errors.New("encrypted io manager does not support extending the file")
+)
+
+// 加密文件由若干个frame组成，每次Write写入一个或多个frame，已写入的frame不会被修改
+// frame：明文长度(4字节) + nonce(12字节) + AES-GCM密文 + tag(16字节)
+// 明文长度、frame在明文中的起始位置及文件名作为附加数据参与认证，防止frame被篡改、调换位置或在文件之间调换
+const (
+	frameLenSize   = 4
+	frameNonceSize = 12
+	frameTagSize   = 16
+	frameOverhead  = frameLenSize + frameNonceSize + frameTagSize
+	// 单个frame中明文的最大长度，读取时只需解密所在的frame
+	maxFramePlainSize = 4096
+	// 打开文件时扫描frame使用的缓冲区大小
+	frameScanBufSize = 64 * 1024
+)
+
+// EncryptedIO 对IOManager进行加密的实现，对外读写的均为明文，偏移量也均为明文中的偏移量
+type EncryptedIO struct {
+	inner    IOManager
+	aead     cipher.AEAD
+	fileName string // 参与认证的文件名
+
+	mu       sync.RWMutex
+	starts   []int64 // 每个frame在明文中的起始位置，第i个frame在文件中的位置为starts[i]+i*frameOverhead
+	size     int64   // 明文大小
+	tornTail bool    // 文件末尾存在不完整的frame，下次写入前需要截断
+
+	cacheMu    sync.Mutex
+	cacheFrame int // 最近一次解密的frame，Get读取header和完整数据时位于同一个frame
+	cache      []byte
+}
+
+// NewEncryptedIO fileName为参与frame认证的文件名，重命名前后需保持一致
+func NewEncryptedIO(inner IOManager, key []byte, fileName string) (*EncryptedIO, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		inner.Close()
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		inner.Close()
+		return nil, err
+	}
+	e := &EncryptedIO{
+		inner:      inner,
+		aead:       aead,
+		fileName:   fileName,
+		cacheFrame: -1,
+	}
+	if err := e.loadFrames(); err != nil {
+		inner.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// loadFrames 扫描文件中所有完整的frame，记录各frame在明文中的起始位置
+func (e *EncryptedIO) loadFrames() error {
+	physicalSize, err := e.inner.Size()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, frameScanBufSize)
+	var bufStart, bufEnd int64
+	var physical int64
+	for physical+frameOverhead <= physicalSize {
+		if physical+frameLenSize > bufEnd {
+			n, err := e.inner.Read(buf, physical)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			bufStart, bufEnd = physical, physical+int64(n)
+		}
+		plainSize := int64(binary.LittleEndian.Uint32(buf[physical-bufStart:]))
+		if plainSize > maxFramePlainSize || physical+frameOverhead+plainSize > physicalSize {
+			break
+		}
+		e.starts = append(e.starts, e.size)
+		e.size += plainSize
+		physical += frameOverhead + plainSize
+	}
+	// 没有任何完整frame的非空文件无法确认密钥是否正确，可能未加密或密钥错误，不能作为不完整的写入截断
+	if len(e.starts) == 0 && physicalSize > 0 {
+		return ErrDecryptFailed
+	}
+	// 解密第一个frame，尽早发现密钥错误
+	if len(e.starts) > 0 {
+		if _, err := e.readFrame(0); err != nil {
+			return err
+		}
+	}
+	// 末尾不完整的frame只可能来自一次未完成的写入，超过一个frame大小时，第一个frame能够解密说明文件中间的frame已损坏
+	if physicalSize-physical >= frameOverhead+maxFramePlainSize {
+		return ErrEncryptedFileCorrupted
+	}
+	e.tornTail = physical != physicalSize
+	return nil
+}
+
+// Write ,encrypt the data and append it to the file
+func (e *EncryptedIO) Write(b []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.tornTail {
+		if err := e.inner.Truncate(e.physicalOffset(len(e.starts))); err != nil {
+			return 0, err
+		}
+		e.tornTail = false
+	}
+	frames := make([]byte, 0, len(b)+(len(b)/maxFramePlainSize+1)*frameOverhead)
+	var starts []int64
+	for off := 0; off < len(b); off += maxFramePlainSize {
+		end := off + maxFramePlainSize
+		if end > len(b) {
+			end = len(b)
+		}
+		start := e.size + int64(off)
+		frame, err := e.seal(b[off:end], start)
+		if err != nil {
+			return 0, err
+		}
+		frames = append(frames, frame...)
+		starts = append(starts, start)
+	}
+	if _, err := e.inner.Write(frames); err != nil {
+		// 写入了部分frame时，下次写入前截断
+		e.tornTail = true
+		return 0, err
+	}
+	e.starts = append(e.starts, starts...)
+	e.size += int64(len(b))
+	return len(b), nil
+}
+
+// Read ,read and decrypt the target data
+func (e *EncryptedIO) Read(b []byte, offset int64) (int, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if offset < 0 || offset >= e.size {
+		return 0, io.EOF
+	}
+	// 找到offset所在的frame
+	idx := sort.Search(len(e.starts), func(i int) bool { return e.starts[i] > offset }) - 1
+	var n int
+	for n < len(b) && idx < len(e.starts) {
+		plain, err := e.readFrame(idx)
+		if err != nil {
+			return n, err
+		}
+		n += copy(b[n:], plain[offset+int64(n)-e.starts[idx]:])
+		idx++
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Sync ,make the data in buffer into disk
+func (e *EncryptedIO) Sync() error {
+	return e.inner.Sync()
+}
+
+// Close ,close the io
+func (e *EncryptedIO) Close() error {
+	return e.inner.Close()
+}
+
+// Size ,get the size of the plaintext
+func (e *EncryptedIO) Size() (int64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.size, nil
+}
+
+// Truncate ,change the size of the plaintext, only shrinking is supported
+func (e *EncryptedIO) Truncate(size int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if size > e.size {
+		return ErrEncryptedTruncateNotSupported
+	}
+	if size == e.size && !e.tornTail {
+		return nil
+	}
+	idx := sort.Search(len(e.starts), func(i int) bool { return e.starts[i] >= size })
+	// 截断位置位于frame中间时，需要保留该frame的前半部分，重新加密写入
+	var remain []byte
+	if idx > 0 && e.frameEnd(idx-1) > size {
+		plain, err := e.readFrame(idx - 1)
+		if err != nil {
+			return err
+		}
+		idx--
+		remain = plain[:size-e.starts[idx]]
+	}
+	if err := e.inner.Truncate(e.physicalOffset(idx)); err != nil {
+		return err
+	}
+	e.starts = e.starts[:idx]
+	e.size = size - int64(len(remain))
+	e.tornTail = false
+	e.resetCache()
+	if len(remain) > 0 {
+		frame, err := e.seal(remain, e.size)
+		if err != nil {
+			return err
+		}
+		if _, err := e.inner.Write(frame); err != nil {
+			e.tornTail = true
+			return err
+		}
+		e.starts = append(e.starts, e.size)
+		e.size = size
+	}
+	return nil
+}
+
+// seal 加密一个frame
+func (e *EncryptedIO) seal(plain []byte, start int64) ([]byte, error) {
+	frame := make([]byte, frameLenSize+frameNonceSize, frameOverhead+len(plain))
+	binary.LittleEndian.PutUint32(frame, uint32(len(plain)))
+	nonce := frame[frameLenSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(frame, nonce, plain, e.additionalData(frame[:frameLenSize], start)), nil
+}
+
+// readFrame 读取并解密第idx个frame，调用方需持有e.mu
+// 第一个frame无法解密时视为密钥错误，之后的frame无法解密时说明文件已损坏
+func (e *EncryptedIO) readFrame(idx int) ([]byte, error) {
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+	if e.cacheFrame == idx {
+		return e.cache, nil
+	}
+	plainSize := e.frameEnd(idx) - e.starts[idx]
+	frame := make([]byte, frameOverhead+plainSize)
+	if _, err := e.inner.Read(frame, e.physicalOffset(idx)); err != nil {
+		return nil, err
+	}
+	failedErr := ErrDecryptFailed
+	if idx > 0 {
+		failedErr = ErrEncryptedFileCorrupted
+	}
+	if int64(binary.LittleEndian.Uint32(frame)) != plainSize {
+		return nil, failedErr
+	}
+	nonce := frame[frameLenSize : frameLenSize+frameNonceSize]
+	plain, err := e.aead.Open(nil, nonce, frame[frameLenSize+frameNonceSize:], e.additionalData(frame[:frameLenSize], e.starts[idx]))
+	if err != nil {
+		return nil, failedErr
+	}
+	e.cacheFrame, e.cache = idx, plain
+	return plain, nil
+}
+
+func (e *EncryptedIO) resetCache() {
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+	e.cacheFrame, e.cache = -1, nil
+}
+
+// frameEnd 第idx个frame在明文中的结束位置
+func (e *EncryptedIO) frameEnd(idx int) int64 {
+	if idx+1 < len(e.starts) {
+		return e.starts[idx+1]
+	}
+	return e.size
+}
+
+// physicalOffset 第idx个frame在文件中的起始位置
+func (e *EncryptedIO) physicalOffset(idx int) int64 {
+	if idx < len(e.starts) {
+		return e.starts[idx] + int64(idx)*frameOverhead
+	}
+	return e.size + int64(idx)*frameOverhead
+}
+
+// additionalData frame的附加数据：明文长度 + frame在明文中的起始位置 + 文件名
+func (e *EncryptedIO) additionalData(lenBuf []byte, start int64) []byte {
+	ad := make([]byte, frameLenSize+8, frameLenSize+8+len(e.fileName))
+	copy(ad, lenBuf)
+	binary.LittleEndian.PutUint64(ad[frameLenSize:], uint64(start))
+	return append(ad, e.fileName...)
+}
@@ -0,0 +1,192 @@
+package fio
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestEncryptedIO_ReadWrite(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	eio, err := NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+
+	small := []byte("jahoon")
+	large := bytes.Repeat([]byte("hello world"), 1000)
+	n, err := eio.Write(small)
+	require.NoError(t, err)
+	require.Equal(t, len(small), n)
+	n, err = eio.Write(large)
+	require.NoError(t, err)
+	require.Equal(t, len(large), n)
+	size, err := eio.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(len(small)+len(large)), size)
+
+	// 跨越多个frame读取
+	buf := make([]byte, len(large))
+	_, err = eio.Read(buf, int64(len(small)))
+	require.NoError(t, err)
+	require.Equal(t, large, buf)
+	buf = make([]byte, 10)
+	n, err = eio.Read(buf, size-5)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 5, n)
+	require.NoError(t, eio.Close())
+
+	// 文件中不包含明文
+	raw, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(raw, small))
+
+	// 重新打开后依然可以读取，mmap同样支持
+	for _, ioType := range []FileIOType{StandardFIO, MemoryMap} {
+		eio, err = NewIoManager(fileName, ioType, WithEncryptionKey(testEncryptionKey))
+		require.NoError(t, err)
+		buf = make([]byte, len(small))
+		_, err = eio.Read(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, small, buf)
+		require.NoError(t, eio.Close())
+	}
+
+	// 密钥错误或未提供密钥时无法读取
+	_, err = NewIoManager(fileName, StandardFIO, WithEncryptionKey([]byte("0123456789abcdef")))
+	require.Equal(t, ErrDecryptFailed, err)
+}
+
+func TestEncryptedIO_Truncate(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	eio, err := NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+	value := bytes.Repeat([]byte("0123456789"), 1000)
+	_, err = eio.Write(value)
+	require.NoError(t, err)
+
+	// 截断位置位于frame中间
+	require.NoError(t, eio.Truncate(5000))
+	_, err = eio.Write([]byte("jahoon"))
+	require.NoError(t, err)
+	require.Equal(t, ErrEncryptedTruncateNotSupported, eio.Truncate(10000))
+	require.NoError(t, eio.Close())
+
+	eio, err = NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+	defer eio.Close()
+	size, err := eio.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(5006), size)
+	buf := make([]byte, size)
+	_, err = eio.Read(buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, append(value[:5000:5000], []byte("jahoon")...), buf)
+}
+
+func TestEncryptedIO_TornTail(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	eio, err := NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+	_, err = eio.Write([]byte("jahoon"))
+	require.NoError(t, err)
+	_, err = eio.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, eio.Close())
+
+	// 最后一个frame写入不完整
+	raw, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fileName, raw[:len(raw)-3], FilePerm))
+
+	eio, err = NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+	size, err := eio.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(6), size)
+	// 写入前截断不完整的frame
+	_, err = eio.Write([]byte("bitcask"))
+	require.NoError(t, err)
+	require.NoError(t, eio.Close())
+
+	eio, err = NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+	defer eio.Close()
+	buf := make([]byte, 13)
+	_, err = eio.Read(buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("jahoonbitcask"), buf)
+}
+
+func TestEncryptedIO_NoValidFrame(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	// 不足一个frame的未加密文件
+	plain := []byte("a plaintext file smaller than one frame")
+	require.NoError(t, os.WriteFile(fileName, plain, FilePerm))
+	_, err := NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.ErrorIs(t, err, ErrDecryptFailed)
+	raw, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, plain, raw)
+
+	// 第一个frame写入不完整时同样无法确认密钥是否正确，不截断文件
+	eio, err := NewIoManager(fileName+".enc", StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+	_, err = eio.Write([]byte("jahoon"))
+	require.NoError(t, err)
+	require.NoError(t, eio.Close())
+	raw, err = os.ReadFile(fileName + ".enc")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fileName+".enc", raw[:len(raw)-3], FilePerm))
+	_, err = NewIoManager(fileName+".enc", StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.ErrorIs(t, err, ErrDecryptFailed)
+	info, err := os.Stat(fileName + ".enc")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(raw)-3), info.Size())
+}
+
+func TestEncryptedIO_Corrupted(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.data")
+	eio, err := NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+	_, err = eio.Write(bytes.Repeat([]byte("a"), 3*maxFramePlainSize))
+	require.NoError(t, err)
+	require.NoError(t, eio.Close())
+	raw, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+
+	// 第二个frame的密文损坏时，第一个frame依然可以读取
+	corrupted := bytes.Clone(raw)
+	corrupted[frameOverhead+maxFramePlainSize+frameLenSize+frameNonceSize] ^= 0xff
+	require.NoError(t, os.WriteFile(fileName, corrupted, FilePerm))
+	eio, err = NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	_, err = eio.Read(buf, 0)
+	require.NoError(t, err)
+	_, err = eio.Read(buf, maxFramePlainSize)
+	require.ErrorIs(t, err, ErrEncryptedFileCorrupted)
+	require.NoError(t, eio.Close())
+
+	// 第二个frame的长度损坏时无法打开，且不视为密钥错误
+	corrupted = bytes.Clone(raw)
+	corrupted[frameOverhead+maxFramePlainSize] = 0xff
+	require.NoError(t, os.WriteFile(fileName, corrupted, FilePerm))
+	_, err = NewIoManager(fileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.ErrorIs(t, err, ErrEncryptedFileCorrupted)
+
+	// frame与文件名绑定，复制到其他文件后无法解密，指定原文件名时可以读取
+	otherFileName := filepath.Join(filepath.Dir(fileName), "b.data")
+	require.NoError(t, os.WriteFile(otherFileName, raw, FilePerm))
+	_, err = NewIoManager(otherFileName, StandardFIO, WithEncryptionKey(testEncryptionKey))
+	require.ErrorIs(t, err, ErrDecryptFailed)
+	eio, err = NewIoManager(otherFileName, StandardFIO, WithEncryptionKey(testEncryptionKey), WithFrameFileName("a.data"))
+	require.NoError(t, err)
+	_, err = eio.Read(buf, 2*maxFramePlainSize)
+	require.NoError(t, err)
+	require.NoError(t, eio.Close())
+}
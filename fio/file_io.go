@@ -48,3 +48,8 @@ func (fio *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+// Truncate ,change the size of file, the next write will append at the new end
+func (fio *FileIO) Truncate(size int64) error {
+	return fio.fd.Truncate(size)
+}
@@ -0,0 +1,66 @@
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileLock 基于flock的文件锁，用于保证同一时刻只有一个进程可以写入db目录
+type FileLock struct {
+	fd *os.File // 只读打开时锁文件不存在则为nil
+}
+
+// NewFileLock 打开锁文件，readOnly为true时不创建锁文件，以便在只读的目录中使用，
+// 锁文件不存在说明没有进程写入过该目录，此时获取共享锁总是成功
+func NewFileLock(filename string, readOnly bool) (*FileLock, error) {
+	flag := os.O_CREATE | os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	fd, err := os.OpenFile(filename, flag, FilePerm)
+	if readOnly && os.IsNotExist(err) {
+		return &FileLock{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &FileLock{
+		fd: fd,
+	}, nil
+}
+
+// TryLock 尝试获取排他锁，若锁已被其他进程持有，立即返回false
+func (fl *FileLock) TryLock() (bool, error) {
+	if err := syscall.Flock(int(fl.fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// TryRLock 尝试获取共享锁，多个进程可以同时持有共享锁，但与排他锁互斥
+func (fl *FileLock) TryRLock() (bool, error) {
+	if fl.fd == nil {
+		return true, nil
+	}
+	if err := syscall.Flock(int(fl.fd.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Unlock 释放锁，并关闭锁文件
+func (fl *FileLock) Unlock() error {
+	if fl.fd == nil {
+		return nil
+	}
+	if err := syscall.Flock(int(fl.fd.Fd()), syscall.LOCK_UN); err != nil {
+		return err
+	}
+	return fl.fd.Close()
+}
@@ -0,0 +1,73 @@
+package fio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLock(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "flock")
+	lock1, err := NewFileLock(fileName, false)
+	require.NoError(t, err)
+	ok, err := lock1.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	lock2, err := NewFileLock(fileName, false)
+	require.NoError(t, err)
+	ok, err = lock2.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, lock1.Unlock())
+	ok, err = lock2.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, lock2.Unlock())
+}
+
+func TestFileLock_Shared(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "flock")
+	require.NoError(t, os.WriteFile(fileName, nil, FilePerm))
+	reader1, err := NewFileLock(fileName, true)
+	require.NoError(t, err)
+	ok, err := reader1.TryRLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	reader2, err := NewFileLock(fileName, true)
+	require.NoError(t, err)
+	ok, err = reader2.TryRLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// 持有共享锁时无法获取排他锁
+	writer, err := NewFileLock(fileName, false)
+	require.NoError(t, err)
+	ok, err = writer.TryLock()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, reader1.Unlock())
+	require.NoError(t, reader2.Unlock())
+	ok, err = writer.TryLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, writer.Unlock())
+}
+
+func TestFileLock_ReadOnlyWithoutLockFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "flock")
+	// 锁文件不存在时只读打开不创建锁文件
+	reader, err := NewFileLock(fileName, true)
+	require.NoError(t, err)
+	ok, err := reader.TryRLock()
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, err = os.Stat(fileName)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, reader.Unlock())
+}
@@ -1,7 +1,19 @@
 package fio
 
+import "path/filepath"
+
 const FilePerm = 0644
 
+type FileIOType = byte
+
+const (
+	// StandardFIO 标准文件IO
+	StandardFIO FileIOType = iota
+
+	// MemoryMap 内存文件映射，仅支持读取
+	MemoryMap
+)
+
 // IOManager , a interface for different IO,provides the method to operate the data in disk
 type IOManager interface {
 	// Write ,write the data into disk
@@ -14,8 +26,52 @@ type IOManager interface {
 	Close() error
 	// Size , get the size of file
 	Size() (int64, error)
+	// Truncate , change the size of file
+	Truncate(int64) error
+}
+
+type ioOptions struct {
+	encryptionKey []byte
+	frameFileName string
+}
+
+type IOOption func(opts *ioOptions)
+
+// WithEncryptionKey 使用AES-GCM对文件内容进行加密，key的长度为16、24或32字节
+func WithEncryptionKey(key []byte) IOOption {
+	return func(opts *ioOptions) {
+		opts.encryptionKey = key
+	}
+}
+
+// WithFrameFileName 指定参与加密frame认证的文件名，默认为所打开文件的文件名
+// 写入临时文件后重命名时，需指定为重命名后的文件名
+func WithFrameFileName(name string) IOOption {
+	return func(opts *ioOptions) {
+		opts.frameFileName = name
+	}
 }
 
-func NewIoManager(filename string) (IOManager, error) {
-	return NewFileIO(filename)
+func NewIoManager(filename string, ioType FileIOType, opts ...IOOption) (IOManager, error) {
+	options := &ioOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	var ioManager IOManager
+	var err error
+	switch ioType {
+	case StandardFIO:
+		ioManager, err = NewFileIO(filename)
+	case MemoryMap:
+		ioManager, err = NewMMapIOManager(filename)
+	default:
+		panic("unsupported io type")
+	}
+	if err != nil || len(options.encryptionKey) == 0 {
+		return ioManager, err
+	}
+	if options.frameFileName == "" {
+		options.frameFileName = filepath.Base(filename)
+	}
+	return NewEncryptedIO(ioManager, options.encryptionKey, options.frameFileName)
 }
@@ -0,0 +1,86 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+var (
+	ErrMMapWriteNotSupported    = errors.New("mmap io manager does not support write")
+	ErrMMapTruncateNotSupported = errors.New("mmap io manager does not support truncate")
+)
+
+// MMap an implement of IOManager with memory map, only used to read data quickly when db start up
+type MMap struct {
+	fd   *os.File
+	data []byte
+}
+
+func NewMMapIOManager(filename string) (*MMap, error) {
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDONLY, FilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	// 空文件无法进行映射，直接以空数据返回即可
+	var data []byte
+	if stat.Size() > 0 {
+		data, err = syscall.Mmap(int(fd.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+	return &MMap{
+		fd:   fd,
+		data: data,
+	}, nil
+}
+
+// Write ,mmap is read only
+func (mmap *MMap) Write(b []byte) (int, error) {
+	return 0, ErrMMapWriteNotSupported
+}
+
+// Read ,read the target data from the mapped region
+func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
+	if offset < 0 || offset >= int64(len(mmap.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, mmap.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Sync ,nothing to sync for a read only mapping
+func (mmap *MMap) Sync() error {
+	return nil
+}
+
+// Close ,unmap the region and close the file
+func (mmap *MMap) Close() error {
+	if mmap.data != nil {
+		if err := syscall.Munmap(mmap.data); err != nil {
+			return err
+		}
+		mmap.data = nil
+	}
+	return mmap.fd.Close()
+}
+
+func (mmap *MMap) Size() (int64, error) {
+	return int64(len(mmap.data)), nil
+}
+
+// Truncate ,mmap is read only
+func (mmap *MMap) Truncate(size int64) error {
+	return ErrMMapTruncateNotSupported
+}
@@ -0,0 +1,58 @@
+package fio
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMMap_Read(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "mmap-a.data")
+
+	// 空文件
+	mmapIO, err := NewMMapIOManager(fileName)
+	require.NoError(t, err)
+	size, err := mmapIO.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), size)
+	b := make([]byte, 6)
+	_, err = mmapIO.Read(b, 0)
+	require.ErrorIs(t, err, io.EOF)
+	require.NoError(t, mmapIO.Close())
+
+	fio, err := NewFileIO(fileName)
+	require.NoError(t, err)
+	_, err = fio.Write([]byte("jahoon"))
+	require.NoError(t, err)
+	_, err = fio.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, fio.Close())
+
+	mmapIO, err = NewMMapIOManager(fileName)
+	require.NoError(t, err)
+	defer mmapIO.Close()
+	size, err = mmapIO.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(17), size)
+
+	n, err := mmapIO.Read(b, 0)
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+	require.Equal(t, []byte("jahoon"), b)
+
+	b2 := make([]byte, 20)
+	n, err = mmapIO.Read(b2, 6)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 11, n)
+	require.Equal(t, []byte("hello world"), b2[:n])
+}
+
+func TestMMap_Write(t *testing.T) {
+	mmapIO, err := NewMMapIOManager(filepath.Join(t.TempDir(), "mmap-b.data"))
+	require.NoError(t, err)
+	defer mmapIO.Close()
+	_, err = mmapIO.Write([]byte("jahoon"))
+	require.ErrorIs(t, err, ErrMMapWriteNotSupported)
+}
@@ -0,0 +1,137 @@
+package bitcaskkv
+
+import (
+	"sync"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/index"
+)
+
+// writeRequest 一次等待持久化的写入
+type writeRequest struct {
+	write func() error //写入数据文件并更新索引，在db.mu内执行
+	err   error
+	done  bool
+}
+
+// writeQueue 需要持久化的并发写入在队列中排队，由队首的写入者将队列中的所有写入作为一组提交，整组只进行一次Sync
+type writeQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*writeRequest
+}
+
+func newWriteQueue() *writeQueue {
+	q := &writeQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// write 在db.mu内执行写入，needSync为true时写入完成并持久化后才返回
+func (db *DB) write(needSync bool, write func() error) error {
+	if !needSync {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return write()
+	}
+
+	q := db.writeQueue
+	req := &writeRequest{write: write}
+	q.mu.Lock()
+	q.pending = append(q.pending, req)
+	// 等待前一组提交完成，成为队首后由自身负责提交
+	for !req.done && q.pending[0] != req {
+		q.cond.Wait()
+	}
+	if req.done {
+		q.mu.Unlock()
+		return req.err
+	}
+	group := q.pending
+	q.mu.Unlock()
+
+	db.commitGroup(group)
+
+	q.mu.Lock()
+	for _, r := range group {
+		r.done = true
+	}
+	q.pending = q.pending[len(group):]
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	return req.err
+}
+
+// commitGroup 依次执行一组写入，全部写入数据文件后进行一次Sync
+// 整个过程持有db.mu，其他读写无法看到尚未持久化的数据
+// B+树索引的事务提交时会持久化checkpoint，组内的写入在数据文件持久化之后才在一个事务内应用
+func (db *DB) commitGroup(group []*writeRequest) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	bpt, isBPTree := db.index.(*index.BPlusTree)
+	if isBPTree {
+		db.pendingIndex = newPendingIndex()
+		defer func() { db.pendingIndex = nil }()
+	}
+	var written bool
+	for _, r := range group {
+		if r.err = r.write(); r.err == nil {
+			written = true
+		}
+	}
+	if !written || db.activeFile == nil {
+		return
+	}
+	//数据文件写满切换时已对旧的活跃文件进行持久化，只需持久化当前的活跃文件
+	if err := db.syncActiveFile(); err != nil {
+		for _, r := range group {
+			if r.err == nil {
+				r.err = err
+			}
+		}
+		return
+	}
+	if isBPTree && db.pendingIndex.end != nil {
+		end := db.pendingIndex.end
+		bpt.Apply(db.pendingIndex.keys, db.pendingIndex.positions, &index.Checkpoint{
+			Fid:    end.Fid,
+			Offset: end.Offset + int64(end.Size),
+			SeqNo:  db.seqNo,
+		})
+	}
+}
+
+// pendingIndex 组提交中已写入数据文件、尚未应用到B+树索引的写入，positions中为nil表示删除
+type pendingIndex struct {
+	keys      [][]byte
+	positions []*data.LogRecordPos
+	latest    map[string]*data.LogRecordPos
+	end       *data.LogRecordPos
+}
+
+func newPendingIndex() *pendingIndex {
+	return &pendingIndex{latest: make(map[string]*data.LogRecordPos)}
+}
+
+// add 暂存一组写入，返回各key原有的位置
+func (p *pendingIndex) add(bpt *index.BPlusTree, keys [][]byte, positions []*data.LogRecordPos, end *data.LogRecordPos) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(keys))
+	for i, key := range keys {
+		if pos, ok := p.latest[string(key)]; ok {
+			oldPositions[i] = pos
+		} else {
+			oldPositions[i] = bpt.Get(key)
+		}
+		p.latest[string(key)] = positions[i]
+	}
+	p.keys = append(p.keys, keys...)
+	p.positions = append(p.positions, positions...)
+	p.end = end
+	return oldPositions
+}
+
+// get 读取key暂存的位置，key被删除时返回nil
+func (p *pendingIndex) get(key []byte) (*data.LogRecordPos, bool) {
+	pos, ok := p.latest[string(key)]
+	return pos, ok
+}
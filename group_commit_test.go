@@ -0,0 +1,96 @@
+package bitcaskkv
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/index"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCommit(t *testing.T) {
+	for _, indexType := range []index.IndexTypes{index.Btree, index.BPtree} {
+		dirPath := t.TempDir()
+		opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024), WithDBSync(true), WithDBIndexType(indexType)}
+		db, err := Open(opts...)
+		require.NoError(t, err)
+		require.NoError(t, db.Put(utils.GetRandomKey(0), []byte("jahoon")))
+
+		// 持有db.mu，使并发的写入在队列中积累为一组
+		db.mu.Lock()
+		var wg sync.WaitGroup
+		for i := 1; i <= 50; i++ {
+			wg.Add(1)
+			key, value := utils.GetRandomKey(i), utils.GetRandomValue(64)
+			go func() {
+				defer wg.Done()
+				require.NoError(t, db.Put(key, value))
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wb := db.NewWriteBatch()
+			require.NoError(t, wb.Put(utils.GetRandomKey(100), []byte("batch")))
+			require.NoError(t, wb.Commit())
+		}()
+		require.Eventually(t, func() bool {
+			db.writeQueue.mu.Lock()
+			defer db.writeQueue.mu.Unlock()
+			return len(db.writeQueue.pending) == 51
+		}, 5*time.Second, time.Millisecond)
+		db.mu.Unlock()
+		wg.Wait()
+		require.Empty(t, db.writeQueue.pending)
+
+		// 同一组内的写入按照提交的顺序生效，队首的写入单独成组，之后的两个写入为同一组
+		db.mu.Lock()
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, db.Put(utils.GetRandomKey(300), []byte("jahoon")))
+		}()
+		require.Eventually(t, func() bool {
+			db.writeQueue.mu.Lock()
+			defer db.writeQueue.mu.Unlock()
+			return len(db.writeQueue.pending) == 1
+		}, 5*time.Second, time.Millisecond)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, db.Put(utils.GetRandomKey(200), []byte("jahoon")))
+		}()
+		require.Eventually(t, func() bool {
+			db.writeQueue.mu.Lock()
+			defer db.writeQueue.mu.Unlock()
+			return len(db.writeQueue.pending) == 2
+		}, 5*time.Second, time.Millisecond)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, db.Delete(utils.GetRandomKey(200)))
+		}()
+		require.Eventually(t, func() bool {
+			db.writeQueue.mu.Lock()
+			defer db.writeQueue.mu.Unlock()
+			return len(db.writeQueue.pending) == 3
+		}, 5*time.Second, time.Millisecond)
+		db.mu.Unlock()
+		wg.Wait()
+		// B+树索引在数据文件持久化后统一应用组内的写入，checkpoint位于最后一条写入之后
+		if bpt, ok := db.index.(*index.BPlusTree); ok {
+			require.Equal(t, db.activeFile.WriteOff, bpt.Checkpoint().Offset)
+		}
+		require.NoError(t, db.Close())
+
+		db, err = Open(opts...)
+		require.NoError(t, err)
+		require.Equal(t, 53, db.index.Size())
+		value, err := db.Get(utils.GetRandomKey(100))
+		require.NoError(t, err)
+		require.Equal(t, []byte("batch"), value)
+		_, err = db.Get(utils.GetRandomKey(200))
+		require.ErrorIs(t, err, ErrKeyIsNotFound)
+		require.NoError(t, db.Close())
+	}
+}
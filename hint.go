@@ -0,0 +1,170 @@
+package bitcaskkv
+
+import (
+	"io"
+	"os"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/utils"
+)
+
+// 写入hint文件时每积累该大小的数据写入一次文件
+const dataHintBufSize = 256 * 1024
+
+// hintEntry hint文件中记录的一条数据，保留了数据的类型及带序列号的key，加载时与读取数据文件的效果一致
+type hintEntry struct {
+	record *data.LogRecord
+	pos    *data.LogRecordPos
+}
+
+// writeDataHintAsync 在后台为已封存的数据文件生成hint文件，调用方需持有db.mu
+func (db *DB) writeDataHintAsync(dataFiles []*data.DataFile) {
+	if len(dataFiles) == 0 {
+		return
+	}
+	mergeVersion := db.mergeVersion
+	db.hintWg.Add(1)
+	go func() {
+		defer db.hintWg.Done()
+		for _, dataFile := range dataFiles {
+			// 生成失败时启动会退回读取数据文件，不影响正确性
+			if err := db.writeDataHint(dataFile, mergeVersion); err != nil {
+				_ = os.Remove(data.GetDataHintTempFileName(db.DirPath, dataFile.FileID))
+			}
+		}
+	}()
+}
+
+// writeDataHint 读取已封存的数据文件，将其中每条数据的key和位置写入hint文件
+func (db *DB) writeDataHint(dataFile *data.DataFile, mergeVersion uint64) error {
+	hintFile, err := data.OpenDataHintTempFile(db.DirPath, dataFile.FileID, db.ioOptions()...)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	// 清理上次未完成的临时文件
+	if err := hintFile.Truncate(0); err != nil {
+		return err
+	}
+
+	var buf []byte
+	var offset int64 = 0
+	for {
+		encLogRecord, size, logRecordHeader, err := dataFile.Get(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		logRecord, err := data.DecodeLogRecordWithoutValue(encLogRecord, logRecordHeader)
+		if err != nil {
+			return err
+		}
+		encHintRecord, _ := data.EnCodeLogRecord(&data.LogRecord{
+			Key:   logRecord.Key,
+			Value: data.EncCodeLogRecordPos(newLogRecordPos(dataFile.FileID, offset, size, logRecord)),
+			Type:  logRecord.Type,
+		})
+		buf = append(buf, encHintRecord...)
+		if len(buf) >= dataHintBufSize {
+			if err := hintFile.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+		offset += size
+	}
+	if err := hintFile.Write(buf); err != nil {
+		return err
+	}
+	if err := hintFile.Sync(); err != nil {
+		return err
+	}
+
+	db.hintMu.Lock()
+	defer db.hintMu.Unlock()
+	tempFileName := data.GetDataHintTempFileName(db.DirPath, dataFile.FileID)
+	// 生成期间完成了merge，该数据文件可能已被替换
+	if db.mergeVersion != mergeVersion {
+		return os.Remove(tempFileName)
+	}
+	if err := os.Rename(tempFileName, data.GetDataHintFileName(db.DirPath, dataFile.FileID)); err != nil {
+		return err
+	}
+	return utils.SyncDir(db.DirPath)
+}
+
+// readDataHint 读取数据文件对应的hint文件，hint文件不存在或与数据文件不一致时返回nil，由调用方读取数据文件
+func (db *DB) readDataHint(dataFile *data.DataFile) []*hintEntry {
+	hintFileName := data.GetDataHintFileName(db.DirPath, dataFile.FileID)
+	if _, err := os.Stat(hintFileName); err != nil {
+		return nil
+	}
+	entries, err := db.readDataHintEntries(dataFile)
+	if err != nil {
+		// 删除无效的hint文件，启动后重新生成
+		if !db.ReadOnly {
+			_ = os.Remove(hintFileName)
+		}
+		return nil
+	}
+	return entries
+}
+
+func (db *DB) readDataHintEntries(dataFile *data.DataFile) ([]*hintEntry, error) {
+	hintFile, err := data.OpenDataHintFile(db.DirPath, dataFile.FileID, db.loadIOType(), db.ioOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer hintFile.Close()
+
+	var entries []*hintEntry
+	var offset, dataOffset int64
+	for {
+		encHintRecord, size, hintRecordHeader, err := hintFile.Get(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		hintRecord, err := data.DecodeLogRecord(encHintRecord, hintRecordHeader)
+		if err != nil {
+			return nil, err
+		}
+		pos := data.DecCodeLogRecordPos(hintRecord.Value)
+		// hint文件中的数据必须连续地覆盖整个数据文件
+		if pos.Fid != dataFile.FileID || pos.Offset != dataOffset {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		hintRecord.Value = nil
+		hintRecord.ExpireAt = pos.ExpireAt
+		entries = append(entries, &hintEntry{record: hintRecord, pos: pos})
+		dataOffset += int64(pos.Size)
+		offset += size
+	}
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if fileSize != dataOffset {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	return entries, nil
+}
+
+// writeMissingDataHints 启动后为缺少hint文件的已封存数据文件补充生成hint文件
+func (db *DB) writeMissingDataHints() {
+	var dataFiles []*data.DataFile
+	for _, fid := range db.fileIds {
+		dataFile, ok := db.olderFiles[uint32(fid)]
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(data.GetDataHintFileName(db.DirPath, dataFile.FileID)); os.IsNotExist(err) {
+			dataFiles = append(dataFiles, dataFile)
+		}
+	}
+	db.writeDataHintAsync(dataFiles)
+}
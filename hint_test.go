@@ -0,0 +1,127 @@
+package bitcaskkv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataHintWrittenOnRotation(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	wb := db.NewWriteBatch()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, wb.Delete(utils.GetRandomKey(i)))
+	}
+	require.NoError(t, wb.Commit())
+	for i := 200; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	reclaimSize, seqNo := db.reclaimSizes, db.seqNo
+	olderFileNum := len(db.olderFiles)
+	require.NoError(t, db.Close())
+
+	for fid := 0; fid < olderFileNum; fid++ {
+		_, err := os.Stat(data.GetDataHintFileName(dirPath, uint32(fid)))
+		require.NoError(t, err)
+	}
+	// 活跃文件尚未封存，没有hint文件
+	_, err = os.Stat(data.GetDataHintFileName(dirPath, uint32(olderFileNum)))
+	require.True(t, os.IsNotExist(err))
+
+	// 修改第一个数据文件中最后一条数据的value，读取hint文件时不会读取到该数据
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+	fileName := data.GetDataFileName(dirPath, 0)
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	buf[len(buf)-1]++
+	require.NoError(t, os.WriteFile(fileName, buf, fio.FilePerm))
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 250, db.index.Size())
+	require.Equal(t, reclaimSize, db.reclaimSizes)
+	require.Equal(t, seqNo, db.seqNo)
+	for i := 50; i < 300; i++ {
+		pos := db.index.Get(utils.GetRandomKey(i))
+		require.NotNil(t, pos)
+		if pos.Fid == 0 {
+			continue
+		}
+		_, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestDataHintInvalid(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.NoError(t, db.Close())
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+
+	// hint文件不完整时读取数据文件
+	hintFileName := data.GetDataHintFileName(dirPath, 0)
+	buf, err := os.ReadFile(hintFileName)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(hintFileName, buf[:len(buf)/2], fio.FilePerm))
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 200, db.index.Size())
+	for i := 0; i < 200; i++ {
+		_, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+	// 启动后重新生成了缺失的hint文件
+	newBuf, err := os.ReadFile(hintFileName)
+	require.NoError(t, err)
+	require.Equal(t, buf, newBuf)
+}
+
+func TestDataHintAfterMerge(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	require.NoError(t, db.Merge())
+	for i := 250; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("jahoon")))
+	}
+	require.NoError(t, db.Close())
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 100, db.index.Size())
+	for i := 200; i < 300; i++ {
+		value, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+		if i >= 250 {
+			require.Equal(t, []byte("jahoon"), value)
+		}
+	}
+	require.NoError(t, db.Close())
+}
@@ -23,11 +23,14 @@ func NewAdaPtiveRadixTree() Index {
 }
 
 // Put the key into memory
-func (art *AdaPtiveRadixTree) Put(key []byte, pos *data.LogRecordPos) bool {
+func (art *AdaPtiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.mu.Lock()
 	defer art.mu.Unlock()
-	art.Tree.Insert(key, pos)
-	return true
+	oldValue, updated := art.Tree.Insert(key, pos)
+	if !updated || oldValue == nil {
+		return nil
+	}
+	return oldValue.(*data.LogRecordPos)
 }
 
 // Get the key's data store pos in disk
@@ -42,11 +45,14 @@ func (art *AdaPtiveRadixTree) Get(key []byte) *data.LogRecordPos {
 }
 
 // Delete ,delete the key in memory
-func (art *AdaPtiveRadixTree) Delete(key []byte) bool {
+func (art *AdaPtiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.mu.Lock()
 	defer art.mu.Unlock()
-	_, deleted := art.Tree.Delete(key)
-	return deleted
+	oldValue, deleted := art.Tree.Delete(key)
+	if !deleted || oldValue == nil {
+		return nil, false
+	}
+	return oldValue.(*data.LogRecordPos), true
 }
 
 // Iterator
@@ -65,6 +71,26 @@ func (art *AdaPtiveRadixTree) Size() int {
 	return size
 }
 
+// Snapshot 将当前所有的key - pos复制到一棵新的基数树中
+func (art *AdaPtiveRadixTree) Snapshot() Index {
+	art.mu.RLock()
+	defer art.mu.RUnlock()
+	tree := goart.New()
+	art.Tree.ForEach(func(node goart.Node) (cont bool) {
+		tree.Insert(node.Key(), node.Value())
+		return true
+	})
+	return &AdaPtiveRadixTree{
+		Tree: tree,
+		mu:   new(sync.RWMutex),
+	}
+}
+
+// Close 内存索引无需释放资源
+func (art *AdaPtiveRadixTree) Close() error {
+	return nil
+}
+
 // arttTeeIterator BTree索引迭代器实例
 type artIterator struct {
 	//当前遍历的下标位置
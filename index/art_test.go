@@ -14,7 +14,7 @@ func TestArtGet(t *testing.T) {
 		Fid:    1,
 		Offset: 10,
 	})
-	require.True(t, Pres1)
+	require.Nil(t, Pres1)
 
 	Gres1 := art.Get([]byte("abc"))
 	require.Equal(t, Gres1.Fid, uint32(1))
@@ -24,7 +24,9 @@ func TestArtGet(t *testing.T) {
 		Fid:    2,
 		Offset: 12,
 	})
-	require.True(t, Pres2)
+	require.NotNil(t, Pres2)
+	require.Equal(t, uint32(1), Pres2.Fid)
+	require.Equal(t, int64(10), Pres2.Offset)
 	Gres2 := art.Get([]byte("abc"))
 	require.Equal(t, Gres2.Fid, uint32(2))
 	require.Equal(t, Gres2.Offset, int64(12))
@@ -36,16 +38,18 @@ func TestARTree_Delete(t *testing.T) {
 		Fid:    1,
 		Offset: 10,
 	})
-	require.True(t, Pres1)
+	require.Nil(t, Pres1)
 
-	Dres1 := art.Delete([]byte("abc"))
-	require.True(t, Dres1)
+	Dres1, ok1 := art.Delete([]byte("abc"))
+	require.True(t, ok1)
+	require.Equal(t, uint32(1), Dres1.Fid)
 
 	Gres1 := art.Get([]byte("abc"))
 	t.Log(Gres1)
 
-	Dres2 := art.Delete([]byte("abc"))
-	require.False(t, Dres2)
+	Dres2, ok2 := art.Delete([]byte("abc"))
+	require.False(t, ok2)
+	require.Nil(t, Dres2)
 
 }
 
@@ -60,7 +64,7 @@ func TestARTree_Iterator(t *testing.T) {
 
 	// 插入数据
 	ok1 := art1.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
-	assert.Equal(t, ok1, true)
+	assert.Nil(t, ok1)
 	iter2 := art1.Iterator(false)
 	assert.True(t, iter2.Valid())
 	assert.Equal(t, iter2.Key(), []byte("a"))
@@ -137,3 +141,19 @@ func TestARTree_Iterator(t *testing.T) {
 	assert.Nil(t, iter3.(*artIterator).values)
 	assert.Nil(t, iter4.(*artIterator).values)
 }
+
+func TestARTree_Snapshot(t *testing.T) {
+	art := NewAdaPtiveRadixTree()
+	art.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("bcd"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	snap := art.Snapshot()
+	art.Put([]byte("abc"), &data.LogRecordPos{Fid: 2, Offset: 10})
+	art.Delete([]byte("bcd"))
+	art.Put([]byte("cde"), &data.LogRecordPos{Fid: 2, Offset: 20})
+
+	require.Equal(t, 2, snap.Size())
+	require.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 10}, snap.Get([]byte("abc")))
+	require.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 20}, snap.Get([]byte("bcd")))
+	require.Nil(t, snap.Get([]byte("cde")))
+}
@@ -22,7 +22,7 @@ func TestBPTree_Get(t *testing.T) {
 		Fid:    1,
 		Offset: 10,
 	})
-	require.True(t, Pres1)
+	require.Nil(t, Pres1)
 
 	Gres1 := bpt.Get([]byte("abc"))
 	require.Equal(t, Gres1.Fid, uint32(1))
@@ -32,7 +32,9 @@ func TestBPTree_Get(t *testing.T) {
 		Fid:    2,
 		Offset: 12,
 	})
-	require.True(t, Pres2)
+	require.NotNil(t, Pres2)
+	require.Equal(t, uint32(1), Pres2.Fid)
+	require.Equal(t, int64(10), Pres2.Offset)
 	Gres2 := bpt.Get([]byte("abc"))
 	require.Equal(t, Gres2.Fid, uint32(2))
 	require.Equal(t, Gres2.Offset, int64(12))
@@ -45,16 +47,18 @@ func TestBPTree_Delete(t *testing.T) {
 		Fid:    1,
 		Offset: 10,
 	})
-	require.True(t, Pres1)
+	require.Nil(t, Pres1)
 
-	Dres1 := bpt.Delete([]byte("abc"))
-	require.True(t, Dres1)
+	Dres1, ok1 := bpt.Delete([]byte("abc"))
+	require.True(t, ok1)
+	require.Equal(t, uint32(1), Dres1.Fid)
 
 	Gres1 := bpt.Get([]byte("abc"))
 	t.Log(Gres1)
 
-	Dres2 := bpt.Delete([]byte("abc"))
-	require.False(t, Dres2)
+	Dres2, ok2 := bpt.Delete([]byte("abc"))
+	require.False(t, ok2)
+	require.Nil(t, Dres2)
 
 	DeleteBPTreeFile()
 
@@ -73,7 +77,7 @@ func TestBPTree_Iterator(t *testing.T) {
 
 	//插入数据
 	ok1 := bpt1.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
-	assert.Equal(t, ok1, true)
+	assert.Nil(t, ok1)
 	iter2 := bpt1.Iterator(false)
 	assert.True(t, iter2.Valid())
 	assert.Equal(t, iter2.Key(), []byte("a"))
@@ -155,3 +159,28 @@ func TestBPTree_Iterator(t *testing.T) {
 
 	DeleteBPTreeFile()
 }
+
+func TestBPTree_Remap(t *testing.T) {
+	bpt := NewBPlusTree(t.TempDir(), false)
+	defer bpt.Close()
+	bpt.Put([]byte("a"), &data.LogRecordPos{Fid: 0, Offset: 10})
+	bpt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	bpt.Put([]byte("c"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bpt.Put([]byte("d"), &data.LogRecordPos{Fid: 3, Offset: 40})
+
+	positions := map[string]*data.LogRecordPos{
+		"a": {Fid: 0, Offset: 0},
+		"b": {Fid: 0, Offset: 50},
+		"d": {Fid: 0, Offset: 100},
+	}
+	require.NoError(t, bpt.(*BPlusTree).Remap(3, positions))
+
+	require.Equal(t, int64(0), bpt.Get([]byte("a")).Offset)
+	require.Equal(t, int64(50), bpt.Get([]byte("b")).Offset)
+	// c未出现在merge结果中，已被丢弃
+	require.Nil(t, bpt.Get([]byte("c")))
+	// d位于未参与merge的文件中，保持不变
+	require.Equal(t, uint32(3), bpt.Get([]byte("d")).Fid)
+	require.Equal(t, int64(40), bpt.Get([]byte("d")).Offset)
+	require.Equal(t, 3, bpt.Size())
+}
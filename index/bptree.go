@@ -1,18 +1,35 @@
 package index
 
 import (
+	"encoding/binary"
 	"fmt"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/GGjahon/bitcask-kv/data"
 	"go.etcd.io/bbolt"
 )
 
 const (
-	btreeIndexFileName = "bptree-index"
+	btreeIndexFileName  = "bptree-index"
+	readOnlyOpenTimeout = time.Second
 )
 
-var indexBucketName = []byte("bitcask-index")
+var (
+	indexBucketName = []byte("bitcask-index")
+	metaBucketName  = []byte("bitcask-meta")
+	checkpointKey   = []byte("checkpoint")
+	remappedKey     = []byte("remapped")
+)
+
+// Checkpoint 已经应用到B+树索引中的数据在数据文件中的结束位置，以及此时的事务序列号
+// 启动时只需从该位置开始重放数据文件即可恢复索引
+type Checkpoint struct {
+	Fid    uint32
+	Offset int64
+	SeqNo  uint64
+}
 
 // AdaPtiveRadixTree  B+树索引 Index接口的B+树实现
 type BPlusTree struct {
@@ -23,16 +40,19 @@ func NewBPlusTree(dirpath string, sync bool) Index {
 	if dirpath == "bitcask-kv-data" {
 		dirpath = "../bitcask-kv-data"
 	}
-	opts := bbolt.DefaultOptions
+	opts := *bbolt.DefaultOptions
 	opts.NoSync = !sync
-	bpTree, err := bbolt.Open(filepath.Join(dirpath, btreeIndexFileName), 0644, opts)
+	bpTree, err := bbolt.Open(filepath.Join(dirpath, btreeIndexFileName), 0644, &opts)
 	if err != nil {
 		fmt.Println(err)
 		panic("failed to open bpTree,")
 	}
 	//为bpTree初始化bucket
 	if err := bpTree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
+		if _, err := tx.CreateBucketIfNotExists(indexBucketName); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(metaBucketName)
 		return err
 	}); err != nil {
 		panic("failed to create bucket in bptree")
@@ -43,15 +63,39 @@ func NewBPlusTree(dirpath string, sync bool) Index {
 	}
 }
 
+// NewReadOnlyBPlusTree 以只读模式打开已存在的B+树索引文件，可与其他只读进程共享，索引文件不存在时返回的错误满足os.IsNotExist
+func NewReadOnlyBPlusTree(dirpath string) (Index, error) {
+	opts := *bbolt.DefaultOptions
+	opts.ReadOnly = true
+	// 索引文件被其他进程以读写模式打开时不一直等待
+	opts.Timeout = readOnlyOpenTimeout
+	fileName := filepath.Join(dirpath, btreeIndexFileName)
+	// bbolt在文件不存在时会尝试创建
+	if _, err := os.Stat(fileName); err != nil {
+		return nil, err
+	}
+	bpTree, err := bbolt.Open(fileName, 0644, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return &BPlusTree{
+		Tree: bpTree,
+	}, nil
+}
+
 // Put the key into memory
-func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) bool {
+func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var oldPos *data.LogRecordPos
 	if err := bpt.Tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
+		if oldValue := bucket.Get(key); len(oldValue) != 0 {
+			oldPos = data.DecCodeLogRecordPos(oldValue)
+		}
 		return bucket.Put(key, data.EncCodeLogRecordPos(pos))
 	}); err != nil {
 		panic("failed to put value in bptree")
 	}
-	return true
+	return oldPos
 }
 
 // Get the key's data store pos in disk
@@ -71,19 +115,19 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 }
 
 // Delete. delete the key in memory
-func (bpt *BPlusTree) Delete(key []byte) bool {
-	var ok bool
+func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	var oldPos *data.LogRecordPos
 	if err := bpt.Tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
 		if value := bucket.Get(key); value != nil {
-			ok = true
+			oldPos = data.DecCodeLogRecordPos(value)
 			return bucket.Delete(key)
 		}
 		return nil
 	}); err != nil {
 		panic("failed to deleted value")
 	}
-	return ok
+	return oldPos, oldPos != nil
 }
 
 // Iterator
@@ -104,6 +148,162 @@ func (bpt *BPlusTree) Size() int {
 	return size
 }
 
+// Snapshot 在一个只读事务内将所有的key - pos复制到内存中的BTree中，
+// 避免长时间持有bbolt的只读事务阻塞写入
+func (bpt *BPlusTree) Snapshot() Index {
+	bt := NewBTree()
+	if err := bpt.Tree.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		return bucket.ForEach(func(k, v []byte) error {
+			key := make([]byte, len(k))
+			copy(key, k)
+			bt.Put(key, data.DecCodeLogRecordPos(v))
+			return nil
+		})
+	}); err != nil {
+		panic("failed to snapshot bptree")
+	}
+	return bt
+}
+
+// Checkpoint 读取索引中记录的checkpoint，未记录过时返回nil
+func (bpt *BPlusTree) Checkpoint() *Checkpoint {
+	var cp *Checkpoint
+	if err := bpt.Tree.View(func(tx *bbolt.Tx) error {
+		//只读模式下打开的旧版本索引文件可能不存在meta bucket
+		if bucket := tx.Bucket(metaBucketName); bucket != nil {
+			cp = decodeCheckpoint(bucket.Get(checkpointKey))
+		}
+		return nil
+	}); err != nil {
+		panic("failed to get checkpoint from bptree")
+	}
+	return cp
+}
+
+// Apply 在同一个事务内更新一组key的索引并记录checkpoint，positions中为nil的key将被删除，返回各key原有的位置
+func (bpt *BPlusTree) Apply(keys [][]byte, positions []*data.LogRecordPos, cp *Checkpoint) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(keys))
+	if err := bpt.Tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		for i, key := range keys {
+			if oldValue := bucket.Get(key); len(oldValue) != 0 {
+				oldPositions[i] = data.DecCodeLogRecordPos(oldValue)
+			}
+			var err error
+			if positions[i] == nil {
+				err = bucket.Delete(key)
+			} else {
+				err = bucket.Put(key, data.EncCodeLogRecordPos(positions[i]))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(metaBucketName).Put(checkpointKey, encodeCheckpoint(cp))
+	}); err != nil {
+		panic("failed to apply index in bptree")
+	}
+	return oldPositions
+}
+
+// Close 关闭B+树索引文件
+func (bpt *BPlusTree) Close() error {
+	return bpt.Tree.Close()
+}
+
+// Remap merge替换数据文件时，将位于merge过的数据文件(fid < noMergeFid)中的key更新为merge后的位置，
+// 不在positions中的key说明已在merge时被丢弃，直接删除。所有修改在同一个事务内完成，返回前确保已持久化
+func (bpt *BPlusTree) Remap(noMergeFid uint32, positions map[string]*data.LogRecordPos) error {
+	if err := bpt.Tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		//遍历过程中不能修改bucket，先找出需要更新的key
+		var mergedKeys [][]byte
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if data.DecCodeLogRecordPos(v).Fid < noMergeFid {
+				key := make([]byte, len(k))
+				copy(key, k)
+				mergedKeys = append(mergedKeys, key)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, key := range mergedKeys {
+			pos, ok := positions[string(key)]
+			if !ok {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := bucket.Put(key, data.EncCodeLogRecordPos(pos)); err != nil {
+				return err
+			}
+		}
+		//checkpoint位于被merge过的文件中时，该位置在merge后的文件中已无意义，之前的数据均已应用，从未参与merge的文件开始即可
+		metaBucket := tx.Bucket(metaBucketName)
+		if cp := decodeCheckpoint(metaBucket.Get(checkpointKey)); cp != nil && cp.Fid < noMergeFid {
+			if err := metaBucket.Put(checkpointKey, encodeCheckpoint(&Checkpoint{Fid: noMergeFid, SeqNo: cp.SeqNo})); err != nil {
+				return err
+			}
+		}
+		//记录已完成更新的merge，启动时据此判断是否需要重新执行
+		return metaBucket.Put(remappedKey, binary.AppendUvarint(nil, uint64(noMergeFid)))
+	}); err != nil {
+		return err
+	}
+	//未开启同步写入时，事务提交不会持久化，需要手动持久化后才能替换数据文件
+	if bpt.Tree.NoSync {
+		return bpt.Tree.Sync()
+	}
+	return nil
+}
+
+// RemappedFid 返回最近一次Remap时未参与merge的第一个文件id，从未执行过Remap时返回false
+func (bpt *BPlusTree) RemappedFid() (uint32, bool) {
+	var fid uint64
+	var ok bool
+	if err := bpt.Tree.View(func(tx *bbolt.Tx) error {
+		if bucket := tx.Bucket(metaBucketName); bucket != nil {
+			if buf := bucket.Get(remappedKey); buf != nil {
+				fid, _ = binary.Uvarint(buf)
+				ok = true
+			}
+		}
+		return nil
+	}); err != nil {
+		panic("failed to get remapped fid from bptree")
+	}
+	return uint32(fid), ok
+}
+
+func encodeCheckpoint(cp *Checkpoint) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*2)
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(cp.Fid))
+	index += binary.PutVarint(buf[index:], cp.Offset)
+	index += binary.PutUvarint(buf[index:], cp.SeqNo)
+	return buf[:index]
+}
+
+func decodeCheckpoint(buf []byte) *Checkpoint {
+	if len(buf) == 0 {
+		return nil
+	}
+	var index = 0
+	fid, n := binary.Varint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	seqNo, _ := binary.Uvarint(buf[index:])
+	return &Checkpoint{
+		Fid:    uint32(fid),
+		Offset: offset,
+		SeqNo:  seqNo,
+	}
+}
+
 // bptTeeIterator B+Tree索引迭代器实例
 type bptIterator struct {
 	tx        *bbolt.Tx
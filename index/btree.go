@@ -21,15 +21,18 @@ func NewBTree() Index {
 		lock: new(sync.RWMutex),
 	}
 }
-func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) bool {
+func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	item := &Item{
 		key: key,
 		pos: pos,
 	}
 	bt.lock.Lock()
 	defer bt.lock.Unlock()
-	bt.tree.ReplaceOrInsert(item)
-	return true
+	oldItem := bt.tree.ReplaceOrInsert(item)
+	if oldItem == nil {
+		return nil
+	}
+	return oldItem.(*Item).pos
 }
 
 // Get the key's data store pos in disk
@@ -47,13 +50,15 @@ func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 }
 
 // Delete ,delete the key in memory
-func (bt *BTree) Delete(key []byte) bool {
+func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	item := &Item{key: key}
 	bt.lock.Lock()
 	defer bt.lock.Unlock()
 	oldItem := bt.tree.Delete(item)
-
-	return oldItem != nil
+	if oldItem == nil {
+		return nil, false
+	}
+	return oldItem.(*Item).pos, true
 }
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
@@ -67,6 +72,21 @@ func (bt *BTree) Size() int {
 	return bt.tree.Len()
 }
 
+// Snapshot btree支持写时复制，Clone的开销很小。Clone会修改原树的内部状态，需要加写锁
+func (bt *BTree) Snapshot() Index {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}
+}
+
+// Close 内存索引无需释放资源
+func (bt *BTree) Close() error {
+	return nil
+}
+
 // btreeIterator BTree索引迭代器实例
 type btreeIterator struct {
 	//当前遍历的下标位置
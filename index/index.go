@@ -19,20 +19,26 @@ const (
 
 // Index give all method for operating the key of data in memory.Every different could implement own method with this interface
 type Index interface {
-	// Put the key into memory
-	Put(key []byte, pos *data.LogRecordPos) bool
+	// Put the key into memory, return the old pos of the key if it exists
+	Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
 
 	// Get the key's data store pos in disk
 	Get(key []byte) *data.LogRecordPos
 
-	// Delete ,delete the key in memory
-	Delete(key []byte) bool
+	// Delete ,delete the key in memory, return the old pos of the key and whether it exists
+	Delete(key []byte) (*data.LogRecordPos, bool)
 
 	//Iterator
 	Iterator(reverse bool) Iterator
 
 	//Size
 	Size() int
+
+	//Snapshot 获取索引在当前时刻的只读副本，之后对索引的修改不会影响该副本
+	Snapshot() Index
+
+	//Close 关闭索引，释放占用的资源
+	Close() error
 }
 
 type Item struct {
@@ -43,14 +49,19 @@ type Item struct {
 func (ai *Item) Less(bi btree.Item) bool {
 	return bytes.Compare(ai.key, bi.(*Item).key) == -1
 }
-func NewIndex(typ IndexTypes, dirpath string, sync bool) Index {
+
+// NewIndex 创建索引，只有只读打开B+树索引时可能返回错误
+func NewIndex(typ IndexTypes, dirpath string, sync bool, readOnly bool) (Index, error) {
 	switch typ {
 	case Btree:
-		return NewBTree()
+		return NewBTree(), nil
 	case ARtree:
-		return NewAdaPtiveRadixTree()
+		return NewAdaPtiveRadixTree(), nil
 	case BPtree:
-		return NewBPlusTree(dirpath, sync)
+		if readOnly {
+			return NewReadOnlyBPlusTree(dirpath)
+		}
+		return NewBPlusTree(dirpath, sync), nil
 	default:
 		panic("unsupported index type")
 	}
@@ -0,0 +1,301 @@
+package bitcaskkv
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/index"
+	"github.com/GGjahon/bitcask-kv/utils"
+)
+
+// 索引快照文件中第一条记录的key，其value为快照的头部信息
+const indexSnapshotKey = "index.snapshot"
+
+// 写入索引快照时每积累该大小的数据写入一次文件
+const indexSnapshotBufSize = 1024 * 1024
+
+// indexSnapshotHeader 索引快照的头部信息
+// 快照包含了数据文件中(fid,offset)之前的全部数据，启动时只需从该位置继续加载
+type indexSnapshotHeader struct {
+	fid          uint32
+	offset       int64
+	seqNo        uint64
+	reclaimSizes map[uint32]int64 // 各数据文件中可回收的数据量
+	keyNum       uint64
+}
+
+// encode 编码头部信息，各数据文件的可回收数据量记录在末尾
+func (h *indexSnapshotHeader) encode() []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*4+len(h.reclaimSizes)*(binary.MaxVarintLen32+binary.MaxVarintLen64))
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(h.fid))
+	index += binary.PutVarint(buf[index:], h.offset)
+	index += binary.PutUvarint(buf[index:], h.seqNo)
+	index += binary.PutUvarint(buf[index:], h.keyNum)
+	index += binary.PutUvarint(buf[index:], uint64(len(h.reclaimSizes)))
+	for fid, size := range h.reclaimSizes {
+		index += binary.PutVarint(buf[index:], int64(fid))
+		index += binary.PutVarint(buf[index:], size)
+	}
+	return buf[:index]
+}
+
+func decodeIndexSnapshotHeader(buf []byte) (*indexSnapshotHeader, error) {
+	h := &indexSnapshotHeader{}
+	var index = 0
+	fid, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	index += n
+	keyNum, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	index += n
+	num, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	index += n
+	h.fid, h.offset, h.seqNo, h.keyNum = uint32(fid), offset, seqNo, keyNum
+	h.reclaimSizes = make(map[uint32]int64, num)
+	for i := uint64(0); i < num; i++ {
+		fid, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		index += n
+		size, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		index += n
+		h.reclaimSizes[uint32(fid)] = size
+	}
+	return h, nil
+}
+
+// startIndexSnapshot 启动后台goroutine，定期保存内存索引的快照，B+树索引本身已持久化，无需保存
+func (db *DB) startIndexSnapshot() {
+	if db.IndexType == index.BPtree || db.ReadOnly || db.IndexSnapshotInterval < 0 {
+		return
+	}
+	db.runInBackground(db.IndexSnapshotInterval, func() {
+		// 保存失败时等待下一次保存即可，启动时快照缺失会退回全量加载
+		_ = db.saveIndexSnapshot()
+	})
+}
+
+// saveIndexSnapshot 在后台保存索引快照，仅在获取快照时持有锁，写入文件期间不阻塞读写
+func (db *DB) saveIndexSnapshot() error {
+	db.mu.Lock()
+	idx, header, err := db.captureIndexSnapshot()
+	mergeVersion := db.mergeVersion
+	db.mu.Unlock()
+	if err != nil || idx == nil {
+		return err
+	}
+	err = db.writeIndexSnapshot(idx, header)
+	_ = idx.Close()
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tempFileName := data.GetIndexSnapshotTempFileName(db.DirPath)
+	// 写入期间完成了merge，快照中的数据位置已经失效
+	if db.mergeVersion != mergeVersion {
+		return os.Remove(tempFileName)
+	}
+	return db.installIndexSnapshot()
+}
+
+// saveIndexSnapshotLocked 关闭db时保存索引快照，调用方需持有db.mu
+func (db *DB) saveIndexSnapshotLocked() error {
+	idx, header, err := db.captureIndexSnapshot()
+	if err != nil || idx == nil {
+		return err
+	}
+	defer idx.Close()
+	if err := db.writeIndexSnapshot(idx, header); err != nil {
+		return err
+	}
+	return db.installIndexSnapshot()
+}
+
+// installIndexSnapshot 将写入完成的临时文件重命名为索引快照文件
+func (db *DB) installIndexSnapshot() error {
+	if err := os.Rename(data.GetIndexSnapshotTempFileName(db.DirPath), filepath.Join(db.DirPath, data.IndexSnapshotFileName)); err != nil {
+		return err
+	}
+	return utils.SyncDir(db.DirPath)
+}
+
+// captureIndexSnapshot 获取当前索引的副本及对应的数据文件写入位置，调用方需持有db.mu
+func (db *DB) captureIndexSnapshot() (index.Index, *indexSnapshotHeader, error) {
+	if db.activeFile == nil {
+		return nil, nil, nil
+	}
+	// 快照记录的写入位置之前的数据必须已经持久化，否则重启后可能缺失快照中引用的数据
+	if err := db.syncActiveFile(); err != nil {
+		return nil, nil, err
+	}
+	idx := db.index.Snapshot()
+	return idx, &indexSnapshotHeader{
+		fid:          db.activeFile.FileID,
+		offset:       db.activeFile.WriteOff,
+		seqNo:        db.seqNo,
+		reclaimSizes: copyReclaimSizes(db.reclaimSizes),
+		keyNum:       uint64(idx.Size()),
+	}, nil
+}
+
+func copyReclaimSizes(reclaimSizes map[uint32]int64) map[uint32]int64 {
+	sizes := make(map[uint32]int64, len(reclaimSizes))
+	for fid, size := range reclaimSizes {
+		sizes[fid] = size
+	}
+	return sizes
+}
+
+// writeIndexSnapshot 将索引写入临时文件并持久化，由调用方重命名为快照文件
+func (db *DB) writeIndexSnapshot(idx index.Index, header *indexSnapshotHeader) error {
+	tempFileName := data.GetIndexSnapshotTempFileName(db.DirPath)
+	// 清理上次未完成的临时文件
+	if err := os.RemoveAll(tempFileName); err != nil {
+		return err
+	}
+	snapshotFile, err := data.OpenIndexSnapshotTempFile(db.DirPath, db.ioOptions()...)
+	if err != nil {
+		return err
+	}
+	defer snapshotFile.Close()
+
+	buf, _ := data.EnCodeLogRecord(&data.LogRecord{
+		Key:   []byte(indexSnapshotKey),
+		Value: header.encode(),
+	})
+	iter := idx.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		buf = append(buf, data.EncPosLogRecordWithKeyAndPos(iter.Key(), iter.Value())...)
+		if len(buf) >= indexSnapshotBufSize {
+			if err := snapshotFile.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if err := snapshotFile.Write(buf); err != nil {
+		return err
+	}
+	return snapshotFile.Sync()
+}
+
+// loadIndexFromSnapshot 从索引快照中加载索引，返回快照的头部信息
+// 快照不存在或已损坏时返回nil，由调用方退回全量加载
+func (db *DB) loadIndexFromSnapshot() *indexSnapshotHeader {
+	if _, err := os.Stat(filepath.Join(db.DirPath, data.IndexSnapshotFileName)); err != nil {
+		return nil
+	}
+	header, err := db.readIndexSnapshot()
+	if err != nil {
+		// 丢弃读取了一部分的索引
+		_ = db.index.Close()
+		// 索引快照只用于内存索引，创建时不会失败
+		db.index, _ = index.NewIndex(db.IndexType, db.DirPath, db.SyncWrites, db.ReadOnly)
+		db.reclaimSizes = make(map[uint32]int64)
+		return nil
+	}
+	return header
+}
+
+func (db *DB) readIndexSnapshot() (*indexSnapshotHeader, error) {
+	snapshotFile, err := data.OpenIndexSnapshotFile(db.DirPath, db.loadIOType(), db.ioOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshotFile.Close()
+
+	encRecord, offset, recordHeader, err := snapshotFile.Get(0)
+	if err != nil {
+		return nil, err
+	}
+	headerRecord, err := data.DecodeLogRecord(encRecord, recordHeader)
+	if err != nil {
+		return nil, err
+	}
+	if string(headerRecord.Key) != indexSnapshotKey {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	header, err := decodeIndexSnapshotHeader(headerRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	// 快照中记录的写入位置必须存在于当前的数据文件中
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileID == header.fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[header.fid]
+	}
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if header.offset > fileSize {
+		return nil, ErrDataDirectoryCorrupted
+	}
+
+	now := time.Now().UnixNano()
+	var keyNum uint64
+	for {
+		encPosRecord, size, posRecordHeader, err := snapshotFile.Get(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		posRecord, err := data.DecodeLogRecord(encPosRecord, posRecordHeader)
+		if err != nil {
+			return nil, err
+		}
+		pos := data.DecCodeLogRecordPos(posRecord.Value)
+		// 快照保存后过期的数据不再加载，计入可回收的数据量
+		if isExpired(pos.ExpireAt, now) {
+			db.addReclaimSize(pos)
+		} else {
+			db.index.Put(posRecord.Key, pos)
+		}
+		keyNum++
+		offset += size
+	}
+	if keyNum != header.keyNum {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	db.seqNo = header.seqNo
+	for fid, size := range header.reclaimSizes {
+		db.reclaimSizes[fid] += size
+	}
+	return header, nil
+}
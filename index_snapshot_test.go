@@ -0,0 +1,132 @@
+package bitcaskkv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/index"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexSnapshotReload(t *testing.T) {
+	for _, indexType := range []index.IndexTypes{index.Btree, index.ARtree} {
+		dirPath := t.TempDir()
+		opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024), WithDBIndexType(indexType)}
+		db, err := Open(opts...)
+		require.NoError(t, err)
+		for i := 0; i < 200; i++ {
+			require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+		}
+		for i := 0; i < 50; i++ {
+			require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+		}
+		reclaimSize := db.reclaimSizes
+		require.NoError(t, db.Close())
+		_, err = os.Stat(filepath.Join(dirPath, data.IndexSnapshotFileName))
+		require.NoError(t, err)
+
+		db, err = Open(opts...)
+		require.NoError(t, err)
+		require.Equal(t, 150, db.index.Size())
+		require.Equal(t, reclaimSize, db.reclaimSizes)
+
+		// 模拟后台保存快照后继续写入，进程退出前未能再次保存快照
+		snapshotFileName := filepath.Join(dirPath, data.IndexSnapshotFileName)
+		require.NoError(t, db.saveIndexSnapshot())
+		content, err := os.ReadFile(snapshotFileName)
+		require.NoError(t, err)
+		for i := 200; i < 250; i++ {
+			require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("jahoon")))
+		}
+		require.NoError(t, db.Delete(utils.GetRandomKey(100)))
+		seqNo := db.seqNo
+		require.NoError(t, db.Close())
+		require.NoError(t, os.WriteFile(snapshotFileName, content, 0644))
+
+		db, err = Open(opts...)
+		require.NoError(t, err)
+		require.Equal(t, 199, db.index.Size())
+		require.Equal(t, seqNo, db.seqNo)
+		_, err = db.Get(utils.GetRandomKey(100))
+		require.ErrorIs(t, err, ErrKeyIsNotFound)
+		value, err := db.Get(utils.GetRandomKey(220))
+		require.NoError(t, err)
+		require.Equal(t, []byte("jahoon"), value)
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestIndexSnapshotCorrupted(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.NoError(t, db.Close())
+
+	snapshotFileName := filepath.Join(dirPath, data.IndexSnapshotFileName)
+	content, err := os.ReadFile(snapshotFileName)
+	require.NoError(t, err)
+	// 截断快照文件，丢失部分索引后需要退回全量加载
+	require.NoError(t, os.WriteFile(snapshotFileName, content[:len(content)/2], 0644))
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 200, db.index.Size())
+	require.NoError(t, db.Close())
+
+	// 篡改快照中的数据，CRC校验失败
+	content, err = os.ReadFile(snapshotFileName)
+	require.NoError(t, err)
+	content[len(content)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(snapshotFileName, content, 0644))
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 200, db.index.Size())
+	for i := 0; i < 200; i++ {
+		_, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestIndexSnapshotRemovedByMerge(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.NoError(t, db.saveIndexSnapshot())
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	require.NoError(t, db.Merge())
+	_, err = os.Stat(filepath.Join(dirPath, data.IndexSnapshotFileName))
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 100, db.index.Size())
+	for i := 200; i < 300; i++ {
+		_, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestIndexSnapshotHeader(t *testing.T) {
+	header := &indexSnapshotHeader{fid: 3, offset: 1024, seqNo: 7, keyNum: 100, reclaimSizes: map[uint32]int64{0: 64, 2: 128}}
+	decoded, err := decodeIndexSnapshotHeader(header.encode())
+	require.NoError(t, err)
+	require.Equal(t, header, decoded)
+
+	_, err = decodeIndexSnapshotHeader(header.encode()[:5])
+	require.ErrorIs(t, err, ErrDataDirectoryCorrupted)
+}
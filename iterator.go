@@ -2,6 +2,7 @@ package bitcaskkv
 
 import (
 	"bytes"
+	"time"
 
 	"github.com/GGjahon/bitcask-kv/index"
 )
@@ -10,6 +11,8 @@ type Iterator struct {
 	Options   IterOptions
 	indexIter index.Iterator
 	db        *DB
+	snap      *Snapshot //从快照中读取数据，保证merge替换数据文件后依然可以读取迭代器创建时的数据
+	ownSnap   bool      //快照是否由迭代器自身创建，迭代器关闭时需要释放
 }
 
 func (db *DB) NewIterator(opts ...IterOption) *Iterator {
@@ -25,7 +28,12 @@ func (db *DB) NewIterator(opts ...IterOption) *Iterator {
 		opt(&itertor.Options)
 	}
 
+	// 索引迭代器中保存的是创建时的数据位置，需要同时持有当前的数据文件，避免merge替换数据文件后读取到错误的数据
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	itertor.indexIter = db.index.Iterator(itertor.Options.Reverse)
+	itertor.snap = db.pinDataFiles()
+	itertor.ownSnap = true
 	return itertor
 }
 
@@ -63,26 +71,34 @@ func (it *Iterator) Value() ([]byte, error) {
 	if logRecordPos == nil {
 		return nil, ErrKeyIsNotFound
 	}
-	it.db.mu.RLock()
-	defer it.db.mu.RUnlock()
-	return it.db.getLogRecordValue(logRecordPos)
+	it.snap.mu.RLock()
+	defer it.snap.mu.RUnlock()
+	if it.snap.released {
+		return nil, ErrSnapshotReleased
+	}
+	return it.snap.getLogRecordValue(logRecordPos)
 }
 
 // Close() 关闭迭代器，释放占用资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.ownSnap {
+		it.snap.Release()
+	}
 }
 
-// skipToNext() 根据用户传入的prefix进行key的筛选
+// skipToNext() 根据用户传入的prefix进行key的筛选，并跳过已过期的key
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.Options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
+	now := time.Now().UnixNano()
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Equal(it.Options.Prefix, key[:prefixLen]) {
-			break
+		if prefixLen > 0 && (prefixLen > len(key) || !bytes.Equal(it.Options.Prefix, key[:prefixLen])) {
+			continue
+		}
+		if isExpired(it.indexIter.Value().ExpireAt, now) {
+			continue
 		}
+		break
 	}
 }
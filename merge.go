@@ -7,17 +7,27 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/index"
+	"github.com/GGjahon/bitcask-kv/utils"
 )
 
 const (
-	mergerDirName   = "-merge"
-	mergeFinshedKey = "merge.finished"
+	mergerDirName    = "-merge"
+	mergeFinshedKey  = "merge.finished"
+	mergedFileNumKey = "merge.file.num"
 )
 
 // Merge 清除OldFiles中的无效数据，将数据文件整合， 生成Hint文件
+// merge完成后立即替换旧的数据文件并更新内存索引，期间db可以正常读写
 func (db *DB) Merge() error {
+	if db.ReadOnly {
+		return ErrReadOnly
+	}
 	//若oldFiles文件数量为0,则直接返沪
 	if len(db.olderFiles) == 0 {
 		return nil
@@ -30,8 +40,13 @@ func (db *DB) Merge() error {
 	}
 	//修改标识位，标志当前有merge操作正在进行
 	db.isMerging = true
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
 	//持久化当前的activeFile，将当前activeFile添加进oldFileMap中，打开新的activeFile，记录其id
-	if err := db.activeFile.Sync(); err != nil {
+	if err := db.syncActiveFile(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
@@ -53,127 +68,351 @@ func (db *DB) Merge() error {
 		return mergeFiles[i].FileID < mergeFiles[j].FileID
 	})
 
-	//打开新的mergeDB实例，进行merge操作，打开前需要查看记录数据的同一目录下是否存在merge目录，若存在，则删除
 	mergePath := db.getMergePath()
+	mergedFileNum, expiredKeys, err := db.rewriteMergeFiles(mergePath, mergeFiles, noMergeFileID)
+	if err != nil {
+		return err
+	}
+	return db.installMergeFiles(mergePath, noMergeFileID, mergedFileNum, expiredKeys)
+}
+
+// rewriteMergeFiles 将mergeFiles中的有效数据重写至merge目录下，并生成hint文件和merge完成标志文件
+// 返回merge后数据文件的数量，以及merge时因过期而被丢弃的key
+func (db *DB) rewriteMergeFiles(mergePath string, mergeFiles []*data.DataFile, noMergeFileID uint32) (uint32, [][]byte, error) {
+	//merge前需要查看记录数据的同一目录下是否存在merge目录，若存在，则删除后重新创建
 	if _, err := os.Stat(mergePath); err == nil {
-		//说明此前存在过merge操作，将该目录删除，在之后创建mergeDB实例时自行创建
-		if err := os.Remove(mergePath); err != nil {
-			return err
+		//说明此前存在过merge操作，将该目录删除
+		if err := os.RemoveAll(mergePath); err != nil {
+			return 0, nil, err
 		}
 	}
-	mergeDB, err := Open(WithDBDirPath(mergePath))
-	if err != nil {
-		return err
+	if err := os.Mkdir(mergePath, os.ModePerm); err != nil {
+		return 0, nil, err
 	}
+	//merge后的数据文件直接写入merge目录，文件id从0开始连续递增
+	var mergeFile *data.DataFile
+	var mergedFileNum uint32 = 0
+	defer func() {
+		if mergeFile != nil {
+			_ = mergeFile.Close()
+		}
+	}()
 	//生成hint文件，保存索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(mergePath, fio.StandardFIO, db.ioOptions()...)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
+	defer hintFile.Close()
+	now := time.Now().UnixNano()
+	var expiredKeys [][]byte
 	//遍历需要merge的文件，读取保存的数据
 	for _, file := range mergeFiles {
 		var offset int64 = 0
 		for {
 			encLogRecord, size, logRecordHeader, err := file.Get(offset)
 			if err != nil {
-				return err
+				if err == io.EOF {
+					break
+				}
+				return 0, nil, err
 			}
 			logRecord, err := data.DecodeLogRecord(encLogRecord, logRecordHeader)
 			if err != nil {
-				return err
+				return 0, nil, err
 			}
 			//获取真正的key
-			realKey, _ := parseLogRecordKey(logRecord.Key)
-			//判断当前logRecord是否是有效数据
+			realKey, _ := ParseLogRecordKey(logRecord.Key)
+			//判断当前logRecord是否是有效数据，已过期的数据直接丢弃
 			pos := db.index.Get(realKey)
 			if pos != nil && pos.Fid == file.FileID && pos.Offset == offset {
+				if isExpired(logRecord.ExpireAt, now) {
+					//已过期的数据不再写入，替换数据文件时需要将其从索引中移除
+					expiredKeys = append(expiredKeys, realKey)
+					offset += size
+					continue
+				}
 				//代表当前数据的存储位置与内存索引中存储的值一致,为有效数据，将其写入到mergeFile中
-				//写入前去掉之前key包含的事务id
+				//写入前去掉之前key包含的事务id，保留原有的写入时间，blob记录只重写其位置，不重写blob文件
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				logRecordPos, err := mergeDB.appendLogRecord(logRecord)
-				if err != nil {
-					return err
+				//使用当前配置的压缩方式重新写入
+				logRecord.Compression = db.Compression
+				encMergeRecord, mergeRecordSize := data.EnCodeLogRecord(logRecord)
+				//当前merge文件写满后，持久化并打开下一个merge文件
+				if mergeFile == nil || mergeFile.WriteOff > 0 && mergeFile.WriteOff+mergeRecordSize > db.MaxDataFileSize {
+					if mergeFile != nil {
+						if err := mergeFile.Sync(); err != nil {
+							return 0, nil, err
+						}
+						if err := mergeFile.Close(); err != nil {
+							return 0, nil, err
+						}
+					}
+					if mergeFile, err = data.OpenDataFile(mergePath, mergedFileNum, fio.StandardFIO, db.ioOptions()...); err != nil {
+						return 0, nil, err
+					}
+					mergedFileNum++
+				}
+				writeOff := mergeFile.WriteOff
+				if err := mergeFile.Write(encMergeRecord); err != nil {
+					return 0, nil, err
 				}
+				logRecordPos := newLogRecordPos(mergeFile.FileID, writeOff, mergeRecordSize, logRecord)
 
 				//将当前数据的key和索引信息组合成logRecord形式，进行编码
 				encPosRecord := data.EncPosLogRecordWithKeyAndPos(realKey, logRecordPos)
 				if err := hintFile.Write(encPosRecord); err != nil {
-					return err
+					return 0, nil, err
 				}
 			}
 			offset += size
 		}
 
 	}
-	//遍历完成后，进行mergeDB的持久化，创建标志merge完成的FinishFile
+	//遍历完成后，持久化merge文件和hint文件，创建标志merge完成的FinishFile
 	if err := hintFile.Sync(); err != nil {
-		return err
+		return 0, nil, err
 	}
-	if err := mergeDB.Sync(); err != nil {
-		return err
+	if mergeFile != nil {
+		if err := mergeFile.Sync(); err != nil {
+			return 0, nil, err
+		}
 	}
-	finishFile, err := data.OpenMergeFinishedFile(mergePath)
+	finishFile, err := data.OpenMergeFinishedFile(mergePath, fio.StandardFIO, db.ioOptions()...)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
+	defer finishFile.Close()
 	mergeDoneRecord := &data.LogRecord{
 		Key:   []byte(mergeFinshedKey),
 		Value: []byte(strconv.Itoa(int(noMergeFileID))),
 	}
 	encMergeDoneRecord, _ := data.EnCodeLogRecord(mergeDoneRecord)
-	if err := finishFile.Write(encMergeDoneRecord); err != nil {
+	mergedFileNumRecord := &data.LogRecord{
+		Key:   []byte(mergedFileNumKey),
+		Value: []byte(strconv.Itoa(int(mergedFileNum))),
+	}
+	encMergedFileNumRecord, _ := data.EnCodeLogRecord(mergedFileNumRecord)
+	if err := finishFile.Write(append(encMergeDoneRecord, encMergedFileNumRecord...)); err != nil {
+		return 0, nil, err
+	}
+	if err := finishFile.Sync(); err != nil {
+		return 0, nil, err
+	}
+	//持久化merge目录，保证merge完成标志文件及hint文件在宕机后依然存在
+	if err := utils.SyncDir(mergePath); err != nil {
+		return 0, nil, err
+	}
+	return mergedFileNum, expiredKeys, nil
+}
+
+// installMergeFiles 将merge目录下的文件替换至数据目录，并将内存索引指向merge后的数据位置
+func (db *DB) installMergeFiles(mergePath string, noMergeFileId, mergedFileNum uint32, expiredKeys [][]byte) error {
+	//替换前先读取hint文件，并打开merge后的数据文件，文件重命名后已打开的文件依然可以正常读取
+	var mergedKeys [][]byte
+	var mergedPositions []*data.LogRecordPos
+	if err := db.foreachHintRecord(mergePath, fio.StandardFIO, func(key []byte, pos *data.LogRecordPos) {
+		mergedKeys = append(mergedKeys, key)
+		mergedPositions = append(mergedPositions, pos)
+	}); err != nil {
+		return err
+	}
+	mergedFiles := make(map[uint32]*data.DataFile, mergedFileNum)
+	var mergedSize int64
+	for fid := uint32(0); fid < mergedFileNum; fid++ {
+		dataFile, err := data.OpenDataFile(mergePath, fid, fio.StandardFIO, db.ioOptions()...)
+		if err == nil {
+			var size int64
+			size, err = dataFile.IoManager.Size()
+			mergedSize += size
+			mergedFiles[fid] = dataFile
+		}
+		if err != nil {
+			closeDataFiles(mergedFiles)
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.moveMergeFiles(mergePath, noMergeFileId, mergedFileNum); err != nil {
+		closeDataFiles(mergedFiles)
 		return err
 	}
-	return finishFile.Sync()
+	//B+树索引持久化在磁盘中，在文件移动完成后更新为merge后的位置，进程在此之前退出时启动后会重新执行
+	//更新失败时继续使用已打开的旧数据文件，其中的位置与B+树索引一致
+	bpt, isBPTree := db.index.(*index.BPlusTree)
+	if isBPTree {
+		positions := make(map[string]*data.LogRecordPos, len(mergedKeys))
+		for i, key := range mergedKeys {
+			positions[string(key)] = mergedPositions[i]
+		}
+		if err := bpt.Remap(noMergeFileId, positions); err != nil {
+			closeDataFiles(mergedFiles)
+			return err
+		}
+	}
+
+	//使用merge后的数据文件替换旧的数据文件
+	var obsoleteSize int64
+	for fid, dataFile := range db.olderFiles {
+		if fid >= noMergeFileId {
+			continue
+		}
+		if size, err := dataFile.IoManager.Size(); err == nil {
+			obsoleteSize += size
+		}
+		db.obsoleteFiles = append(db.obsoleteFiles, dataFile)
+		delete(db.olderFiles, fid)
+	}
+	for fid, dataFile := range mergedFiles {
+		db.olderFiles[fid] = dataFile
+	}
+
+	//内存索引在替换数据文件后再更新，merge期间被重新写入或删除的key，其索引已不再指向被merge的文件，无需更新
+	if !isBPTree {
+		for i, key := range mergedKeys {
+			if pos := db.index.Get(key); pos != nil && pos.Fid < noMergeFileId {
+				db.index.Put(key, mergedPositions[i])
+			}
+		}
+		for _, key := range expiredKeys {
+			if pos := db.index.Get(key); pos != nil && pos.Fid < noMergeFileId {
+				db.index.Delete(key)
+			}
+		}
+	}
+
+	//被merge的文件中的无效数据已经清除，merge期间被覆盖或删除的数据依然写入了merge后的文件，仍可回收
+	var reclaimSize int64
+	for fid, size := range db.reclaimSizes {
+		if fid < noMergeFileId {
+			reclaimSize += size
+			delete(db.reclaimSizes, fid)
+		}
+	}
+	if reclaimSize -= obsoleteSize - mergedSize; reclaimSize > 0 && mergedFileNum > 0 {
+		db.reclaimSizes[0] = reclaimSize
+	}
+	//存在未释放的快照时，旧的数据文件可能仍在被读取，待快照全部释放后再关闭
+	if db.snapshotNum == 0 {
+		return db.closeObsoleteFiles()
+	}
+	return nil
+}
+
+// closeObsoleteFiles 关闭merge后不再使用的旧数据文件
+func (db *DB) closeObsoleteFiles() error {
+	obsoleteFiles := db.obsoleteFiles
+	db.obsoleteFiles = nil
+	for _, dataFile := range obsoleteFiles {
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countDataFiles 统计目录下数据文件的数量
+func countDataFiles(dirPath string) (uint32, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	var num uint32
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			num++
+		}
+	}
+	return num, nil
+}
+
+func closeDataFiles(dataFiles map[uint32]*data.DataFile) {
+	for _, dataFile := range dataFiles {
+		_ = dataFile.Close()
+	}
 }
 
 func (db *DB) getMergePath() string {
-	dir := path.Dir(path.Clean(db.Options.DirPath))
-	base := path.Base(db.Options.DirPath)
+	return getMergePath(db.Options.DirPath)
+}
+
+// getMergePath merge目录与数据目录位于同一父目录下
+func getMergePath(dirPath string) string {
+	dir := path.Dir(path.Clean(dirPath))
+	base := path.Base(dirPath)
 	return filepath.Join(dir, base+mergerDirName)
 }
 
-// loadMergeFiles 判断上次的merge是否完成，若完成，将merge过的数据文件进行删除，
-// 将merge临时目录下的文件转移到db真正存储数据的目录进行替换
+// loadMergeFiles 判断上次的merge是否完成，若完成，继续将merge临时目录下的文件转移到db真正存储数据的目录进行替换
+// 正常情况下merge完成时已经完成替换，只有替换过程中进程退出才需要在启动时继续完成
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	//判断当前是否存在有mergePath
-	if _, err := os.Stat(mergePath); os.IsExist(err) {
+	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
-	//在读取hint文件完成后，将merge目录删除
-	defer func() {
-		os.RemoveAll(mergePath)
-	}()
-
-	//读取mergePath下的所有文件
-	dirEntries, err := os.ReadDir(mergePath)
+	//若不存在mergeFinished标志文件，说明上次merge未完成，直接删除merge目录即可
+	if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		return os.RemoveAll(mergePath)
+	}
+	//获取此前未进行merge的activeFileID
+	noMergeFileId, err := db.getNoMergeFileId(mergePath)
 	if err != nil {
 		return err
 	}
-	var mergeFinished bool
-	var mergeFileNames []string
-	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinishedFileName {
-			mergeFinished = true
-		}
-		if entry.Name() == data.SeqNoFileName {
-			continue
-		}
-		mergeFileNames = append(mergeFileNames, entry.Name())
+	mergedFileNum, err := db.getMergedFileNum(mergePath)
+	if err != nil {
+		return err
 	}
-	//若不存在mergeFinished标志文件，说明上次merge未完成，则直接返回即可
-	if !mergeFinished {
+	return db.moveMergeFiles(mergePath, noMergeFileId, mergedFileNum)
+}
+
+// remapBPTreeIndex B+树索引在merge的文件移动完成后才更新，进程在两者之间退出时，启动后根据hint文件重新更新
+func (db *DB) remapBPTreeIndex() error {
+	bpt, ok := db.index.(*index.BPlusTree)
+	if !ok {
 		return nil
 	}
-	//获取此前未进行merge的activeFileID
-	noMergeFileId, err := db.getNoMergeFileId(mergePath)
+	if _, err := os.Stat(filepath.Join(db.DirPath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		return nil
+	}
+	noMergeFileId, err := db.getNoMergeFileId(db.DirPath)
 	if err != nil {
 		return err
 	}
-	//将merge过的数据文件进行删除操作
-	var fileId uint32 = 0
-	for ; fileId < noMergeFileId; fileId++ {
+	//merge时总会切换活跃文件，每次merge的noMergeFileId都大于之前的值
+	if remappedFid, ok := bpt.RemappedFid(); ok && remappedFid >= noMergeFileId {
+		return nil
+	}
+	positions := make(map[string]*data.LogRecordPos)
+	if err := db.foreachHintRecord(db.DirPath, fio.StandardFIO, func(key []byte, pos *data.LogRecordPos) {
+		positions[string(key)] = pos
+	}); err != nil {
+		return err
+	}
+	return bpt.Remap(noMergeFileId, positions)
+}
+
+// moveMergeFiles 删除被merge过的数据文件，将merge目录下的文件移动到db.dirpath目录下
+// 移动过程中进程退出时，再次执行可以继续完成替换，merge完成标志文件最后移动
+func (db *DB) moveMergeFiles(mergePath string, noMergeFileId, mergedFileNum uint32) error {
+	//替换期间不能生成新的hint文件，之前开始生成的hint文件也不再使用
+	db.hintMu.Lock()
+	defer db.hintMu.Unlock()
+	db.mergeVersion++
+	//索引快照中的数据位置在替换后失效，需要在修改数据文件之前删除
+	if err := os.RemoveAll(filepath.Join(db.Options.DirPath, data.IndexSnapshotFileName)); err != nil {
+		return err
+	}
+	//被替换的数据文件的hint文件同样失效，merge后数据的索引保存在merge目录的hint文件中
+	for fileId := uint32(0); fileId < noMergeFileId; fileId++ {
+		if err := os.RemoveAll(data.GetDataHintFileName(db.Options.DirPath, fileId)); err != nil {
+			return err
+		}
+	}
+	//不会被merge后的数据文件覆盖的旧数据文件直接删除
+	for fileId := mergedFileNum; fileId < noMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.Options.DirPath, fileId)
 		//判断文件是否存在，若存在进行删除
 		if _, err := os.Stat(fileName); err == nil {
@@ -182,22 +421,40 @@ func (db *DB) loadMergeFiles() error {
 			}
 		}
 	}
-	//移动merge文件夹下所有文件到db.dirpath目录下
-	for _, fileName := range mergeFileNames {
+	//失效文件的删除需要先于数据文件的替换持久化
+	if err := utils.SyncDir(db.Options.DirPath); err != nil {
+		return err
+	}
+	//merge后的数据文件直接覆盖同名的旧数据文件，最后移动hint文件和merge完成标志文件
+	var fileNames []string
+	for fileId := uint32(0); fileId < mergedFileNum; fileId++ {
+		fileNames = append(fileNames, filepath.Base(data.GetDataFileName(mergePath, fileId)))
+	}
+	fileNames = append(fileNames, data.HintFileName, data.MergeFinishedFileName)
+	for _, fileName := range fileNames {
 		srcPath := filepath.Join(mergePath, fileName)
+		//上次替换时已经移动过的文件无需再次移动
+		if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+			continue
+		}
 		tarPath := filepath.Join(db.Options.DirPath, fileName)
 		if err := os.Rename(srcPath, tarPath); err != nil {
 			return err
 		}
 	}
-	return nil
+	//重命名持久化之后才能删除merge目录
+	if err := utils.SyncDir(db.Options.DirPath); err != nil {
+		return err
+	}
+	return os.RemoveAll(mergePath)
 }
 
 func (db *DB) getNoMergeFileId(dirPath string) (uint32, error) {
-	mergeFF, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFF, err := data.OpenMergeFinishedFile(dirPath, db.loadIOType(), db.ioOptions()...)
 	if err != nil {
 		return 0, err
 	}
+	defer mergeFF.Close()
 	//读取mergeFinishedFile中的数据
 	encLogRecord, _, logRecordHeader, err := mergeFF.Get(0)
 	if err != nil {
@@ -215,18 +472,63 @@ func (db *DB) getNoMergeFileId(dirPath string) (uint32, error) {
 	return uint32(noMergeFileId), nil
 }
 
+// getMergedFileNum 读取mergeFinishedFile中记录的merge后数据文件的数量
+func (db *DB) getMergedFileNum(dirPath string) (uint32, error) {
+	mergeFF, err := data.OpenMergeFinishedFile(dirPath, fio.StandardFIO, db.ioOptions()...)
+	if err != nil {
+		return 0, err
+	}
+	defer mergeFF.Close()
+	//数据文件数量记录在merge完成记录之后
+	_, size, _, err := mergeFF.Get(0)
+	if err != nil {
+		return 0, err
+	}
+	encLogRecord, _, logRecordHeader, err := mergeFF.Get(size)
+	if err == io.EOF {
+		//兼容未记录数据文件数量的merge目录，此时merge后的数据文件均未被移动
+		return countDataFiles(dirPath)
+	}
+	if err != nil {
+		return 0, err
+	}
+	mergedFileNumRecord, err := data.DecodeLogRecord(encLogRecord, logRecordHeader)
+	if err != nil {
+		return 0, err
+	}
+	mergedFileNum, err := strconv.Atoi(string(mergedFileNumRecord.Value))
+	if err != nil {
+		return 0, err
+	}
+	return uint32(mergedFileNum), nil
+}
+
 // loadIndexFromHintFile 从hint文件中加载索引
 func (db *DB) loadIndexFromHintFile() error {
-	hintFileName := filepath.Join(db.DirPath, data.HintFileName)
+	now := time.Now().UnixNano()
+	return db.foreachHintRecord(db.DirPath, db.loadIOType(), func(key []byte, pos *data.LogRecordPos) {
+		//获取到key和key对应数据的pos,将key-pos放入内存索引即可，已过期的数据无需加载
+		if isExpired(pos.ExpireAt, now) {
+			db.addReclaimSize(pos)
+		} else {
+			db.index.Put(key, pos)
+		}
+	})
+}
+
+// foreachHintRecord 依次读取dirPath下hint文件中的key及其数据位置，hint文件不存在时直接返回
+func (db *DB) foreachHintRecord(dirPath string, ioType fio.FileIOType, fn func(key []byte, pos *data.LogRecordPos)) error {
+	hintFileName := filepath.Join(dirPath, data.HintFileName)
 	//先查看当前文件夹下是否存在hintFile,若不存在直接返回即可
-	if _, err := os.Stat(hintFileName); os.IsExist(err) {
+	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
 	//若存在，则打开文件，读取索引数据
-	hinFile, err := data.OpenHintFile(db.DirPath)
+	hinFile, err := data.OpenHintFile(dirPath, ioType, db.ioOptions()...)
 	if err != nil {
 		return err
 	}
+	defer hinFile.Close()
 	var offset int64 = 0
 	for {
 		encPosLogRecord, size, posLogRecordHeader, err := hinFile.Get(offset)
@@ -241,9 +543,7 @@ func (db *DB) loadIndexFromHintFile() error {
 		if err != nil {
 			return err
 		}
-		pos := data.DecCodeLogRecordPos(posLogRecord.Value)
-		//解码完成后，获取到key和key对应数据的pos,将key-pos放入内存索引即可
-		db.index.Put(posLogRecord.Key, pos)
+		fn(posLogRecord.Key, data.DecCodeLogRecordPos(posLogRecord.Value))
 
 		//该条数据处理完成后，offset后移
 		offset += size
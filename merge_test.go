@@ -0,0 +1,256 @@
+package bitcaskkv
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/index"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeAppliesImmediately(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+
+	for i := 0; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	before, err := db.Stat()
+	require.NoError(t, err)
+
+	require.NoError(t, db.Merge())
+	// merge目录在替换完成后被删除，旧数据文件的空间立即回收
+	_, err = os.Stat(db.getMergePath())
+	require.True(t, os.IsNotExist(err))
+	after, err := db.Stat()
+	require.NoError(t, err)
+	require.Equal(t, uint(100), after.KeyNum)
+	require.Less(t, after.DiskSize, before.DiskSize)
+	require.Less(t, after.DataFileNum, before.DataFileNum)
+
+	for i := 0; i < 300; i++ {
+		value, err := db.Get(utils.GetRandomKey(i))
+		if i < 200 {
+			require.ErrorIs(t, err, ErrKeyIsNotFound)
+			continue
+		}
+		require.NoError(t, err)
+		require.Len(t, value, 64)
+	}
+	// merge后可以继续写入
+	require.NoError(t, db.Put(utils.GetRandomKey(1), []byte("jahoon")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 101, db.index.Size())
+	value, err := db.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("jahoon"), value)
+	require.NoError(t, db.Close())
+}
+
+func TestMergeWithConcurrentWrites(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 300; i++ {
+			if i%2 == 0 {
+				require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+			} else {
+				require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("jahoon")))
+			}
+		}
+	}()
+	require.NoError(t, db.Merge())
+	wg.Wait()
+
+	for i := 0; i < 300; i++ {
+		value, err := db.Get(utils.GetRandomKey(i))
+		if i%2 == 0 {
+			require.ErrorIs(t, err, ErrKeyIsNotFound)
+		} else {
+			require.NoError(t, err)
+			require.Equal(t, []byte("jahoon"), value)
+		}
+	}
+}
+
+func TestMergeWithSnapshot(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("old")))
+	}
+	snap := db.Snapshot()
+	iter := db.NewIterator()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("new")))
+	}
+	require.NoError(t, db.Merge())
+	require.NotEmpty(t, db.obsoleteFiles)
+
+	// 快照与迭代器依然读取merge前的数据文件
+	for i := 0; i < 100; i++ {
+		value, err := snap.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+		require.Equal(t, []byte("old"), value)
+	}
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		require.NoError(t, err)
+		require.Equal(t, []byte("old"), value)
+		count++
+	}
+	require.Equal(t, 100, count)
+	iter.Close()
+	snap.Release()
+	require.Empty(t, db.obsoleteFiles)
+
+	value, err := db.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), value)
+}
+
+func TestLoadMergeFilesResume(t *testing.T) {
+	for _, indexType := range []index.IndexTypes{index.Btree, index.BPtree} {
+		dirPath := t.TempDir()
+		opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024), WithDBIndexType(indexType)}
+		db, err := Open(opts...)
+		require.NoError(t, err)
+		for i := 0; i < 300; i++ {
+			require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+		}
+		for i := 0; i < 200; i++ {
+			require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+		}
+
+		// 模拟merge文件生成后、替换完成前进程退出
+		db.mu.Lock()
+		db.olderFiles[db.activeFile.FileID] = db.activeFile
+		require.NoError(t, db.setActiveFile())
+		noMergeFileID := db.activeFile.FileID
+		var mergeFiles []*data.DataFile
+		for fid := uint32(0); fid < noMergeFileID; fid++ {
+			mergeFiles = append(mergeFiles, db.olderFiles[fid])
+		}
+		db.mu.Unlock()
+		mergePath := db.getMergePath()
+		defer os.RemoveAll(mergePath)
+		mergedFileNum, _, err := db.rewriteMergeFiles(mergePath, mergeFiles, noMergeFileID)
+		require.NoError(t, err)
+		require.Greater(t, mergedFileNum, uint32(0))
+		// 仅移动了第一个数据文件
+		fileName := filepath.Base(data.GetDataFileName(mergePath, 0))
+		require.NoError(t, os.Rename(filepath.Join(mergePath, fileName), filepath.Join(dirPath, fileName)))
+		require.NoError(t, db.Close())
+
+		db, err = Open(opts...)
+		require.NoError(t, err)
+		_, err = os.Stat(mergePath)
+		require.True(t, os.IsNotExist(err))
+		require.Equal(t, 100, db.index.Size())
+		for i := 200; i < 300; i++ {
+			_, err := db.Get(utils.GetRandomKey(i))
+			require.NoError(t, err)
+		}
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestMergeBPTree(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024), WithDBIndexType(index.BPtree)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	for i := 250; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("jahoon")))
+	}
+	require.NoError(t, db.Merge())
+	check := func(db *DB) {
+		require.Equal(t, 100, db.index.Size())
+		for i := 200; i < 300; i++ {
+			value, err := db.Get(utils.GetRandomKey(i))
+			require.NoError(t, err)
+			if i >= 250 {
+				require.Equal(t, []byte("jahoon"), value)
+			}
+		}
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	// 重启后持久化的B+树索引指向merge后的数据位置
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	check(db)
+	require.NoError(t, db.Close())
+}
+
+func TestMergeBPTreeRemapAfterCrash(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024), WithDBIndexType(index.BPtree)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	for i := 250; i < 300; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("jahoon")))
+	}
+	require.NoError(t, db.Close())
+	indexFileName := filepath.Join(dirPath, "bptree-index")
+	oldIndex, err := os.ReadFile(indexFileName)
+	require.NoError(t, err)
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+	// 模拟文件移动完成后、B+树索引更新前进程退出，索引仍指向merge前的数据位置
+	require.NoError(t, os.WriteFile(indexFileName, oldIndex, fio.FilePerm))
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	require.Equal(t, 100, db.index.Size())
+	for i := 200; i < 300; i++ {
+		value, err := db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+		if i >= 250 {
+			require.Equal(t, []byte("jahoon"), value)
+		}
+	}
+	require.NoError(t, db.Close())
+}
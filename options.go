@@ -1,11 +1,19 @@
 package bitcaskkv
 
-import "github.com/GGjahon/bitcask-kv/index"
+import (
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/index"
+)
 
 const (
-	DefaultDirPath         = "bitcask-kv-data"
-	DefalutMaxDataFileSize = 128 * 1024 * 1024
-	DefaultIndexType       = index.Btree
+	DefaultDirPath               = "bitcask-kv-data"
+	DefalutMaxDataFileSize       = 128 * 1024 * 1024
+	DefaultIndexType             = index.Btree
+	DefaultMergeCheckInterval    = time.Minute
+	DefaultIndexSnapshotInterval = 10 * time.Minute
+	DefaultBlobGCInterval        = 10 * time.Minute
 )
 
 type IndexTypes = int8
@@ -28,8 +36,48 @@ type Options struct {
 	//是否每次写入均需要持久化
 	SyncWrites bool
 
+	//累计写入多少字节后进行一次持久化，为0表示不开启
+	BytesPerSync uint
+
+	//后台定期持久化活跃文件的时间间隔，为0表示不开启
+	SyncInterval time.Duration
+
 	//索引类型
 	IndexType index.IndexTypes
+
+	//启动时是否使用mmap加载数据文件，加快索引的构建
+	MMapAtStartup bool
+
+	//是否以只读模式打开，只读模式下可以有多个进程同时打开同一个db
+	ReadOnly bool
+
+	//旧数据文件中可回收数据的占比达到该阈值时，后台自动进行merge，为0表示不开启自动merge
+	MergeRatio float32
+
+	//自动merge完成后磁盘至少需要剩余的空间大小，不满足时跳过本次merge
+	MergeMinFreeDisk uint64
+
+	//后台检查是否需要自动merge的时间间隔
+	MergeCheckInterval time.Duration
+
+	//value的大小达到该阈值时存储在单独的blob文件中，merge时无需重写，为0表示不开启
+	BlobThreshold int64
+
+	//blob文件中无效数据的占比达到该阈值时，后台回收该blob文件，为0表示不开启自动回收
+	BlobGCRatio float32
+
+	//后台检查是否需要回收blob文件的时间间隔
+	BlobGCInterval time.Duration
+
+	//value的压缩方式，只影响之后写入的数据，已写入的数据依然可以正常读取
+	Compression data.CompressionType
+
+	//后台保存索引快照的时间间隔，小于0表示仅在关闭db时保存，B+树索引无需保存快照
+	IndexSnapshotInterval time.Duration
+
+	//数据文件、hint文件等的AES-GCM加密密钥，长度为16、24或32字节，为空表示不加密
+	//加密后的db每次打开都必须提供相同的密钥，更换密钥需使用RotateEncryptionKey
+	EncryptionKey []byte
 }
 
 type DBOption func(o *Options)
@@ -51,12 +99,90 @@ func WithDBSync(is bool) DBOption {
 	}
 }
 
+func WithDBBytesPerSync(bytes uint) DBOption {
+	return func(o *Options) {
+		o.BytesPerSync = bytes
+	}
+}
+
+func WithDBSyncInterval(interval time.Duration) DBOption {
+	return func(o *Options) {
+		o.SyncInterval = interval
+	}
+}
+
 func WithDBIndexType(indexType int8) DBOption {
 	return func(o *Options) {
 		o.IndexType = indexType
 	}
 }
 
+func WithDBMMapAtStartup(is bool) DBOption {
+	return func(o *Options) {
+		o.MMapAtStartup = is
+	}
+}
+
+func WithReadOnly() DBOption {
+	return func(o *Options) {
+		o.ReadOnly = true
+	}
+}
+
+func WithDBMergeRatio(ratio float32) DBOption {
+	return func(o *Options) {
+		o.MergeRatio = ratio
+	}
+}
+
+func WithDBMergeMinFreeDisk(size uint64) DBOption {
+	return func(o *Options) {
+		o.MergeMinFreeDisk = size
+	}
+}
+
+func WithDBMergeCheckInterval(interval time.Duration) DBOption {
+	return func(o *Options) {
+		o.MergeCheckInterval = interval
+	}
+}
+
+func WithDBBlobThreshold(threshold int64) DBOption {
+	return func(o *Options) {
+		o.BlobThreshold = threshold
+	}
+}
+
+func WithDBBlobGCRatio(ratio float32) DBOption {
+	return func(o *Options) {
+		o.BlobGCRatio = ratio
+	}
+}
+
+func WithDBBlobGCInterval(interval time.Duration) DBOption {
+	return func(o *Options) {
+		o.BlobGCInterval = interval
+	}
+}
+
+func WithDBCompression(compression data.CompressionType) DBOption {
+	return func(o *Options) {
+		o.Compression = compression
+	}
+}
+
+func WithDBIndexSnapshotInterval(interval time.Duration) DBOption {
+	return func(o *Options) {
+		o.IndexSnapshotInterval = interval
+	}
+}
+
+func WithDBEncryptionKey(key []byte) DBOption {
+	return func(o *Options) {
+		o.EncryptionKey = key
+	}
+}
+
 func repaireDB(o *Options) {
 	if len(o.DirPath) == 0 {
 		o.DirPath = DefaultDirPath
@@ -67,6 +193,15 @@ func repaireDB(o *Options) {
 	if o.IndexType == 0 {
 		o.IndexType = DefaultIndexType
 	}
+	if o.MergeCheckInterval <= 0 {
+		o.MergeCheckInterval = DefaultMergeCheckInterval
+	}
+	if o.BlobGCInterval <= 0 {
+		o.BlobGCInterval = DefaultBlobGCInterval
+	}
+	if o.IndexSnapshotInterval == 0 {
+		o.IndexSnapshotInterval = DefaultIndexSnapshotInterval
+	}
 }
 
 type IterOptions struct {
@@ -101,3 +236,10 @@ func WithMaxBatchNum(num uint) WriteBatchOption {
 		o.MaxBatchNum = num
 	}
 }
+
+// WithBatchSync 提交时是否持久化，为false时按db的SyncWrites配置决定
+func WithBatchSync(is bool) WriteBatchOption {
+	return func(o *WriteBatchOptions) {
+		o.SyncWrites = is
+	}
+}
@@ -0,0 +1,424 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+)
+
+// command 一条redis命令的定义
+type command struct {
+	name string
+	// 参数个数（包含命令名），为负数时表示最少的参数个数
+	arity   int
+	handler func(ctx *context, args [][]byte) reply
+	// 写命令之间可以并发执行
+	write bool
+	// 需要先读取再写入的命令及读取多个子key的命令执行期间独占，避免与其他写命令交错
+	exclusive func(args [][]byte) bool
+	// 不能在MULTI中排队的命令
+	noMulti bool
+}
+
+var commands = make(map[string]*command)
+
+func init() {
+	for _, cmd := range []*command{
+		{name: "ping", arity: -1, handler: pingCommand},
+		{name: "echo", arity: 2, handler: echoCommand},
+		{name: "select", arity: 2, handler: selectCommand},
+		{name: "client", arity: -2, handler: clientCommand, noMulti: true},
+		{name: "command", arity: -1, handler: commandCommand, noMulti: true},
+		{name: "get", arity: 2, handler: getCommand},
+		{name: "set", arity: -3, handler: setCommand, write: true, exclusive: setIsConditional},
+		{name: "del", arity: -2, handler: delCommand, write: true, exclusive: always},
+		{name: "exists", arity: -2, handler: existsCommand},
+		{name: "type", arity: 2, handler: typeCommand},
+		{name: "keys", arity: 2, handler: keysCommand},
+		{name: "scan", arity: -2, handler: scanCommand},
+		{name: "hset", arity: -4, handler: hsetCommand, write: true, exclusive: always},
+		{name: "hget", arity: 3, handler: hgetCommand},
+		{name: "hdel", arity: -3, handler: hdelCommand, write: true, exclusive: always},
+		{name: "sadd", arity: -3, handler: saddCommand, write: true, exclusive: always},
+		{name: "sismember", arity: 3, handler: sismemberCommand},
+		{name: "srem", arity: -3, handler: sremCommand, write: true, exclusive: always},
+		{name: "lpush", arity: -3, handler: lpushCommand, write: true, exclusive: always},
+		{name: "rpop", arity: 2, handler: rpopCommand, write: true, exclusive: always},
+		{name: "lrange", arity: 4, handler: lrangeCommand, exclusive: always},
+		{name: "zadd", arity: -4, handler: zaddCommand, write: true, exclusive: always},
+		{name: "zscore", arity: 3, handler: zscoreCommand},
+		{name: "zrangebyscore", arity: -4, handler: zrangeByScoreCommand, exclusive: always},
+	} {
+		commands[cmd.name] = cmd
+	}
+}
+
+// lookupCommand 查找命令并校验参数个数
+func lookupCommand(name string, args [][]byte) (*command, reply) {
+	cmd := commands[name]
+	if cmd == nil {
+		return nil, errorReply(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		return nil, wrongArgsReply(cmd.name)
+	}
+	return cmd, nil
+}
+
+func always([][]byte) bool { return true }
+
+// errorFromDB 将DB返回的错误转换为回复给客户端的错误
+func errorFromDB(err error) reply {
+	switch {
+	case errors.Is(err, bitcaskkv.ErrKeyIsEmpty):
+		return errorReply("ERR empty keys are not supported")
+	case errors.Is(err, bitcaskkv.ErrReadOnly):
+		return errorReply("READONLY You can't write against a read only database.")
+	case errors.Is(err, ErrWrongType):
+		return errorReply(err.Error())
+	default:
+		return errorReply("ERR " + err.Error())
+	}
+}
+
+func wrongArgsReply(name string) reply {
+	return errorReply(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
+
+var (
+	syntaxErrReply  = errorReply("ERR syntax error")
+	notIntegerReply = errorReply("ERR value is not an integer or out of range")
+)
+
+func parseInt(arg []byte) (int64, bool) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	return n, err == nil
+}
+
+func pingCommand(ctx *context, args [][]byte) reply {
+	switch len(args) {
+	case 1:
+		return simpleString("PONG")
+	case 2:
+		return bulkString(args[1])
+	default:
+		return wrongArgsReply("ping")
+	}
+}
+
+func echoCommand(ctx *context, args [][]byte) reply {
+	return bulkString(args[1])
+}
+
+// selectCommand 只有一个数据库，仅支持SELECT 0
+func selectCommand(ctx *context, args [][]byte) reply {
+	index, ok := parseInt(args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	if index != 0 {
+		return errorReply("ERR DB index is out of range")
+	}
+	return okReply
+}
+
+// clientCommand 客户端连接时会发送CLIENT SETNAME等命令，直接忽略
+func clientCommand(ctx *context, args [][]byte) reply {
+	return okReply
+}
+
+// commandCommand 部分客户端连接时会通过COMMAND获取命令列表，返回空列表即可
+func commandCommand(ctx *context, args [][]byte) reply {
+	return array{}
+}
+
+func getCommand(ctx *context, args [][]byte) reply {
+	value, err := ctx.get(stringKey(args[1]))
+	if err == bitcaskkv.ErrKeyIsNotFound {
+		// key可能存储的是其他类型的数据
+		exists, err := ctx.exists(metaKey(args[1]))
+		if err != nil {
+			return errorFromDB(err)
+		}
+		if exists {
+			return errorFromDB(ErrWrongType)
+		}
+		return nilReply
+	}
+	if err != nil {
+		return errorFromDB(err)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return bulkString(value)
+}
+
+// setCommand SET key value [EX seconds|PX milliseconds] [NX|XX]
+func setCommand(ctx *context, args [][]byte) reply {
+	var (
+		ttl    time.Duration
+		hasTTL bool
+		nx, xx bool
+	)
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if hasTTL || i+1 >= len(args) {
+				return syntaxErrReply
+			}
+			i++
+			n, ok := parseInt(args[i])
+			if !ok {
+				return notIntegerReply
+			}
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > int64(time.Duration(1<<63-1)/unit) {
+				return errorReply("ERR invalid expire time in 'set' command")
+			}
+			ttl, hasTTL = time.Duration(n)*unit, true
+		default:
+			return syntaxErrReply
+		}
+	}
+	if nx && xx {
+		return syntaxErrReply
+	}
+
+	dataType, err := ctx.keyType(args[1])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	if (nx && dataType != None) || (xx && dataType == None) {
+		return nilReply
+	}
+	// 覆盖其他类型的数据时先删除其所有子key
+	if dataType != None && dataType != String {
+		if _, err := ctx.deleteKey(args[1]); err != nil {
+			return errorFromDB(err)
+		}
+	}
+	if err := ctx.put(stringKey(args[1]), args[2], ttl); err != nil {
+		return errorFromDB(err)
+	}
+	return okReply
+}
+
+// setIsConditional 带NX/XX的SET需要先判断key是否存在，执行期间独占
+func setIsConditional(args [][]byte) bool {
+	for _, arg := range args[3:] {
+		if opt := strings.ToLower(string(arg)); opt == "nx" || opt == "xx" {
+			return true
+		}
+	}
+	return false
+}
+
+// delCommand 删除任意类型的key，返回实际删除的key个数
+func delCommand(ctx *context, args [][]byte) reply {
+	var deleted int64
+	for _, key := range args[1:] {
+		exists, err := ctx.deleteKey(key)
+		if err != nil {
+			return errorFromDB(err)
+		}
+		if exists {
+			deleted++
+		}
+	}
+	return integer(deleted)
+}
+
+// existsCommand 返回存在的key个数，重复的key重复计数
+func existsCommand(ctx *context, args [][]byte) reply {
+	var count int64
+	for _, key := range args[1:] {
+		dataType, err := ctx.keyType(key)
+		if err != nil {
+			return errorFromDB(err)
+		}
+		if dataType != None {
+			count++
+		}
+	}
+	return integer(count)
+}
+
+func typeCommand(ctx *context, args [][]byte) reply {
+	dataType, err := ctx.keyType(args[1])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return simpleString(dataType.String())
+}
+
+// scanKeys 依次遍历以prefix开头的字符串key及其他类型的key，start为上一次遍历到的底层key，fn返回false时停止遍历
+// 底层key依次为：不以0xff开头的字符串key、元数据key、以0xff开头的字符串key
+func (ctx *context) scanKeys(prefix, start []byte, fn func(rawKey, key []byte, dataType DataType) bool) {
+	stopped := false
+	if !isReservedKey(start) {
+		if !isReservedKey(prefix) {
+			ctx.scan(prefix, start, func(rawKey []byte) bool {
+				// 字符串key之后是以0xff开头的元数据及子key
+				if isReservedKey(rawKey) {
+					return false
+				}
+				stopped = !fn(rawKey, rawKey, String)
+				return !stopped
+			})
+		}
+		start = nil
+	}
+	if stopped {
+		return
+	}
+	if start == nil || bytes.HasPrefix(start, metaKeyPrefix) {
+		ctx.scan(metaKey(prefix), start, func(rawKey []byte) bool {
+			buf, err := ctx.get(rawKey)
+			if err != nil {
+				return true
+			}
+			stopped = !fn(rawKey, rawKey[len(metaKeyPrefix):], DataType(buf[0]))
+			return !stopped
+		})
+		start = nil
+	}
+	if stopped || len(prefix) > 0 && !isReservedKey(prefix) {
+		return
+	}
+	ctx.scan(append(append([]byte{}, stringKeyPrefix...), prefix...), start, func(rawKey []byte) bool {
+		return fn(rawKey, rawKey[len(stringKeyPrefix):], String)
+	})
+}
+
+// keysCommand 返回所有匹配模式的key
+func keysCommand(ctx *context, args [][]byte) reply {
+	pattern := args[1]
+	keys := make([][]byte, 0)
+	ctx.scanKeys(patternPrefix(pattern), nil, func(_, key []byte, _ DataType) bool {
+		if matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return bulkStrings(keys)
+}
+
+// 默认每次SCAN遍历的key个数
+const defaultScanCount = 10
+
+// scanCommand SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// 游标对应上一次遍历到的key，下一次从该key之后继续遍历，遍历期间写入的key可能被返回也可能不被返回
+func scanCommand(ctx *context, args [][]byte) reply {
+	var start []byte
+	if string(args[1]) != "0" {
+		cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+		if err != nil {
+			return errorReply("ERR invalid cursor")
+		}
+		var ok bool
+		if start, ok = ctx.server.cursors.get(cursor); !ok {
+			return errorReply("ERR invalid cursor")
+		}
+	}
+	pattern := []byte("*")
+	count := defaultScanCount
+	var typ string
+	for i := 2; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return syntaxErrReply
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return notIntegerReply
+			}
+			if n < 1 {
+				return syntaxErrReply
+			}
+			count = int(n)
+		case "type":
+			typ = strings.ToLower(string(args[i+1]))
+		default:
+			return syntaxErrReply
+		}
+		i++
+	}
+
+	keys := make([][]byte, 0)
+	var last []byte
+	scanned, hasMore := 0, false
+	ctx.scanKeys(patternPrefix(pattern), start, func(rawKey, key []byte, dataType DataType) bool {
+		if bytes.Equal(rawKey, start) {
+			return true
+		}
+		// 多遍历一个key用于判断是否已经遍历完成
+		if scanned == count {
+			hasMore = true
+			return false
+		}
+		scanned++
+		last = rawKey
+		if (typ == "" || typ == dataType.String()) && matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	next := "0"
+	if hasMore {
+		next = strconv.FormatUint(ctx.server.cursors.put(last), 10)
+	}
+	return array{bulkString(next), bulkStrings(keys)}
+}
+
+// 最多保存的游标个数，超出后淘汰最早创建的游标
+const maxCursors = 1024
+
+// cursorTable 保存SCAN游标对应的key，客户端要求游标为十进制无符号整数，无法直接在游标中保存key
+// 游标被淘汰或服务端重启后，使用该游标的SCAN返回错误，需要从0重新遍历
+type cursorTable struct {
+	mu      sync.Mutex
+	next    uint64
+	cursors map[uint64][]byte
+	order   []uint64
+}
+
+func newCursorTable() *cursorTable {
+	return &cursorTable{cursors: make(map[uint64][]byte)}
+}
+
+func (t *cursorTable) put(key []byte) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.cursors[t.next] = key
+	t.order = append(t.order, t.next)
+	if len(t.order) > maxCursors {
+		delete(t.cursors, t.order[0])
+		t.order = t.order[1:]
+	}
+	return t.next
+}
+
+func (t *cursorTable) get(cursor uint64) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, ok := t.cursors[cursor]
+	return key, ok
+}
@@ -0,0 +1,155 @@
+package redis
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+)
+
+// context 命令的执行环境，命令中的写入先暂存在context中，读取时优先读取暂存的数据
+// 命令执行完成后通过commit一次性提交，保证一条命令（或MULTI/EXEC中的所有命令）写入的数据要么全部生效，要么全部不生效
+type context struct {
+	server *Server
+	db     *bitcaskkv.DB
+	writes map[string]*pendingWrite
+}
+
+// pendingWrite 暂存的一次写入
+type pendingWrite struct {
+	value   []byte
+	ttl     time.Duration
+	deleted bool
+}
+
+func newContext(server *Server, db *bitcaskkv.DB) *context {
+	return &context{
+		server: server,
+		db:     db,
+		writes: make(map[string]*pendingWrite),
+	}
+}
+
+func (ctx *context) get(key []byte) ([]byte, error) {
+	if w := ctx.writes[string(key)]; w != nil {
+		if w.deleted {
+			return nil, bitcaskkv.ErrKeyIsNotFound
+		}
+		return w.value, nil
+	}
+	return ctx.db.Get(key)
+}
+
+// exists 判断key是否存在，只需查询索引，无需读取value
+func (ctx *context) exists(key []byte) (bool, error) {
+	if w := ctx.writes[string(key)]; w != nil {
+		return !w.deleted, nil
+	}
+	_, err := ctx.db.TTL(key)
+	if err == bitcaskkv.ErrKeyIsNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// put 写入key - value，ttl为0时清除key原有的过期时间
+func (ctx *context) put(key, value []byte, ttl time.Duration) error {
+	if err := ctx.checkWrite(key); err != nil {
+		return err
+	}
+	ctx.writes[string(key)] = &pendingWrite{value: value, ttl: ttl}
+	return nil
+}
+
+func (ctx *context) delete(key []byte) error {
+	if err := ctx.checkWrite(key); err != nil {
+		return err
+	}
+	ctx.writes[string(key)] = &pendingWrite{deleted: true}
+	return nil
+}
+
+// checkWrite 在暂存时检查写入是否合法，避免提交时才失败
+func (ctx *context) checkWrite(key []byte) error {
+	if len(key) == 0 {
+		return bitcaskkv.ErrKeyIsEmpty
+	}
+	if ctx.db.ReadOnly {
+		return bitcaskkv.ErrReadOnly
+	}
+	return nil
+}
+
+// scan 按顺序遍历以prefix开头且不小于start的key，包含暂存的写入，fn返回false时停止遍历
+func (ctx *context) scan(prefix, start []byte, fn func(key []byte) bool) {
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	var pending []string
+	for key := range ctx.writes {
+		if bytes.HasPrefix([]byte(key), prefix) && key >= string(start) {
+			pending = append(pending, key)
+		}
+	}
+	sort.Strings(pending)
+
+	iter := ctx.db.NewIterator(bitcaskkv.WithIterPrefix(prefix))
+	defer iter.Close()
+	iter.Seek(start)
+	for iter.Valid() || len(pending) > 0 {
+		var key []byte
+		switch {
+		case len(pending) > 0 && (!iter.Valid() || pending[0] <= string(iter.Key())):
+			if iter.Valid() && pending[0] == string(iter.Key()) {
+				iter.Next()
+			}
+			key, pending = []byte(pending[0]), pending[1:]
+			if ctx.writes[string(key)].deleted {
+				continue
+			}
+		default:
+			key = iter.Key()
+			iter.Next()
+		}
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// commit 提交暂存的所有写入，多条写入通过WriteBatch原子地提交，是否持久化由db的SyncWrites配置决定
+func (ctx *context) commit() error {
+	defer func() { ctx.writes = make(map[string]*pendingWrite) }()
+	if len(ctx.writes) == 1 {
+		for key, w := range ctx.writes {
+			switch {
+			case w.deleted:
+				return ctx.db.Delete([]byte(key))
+			case w.ttl > 0:
+				return ctx.db.PutWithTTL([]byte(key), w.value, w.ttl)
+			default:
+				return ctx.db.Put([]byte(key), w.value)
+			}
+		}
+	}
+	if len(ctx.writes) == 0 {
+		return nil
+	}
+	wb := ctx.db.NewWriteBatch(bitcaskkv.WithMaxBatchNum(uint(len(ctx.writes))), bitcaskkv.WithBatchSync(false))
+	for key, w := range ctx.writes {
+		var err error
+		switch {
+		case w.deleted:
+			err = wb.Delete([]byte(key))
+		case w.ttl > 0:
+			err = wb.PutWithTTL([]byte(key), w.value, w.ttl)
+		default:
+			err = wb.Put([]byte(key), w.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
@@ -0,0 +1,135 @@
+package redis
+
+import bitcaskkv "github.com/GGjahon/bitcask-kv"
+
+// hset 写入field - value，返回新增的field个数
+func (ctx *context) hset(key []byte, pairs [][]byte) (int64, error) {
+	meta, err := ctx.getMeta(key, Hash)
+	if err != nil {
+		return 0, err
+	}
+	var added int64
+	for i := 0; i < len(pairs); i += 2 {
+		fieldKey := dataKey(key, pairs[i])
+		exists, err := ctx.exists(fieldKey)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			meta.size++
+			added++
+		}
+		if err := ctx.put(fieldKey, pairs[i+1], 0); err != nil {
+			return 0, err
+		}
+	}
+	return added, ctx.putMeta(key, meta)
+}
+
+func (ctx *context) hget(key, field []byte) ([]byte, error) {
+	meta, err := ctx.getMeta(key, Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 {
+		return nil, bitcaskkv.ErrKeyIsNotFound
+	}
+	value, err := ctx.get(dataKey(key, field))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// hdel 删除field，返回实际删除的field个数
+func (ctx *context) hdel(key []byte, fields [][]byte) (int64, error) {
+	meta, err := ctx.getMeta(key, Hash)
+	if err != nil || meta.size == 0 {
+		return 0, err
+	}
+	var deleted int64
+	for _, field := range fields {
+		fieldKey := dataKey(key, field)
+		exists, err := ctx.exists(fieldKey)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			continue
+		}
+		if err := ctx.delete(fieldKey); err != nil {
+			return 0, err
+		}
+		meta.size--
+		deleted++
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, ctx.putMeta(key, meta)
+}
+
+// HSet 写入hash中的field - value，返回field是否为新增的
+func (ds *DataStructure) HSet(key, field, value []byte) (bool, error) {
+	var added int64
+	err := ds.update(func(ctx *context) (err error) {
+		added, err = ctx.hset(key, [][]byte{field, value})
+		return err
+	})
+	return added > 0, err
+}
+
+// HGet 读取hash中field对应的value，不存在时返回ErrKeyIsNotFound
+func (ds *DataStructure) HGet(key, field []byte) ([]byte, error) {
+	var value []byte
+	err := ds.view(func(ctx *context) (err error) {
+		value, err = ctx.hget(key, field)
+		return err
+	})
+	return value, err
+}
+
+// HDel 删除hash中的field，返回field是否存在
+func (ds *DataStructure) HDel(key, field []byte) (bool, error) {
+	var deleted int64
+	err := ds.update(func(ctx *context) (err error) {
+		deleted, err = ctx.hdel(key, [][]byte{field})
+		return err
+	})
+	return deleted > 0, err
+}
+
+// hsetCommand HSET key field value [field value ...]
+func hsetCommand(ctx *context, args [][]byte) reply {
+	if len(args)%2 != 0 {
+		return wrongArgsReply("hset")
+	}
+	added, err := ctx.hset(args[1], args[2:])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return integer(added)
+}
+
+func hgetCommand(ctx *context, args [][]byte) reply {
+	value, err := ctx.hget(args[1], args[2])
+	if err == bitcaskkv.ErrKeyIsNotFound {
+		return nilReply
+	}
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return bulkString(value)
+}
+
+// hdelCommand HDEL key field [field ...]
+func hdelCommand(ctx *context, args [][]byte) reply {
+	deleted, err := ctx.hdel(args[1], args[2:])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return integer(deleted)
+}
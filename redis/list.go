@@ -0,0 +1,145 @@
+package redis
+
+import "encoding/binary"
+
+// listElementKey list中index位置的元素对应的子key
+func listElementKey(key []byte, index uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, index)
+	return dataKey(key, buf)
+}
+
+// lpush 依次将元素插入list头部，返回插入后list的长度
+func (ctx *context) lpush(key []byte, elements [][]byte) (int64, error) {
+	meta, err := ctx.getMeta(key, List)
+	if err != nil {
+		return 0, err
+	}
+	for _, element := range elements {
+		meta.head--
+		if err := ctx.put(listElementKey(key, meta.head), element, 0); err != nil {
+			return 0, err
+		}
+		meta.size++
+	}
+	return int64(meta.size), ctx.putMeta(key, meta)
+}
+
+// rpop 移除并返回list尾部的元素，list为空时返回nil
+func (ctx *context) rpop(key []byte) ([]byte, error) {
+	meta, err := ctx.getMeta(key, List)
+	if err != nil || meta.size == 0 {
+		return nil, err
+	}
+	elementKey := listElementKey(key, meta.tail-1)
+	element, err := ctx.get(elementKey)
+	if err != nil {
+		return nil, err
+	}
+	if element == nil {
+		element = []byte{}
+	}
+	if err := ctx.delete(elementKey); err != nil {
+		return nil, err
+	}
+	meta.tail--
+	meta.size--
+	return element, ctx.putMeta(key, meta)
+}
+
+// lrange 返回list中[start, stop]范围内的元素，负数表示从尾部开始计数
+func (ctx *context) lrange(key []byte, start, stop int64) ([][]byte, error) {
+	meta, err := ctx.getMeta(key, List)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(meta.size)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	elements := make([][]byte, 0)
+	for i := start; i <= stop; i++ {
+		element, err := ctx.get(listElementKey(key, meta.head+uint64(i)))
+		if err != nil {
+			return nil, err
+		}
+		if element == nil {
+			element = []byte{}
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+// LPush 将元素插入list头部，返回插入后list的长度
+func (ds *DataStructure) LPush(key, element []byte) (int64, error) {
+	var size int64
+	err := ds.update(func(ctx *context) (err error) {
+		size, err = ctx.lpush(key, [][]byte{element})
+		return err
+	})
+	return size, err
+}
+
+// RPop 移除并返回list尾部的元素，list为空时返回nil
+func (ds *DataStructure) RPop(key []byte) ([]byte, error) {
+	var element []byte
+	err := ds.update(func(ctx *context) (err error) {
+		element, err = ctx.rpop(key)
+		return err
+	})
+	return element, err
+}
+
+// LRange 返回list中[start, stop]范围内的元素，负数表示从尾部开始计数，-1为最后一个元素
+func (ds *DataStructure) LRange(key []byte, start, stop int64) ([][]byte, error) {
+	var elements [][]byte
+	err := ds.view(func(ctx *context) (err error) {
+		elements, err = ctx.lrange(key, start, stop)
+		return err
+	})
+	return elements, err
+}
+
+// lpushCommand LPUSH key element [element ...]
+func lpushCommand(ctx *context, args [][]byte) reply {
+	size, err := ctx.lpush(args[1], args[2:])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return integer(size)
+}
+
+func rpopCommand(ctx *context, args [][]byte) reply {
+	element, err := ctx.rpop(args[1])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return bulkString(element)
+}
+
+// lrangeCommand LRANGE key start stop
+func lrangeCommand(ctx *context, args [][]byte) reply {
+	start, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	stop, ok := parseInt(args[3])
+	if !ok {
+		return notIntegerReply
+	}
+	elements, err := ctx.lrange(args[1], start, stop)
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return bulkStrings(elements)
+}
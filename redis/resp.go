@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// RESP2协议：客户端以bulk string数组发送命令，也兼容telnet等工具发送的以空格分隔的inline命令
+// 服务端的回复为simple string、error、integer、bulk string或array之一
+
+const (
+	// 单个bulk string的最大长度，与redis一致
+	maxBulkLen = 512 * 1024 * 1024
+	// 单条命令的最大参数个数
+	maxArrayLen = 1024 * 1024
+	// inline命令的最大长度
+	maxInlineLen = 64 * 1024
+)
+
+var (
+	ErrProtocol = errors.New("Protocol error")
+)
+
+// reader 从连接中读取客户端发送的命令
+type reader struct {
+	br *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{br: bufio.NewReader(r)}
+}
+
+// readCommand 读取一条命令，返回命令名及参数，空行返回长度为0的命令
+func (r *reader) readCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return r.parseInline(line)
+	}
+	n, err := parseLen(line[1:], maxArrayLen)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, ErrProtocol
+		}
+		size, err := parseLen(line[1:], maxBulkLen)
+		if err != nil {
+			return nil, err
+		}
+		// bulk string之后是\r\n
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r.br, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, ErrProtocol
+		}
+		args = append(args, buf[:size])
+	}
+	return args, nil
+}
+
+// readLine 读取以\r\n结尾的一行，返回的数据不包含结尾的\r\n
+func (r *reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		buf, err := r.br.ReadSlice('\n')
+		line = append(line, buf...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if len(line) > maxInlineLen {
+			return nil, ErrProtocol
+		}
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// parseInline 解析以空格分隔的inline命令
+func (r *reader) parseInline(line []byte) ([][]byte, error) {
+	if len(line) > maxInlineLen {
+		return nil, ErrProtocol
+	}
+	fields := bytes.Fields(line)
+	args := make([][]byte, len(fields))
+	for i, field := range fields {
+		args[i] = append([]byte(nil), field...)
+	}
+	return args, nil
+}
+
+func parseLen(buf []byte, max int) (int, error) {
+	n, err := strconv.Atoi(string(buf))
+	if err != nil || n < 0 || n > max {
+		return 0, ErrProtocol
+	}
+	return n, nil
+}
+
+// reply 回复给客户端的数据
+type reply interface {
+	writeTo(w *bufio.Writer)
+}
+
+type (
+	simpleString string
+	errorReply   string
+	integer      int64
+	// bulkString 为nil时回复null bulk string
+	bulkString []byte
+	// array 为nil时回复null array
+	array []reply
+)
+
+var (
+	okReply     = simpleString("OK")
+	queuedReply = simpleString("QUEUED")
+	nilReply    = bulkString(nil)
+)
+
+func (s simpleString) writeTo(w *bufio.Writer) {
+	w.WriteByte('+')
+	w.WriteString(string(s))
+	w.WriteString("\r\n")
+}
+
+func (e errorReply) writeTo(w *bufio.Writer) {
+	w.WriteByte('-')
+	w.WriteString(string(e))
+	w.WriteString("\r\n")
+}
+
+func (i integer) writeTo(w *bufio.Writer) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(int64(i), 10))
+	w.WriteString("\r\n")
+}
+
+func (b bulkString) writeTo(w *bufio.Writer) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(b)))
+	w.WriteString("\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (a array) writeTo(w *bufio.Writer) {
+	if a == nil {
+		w.WriteString("*-1\r\n")
+		return
+	}
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(len(a)))
+	w.WriteString("\r\n")
+	for _, r := range a {
+		r.writeTo(w)
+	}
+}
+
+// bulkStrings 将一组数据作为bulk string数组回复
+func bulkStrings(items [][]byte) array {
+	a := make(array, len(items))
+	for i, item := range items {
+		a[i] = bulkString(item)
+	}
+	return a
+}
@@ -0,0 +1,257 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+)
+
+var (
+	ErrServerClosed = errors.New("redis: server closed")
+)
+
+// Server 兼容redis RESP2协议的服务端，将redis命令映射为对DB的操作
+type Server struct {
+	db      *bitcaskkv.DB
+	ds      *DataStructure
+	cursors *cursorTable
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func NewServer(db *bitcaskkv.DB) *Server {
+	return &Server{
+		db:      db,
+		ds:      NewDataStructure(db),
+		cursors: newCursorTable(),
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// DataStructure 返回与Server共用锁的DataStructure，在服务运行期间直接操作Hash、Set、List、ZSet时应使用该实例
+func (s *Server) DataStructure() *DataStructure {
+	return s.ds
+}
+
+// ListenAndServe 监听addr并处理客户端连接，直到Close被调用
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve 处理listener接收的客户端连接，直到Close被调用，返回ErrServerClosed
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close 停止接收新连接，关闭所有客户端连接并等待正在执行的命令完成，不会关闭DB
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Addr 返回监听的地址，尚未开始监听时返回nil
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// client 一个客户端连接的状态
+type client struct {
+	server *Server
+	conn   net.Conn
+	reader *reader
+	writer *bufio.Writer
+	// MULTI之后排队等待EXEC执行的命令
+	inMulti bool
+	queued  [][][]byte
+	// 排队时出现错误的事务在EXEC时直接丢弃
+	multiErr bool
+	quit     bool
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+	c := &client{
+		server: s,
+		conn:   conn,
+		reader: newReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+	for !c.quit {
+		args, err := c.reader.readCommand()
+		if err != nil {
+			if err == ErrProtocol {
+				errorReply("ERR Protocol error").writeTo(c.writer)
+				c.writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		c.handle(args).writeTo(c.writer)
+		// 客户端一次发送了多条命令时，全部处理完成后再统一发送回复
+		if c.reader.br.Buffered() == 0 {
+			if err := c.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+	c.writer.Flush()
+}
+
+// handle 执行一条命令，处理事务相关的命令，MULTI之后的其他命令进入队列
+func (c *client) handle(args [][]byte) reply {
+	name := strings.ToLower(string(args[0]))
+	switch name {
+	case "multi":
+		if c.inMulti {
+			return errorReply("ERR MULTI calls can not be nested")
+		}
+		c.inMulti, c.queued, c.multiErr = true, nil, false
+		return okReply
+	case "exec":
+		if !c.inMulti {
+			return errorReply("ERR EXEC without MULTI")
+		}
+		queued, multiErr := c.queued, c.multiErr
+		c.inMulti, c.queued, c.multiErr = false, nil, false
+		if multiErr {
+			return errorReply("EXECABORT Transaction discarded because of previous errors.")
+		}
+		return c.server.exec(queued)
+	case "discard":
+		if !c.inMulti {
+			return errorReply("ERR DISCARD without MULTI")
+		}
+		c.inMulti, c.queued, c.multiErr = false, nil, false
+		return okReply
+	case "quit":
+		c.quit = true
+		return okReply
+	}
+
+	cmd, errReply := lookupCommand(name, args)
+	if errReply != nil {
+		if c.inMulti {
+			c.multiErr = true
+		}
+		return errReply
+	}
+	if c.inMulti {
+		if cmd.noMulti {
+			c.multiErr = true
+			return errorReply("ERR Command not allowed inside a transaction")
+		}
+		c.queued = append(c.queued, args)
+		return queuedReply
+	}
+	return c.server.call(cmd, args)
+}
+
+// call 在事务之外执行一条命令，命令中的写入在执行完成后一起提交
+func (s *Server) call(cmd *command, args [][]byte) reply {
+	switch {
+	case cmd.exclusive != nil && cmd.exclusive(args):
+		s.ds.mu.Lock()
+		defer s.ds.mu.Unlock()
+	case cmd.write:
+		s.ds.mu.RLock()
+		defer s.ds.mu.RUnlock()
+	}
+	ctx := newContext(s, s.db)
+	r := cmd.handler(ctx, args)
+	if _, ok := r.(errorReply); ok {
+		return r
+	}
+	if err := ctx.commit(); err != nil {
+		return errorFromDB(err)
+	}
+	return r
+}
+
+// exec 依次执行排队的命令，执行期间独占，后面的命令可以读取到前面命令的写入，所有写入一起提交
+func (s *Server) exec(queued [][][]byte) reply {
+	s.ds.mu.Lock()
+	defer s.ds.mu.Unlock()
+	ctx := newContext(s, s.db)
+	replies := make(array, 0, len(queued))
+	for _, args := range queued {
+		cmd, _ := lookupCommand(strings.ToLower(string(args[0])), args)
+		replies = append(replies, cmd.handler(ctx, args))
+	}
+	if err := ctx.commit(); err != nil {
+		return errorFromDB(err)
+	}
+	return replies
+}
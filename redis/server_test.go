@@ -0,0 +1,452 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient 以RESP协议发送命令并解析回复的简单客户端
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func newTestServer(t *testing.T, opts ...bitcaskkv.DBOption) (*Server, *bitcaskkv.DB) {
+	db, err := bitcaskkv.Open(append([]bitcaskkv.DBOption{bitcaskkv.WithDBDirPath(t.TempDir())}, opts...)...)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewServer(db)
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(listener) }()
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+		require.ErrorIs(t, <-done, ErrServerClosed)
+		require.NoError(t, db.Close())
+	})
+	return server, db
+}
+
+func newTestClient(t *testing.T, server *Server) *testClient {
+	var addr net.Addr
+	for addr == nil {
+		addr = server.Addr()
+		time.Sleep(time.Millisecond)
+	}
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+}
+
+func (c *testClient) send(args ...string) {
+	buf := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		buf += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.conn.Write([]byte(buf))
+	require.NoError(c.t, err)
+}
+
+// do 发送命令并返回回复：simple string为string，error为error，integer为int64，bulk string为[]byte，array为[]interface{}
+func (c *testClient) do(args ...string) interface{} {
+	c.send(args...)
+	return c.read()
+}
+
+func (c *testClient) read() interface{} {
+	line, err := c.br.ReadString('\n')
+	require.NoError(c.t, err)
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		require.NoError(c.t, err)
+		return n
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		require.NoError(c.t, err)
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.br, buf)
+		require.NoError(c.t, err)
+		return buf[:n]
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		require.NoError(c.t, err)
+		if n < 0 {
+			return nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return items
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func requireError(t *testing.T, reply interface{}, msg string) {
+	err, ok := reply.(error)
+	require.True(t, ok, "reply %v is not an error", reply)
+	require.Equal(t, msg, err.Error())
+}
+
+func TestServerStringCommands(t *testing.T) {
+	server, db := newTestServer(t)
+	c := newTestClient(t, server)
+
+	require.Equal(t, "PONG", c.do("PING"))
+	require.Equal(t, []byte("hello"), c.do("ping", "hello"))
+	require.Nil(t, c.do("GET", "name"))
+	require.Equal(t, "OK", c.do("SET", "name", "jahoon"))
+	require.Equal(t, []byte("jahoon"), c.do("GET", "name"))
+	value, err := db.Get([]byte("name"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("jahoon"), value)
+	require.Equal(t, "OK", c.do("SET", "empty", ""))
+	require.Equal(t, []byte{}, c.do("GET", "empty"))
+
+	// NX/XX
+	require.Nil(t, c.do("SET", "name", "other", "NX"))
+	require.Equal(t, "OK", c.do("SET", "name", "other", "XX"))
+	require.Nil(t, c.do("SET", "missing", "other", "XX"))
+	require.Equal(t, "OK", c.do("SET", "missing", "other", "nx"))
+	requireError(t, c.do("SET", "name", "other", "NX", "XX"), "ERR syntax error")
+
+	// EX/PX
+	require.Equal(t, "OK", c.do("SET", "temp", "value", "PX", "50"))
+	ttl, err := db.TTL([]byte("temp"))
+	require.NoError(t, err)
+	require.True(t, ttl > 0 && ttl <= 50*time.Millisecond)
+	require.Equal(t, "OK", c.do("SET", "long", "value", "EX", "100"))
+	ttl, err = db.TTL([]byte("long"))
+	require.NoError(t, err)
+	require.True(t, ttl > 99*time.Second)
+	requireError(t, c.do("SET", "temp", "value", "EX", "0"), "ERR invalid expire time in 'set' command")
+	requireError(t, c.do("SET", "temp", "value", "EX", "abc"), "ERR value is not an integer or out of range")
+	requireError(t, c.do("SET", "temp", "value", "EX"), "ERR syntax error")
+	time.Sleep(100 * time.Millisecond)
+	require.Nil(t, c.do("GET", "temp"))
+	// 不带过期时间的SET清除原有的过期时间
+	require.Equal(t, "OK", c.do("SET", "long", "value"))
+	ttl, err = db.TTL([]byte("long"))
+	require.NoError(t, err)
+	require.Equal(t, bitcaskkv.NoExpireTTL, ttl)
+
+	require.Equal(t, int64(2), c.do("EXISTS", "name", "missing", "temp"))
+	require.Equal(t, int64(2), c.do("DEL", "name", "missing", "temp"))
+	require.Equal(t, int64(0), c.do("EXISTS", "name", "missing"))
+
+	requireError(t, c.do("GET"), "ERR wrong number of arguments for 'get' command")
+	requireError(t, c.do("FOO", "bar"), "ERR unknown command 'FOO'")
+	requireError(t, c.do("SET", "", "value"), "ERR empty keys are not supported")
+	require.Equal(t, "OK", c.do("SELECT", "0"))
+	requireError(t, c.do("SELECT", "1"), "ERR DB index is out of range")
+
+	// inline命令
+	_, err = c.conn.Write([]byte("ECHO hi\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("hi"), c.read())
+	require.Equal(t, "OK", c.do("QUIT"))
+}
+
+func TestServerKeysAndScan(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := newTestClient(t, server)
+
+	for i := 0; i < 50; i++ {
+		require.Equal(t, "OK", c.do("SET", fmt.Sprintf("user:%02d", i), "value"))
+	}
+	require.Equal(t, "OK", c.do("SET", "order:1", "value"))
+	require.Len(t, c.do("KEYS", "*"), 51)
+	require.Len(t, c.do("KEYS", "user:*"), 50)
+	require.Equal(t, []interface{}{[]byte("user:10"), []byte("user:11")}, c.do("KEYS", "user:1[01]"))
+	require.Equal(t, []interface{}{[]byte("order:1")}, c.do("KEYS", "*der:?"))
+	require.Equal(t, []interface{}{}, c.do("KEYS", "none*"))
+
+	// 分页遍历所有匹配的key
+	seen := make(map[string]bool)
+	cursor := "0"
+	pages := 0
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "7").([]interface{})
+		for _, key := range reply[1].([]interface{}) {
+			require.False(t, seen[string(key.([]byte))])
+			seen[string(key.([]byte))] = true
+		}
+		pages++
+		cursor = string(reply[0].([]byte))
+		if cursor == "0" {
+			break
+		}
+	}
+	require.Len(t, seen, 50)
+	require.Equal(t, 8, pages)
+	reply := c.do("SCAN", "0", "COUNT", "100").([]interface{})
+	require.Equal(t, []byte("0"), reply[0])
+	require.Len(t, reply[1], 51)
+	reply = c.do("SCAN", "0", "TYPE", "hash").([]interface{})
+	require.Len(t, reply[1], 0)
+	requireError(t, c.do("SCAN", "12345"), "ERR invalid cursor")
+	requireError(t, c.do("SCAN", "jahoon"), "ERR invalid cursor")
+	requireError(t, c.do("SCAN", "0", "COUNT", "0"), "ERR syntax error")
+}
+
+func TestServerReservedPrefixKeys(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := newTestClient(t, server)
+	// 以0xff开头的字符串key与元数据及子key的前缀相同，存储时不能互相覆盖
+	require.Equal(t, int64(1), c.do("HSET", "user", "name", "jahoon"))
+	require.Equal(t, "OK", c.do("SET", "\xffmuser", "meta"))
+	require.Equal(t, "OK", c.do("SET", "\xffd\x04username", "data"))
+	require.Equal(t, []byte("meta"), c.do("GET", "\xffmuser"))
+	require.Equal(t, []byte("data"), c.do("GET", "\xffd\x04username"))
+	require.Equal(t, []byte("jahoon"), c.do("HGET", "user", "name"))
+	require.Equal(t, "string", c.do("TYPE", "\xffmuser"))
+	require.Equal(t, "hash", c.do("TYPE", "user"))
+
+	require.Len(t, c.do("KEYS", "*"), 3)
+	require.Len(t, c.do("KEYS", "\xff*"), 2)
+	seen := 0
+	for cursor := "0"; ; {
+		reply := c.do("SCAN", cursor, "COUNT", "1").([]interface{})
+		seen += len(reply[1].([]interface{}))
+		if cursor = string(reply[0].([]byte)); cursor == "0" {
+			break
+		}
+	}
+	require.Equal(t, 3, seen)
+
+	require.Equal(t, int64(2), c.do("DEL", "\xffmuser", "\xffd\x04username"))
+	require.Equal(t, []byte("jahoon"), c.do("HGET", "user", "name"))
+	require.Equal(t, int64(1), c.do("EXISTS", "user"))
+}
+
+func TestServerScanCursorIsUnsigned(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := newTestClient(t, server)
+	for i := 0; i < 20; i++ {
+		require.Equal(t, "OK", c.do("SET", fmt.Sprintf("user:%02d", i), "value"))
+	}
+	// 客户端将游标解析为十进制无符号整数
+	cursor, seen := "0", 0
+	for {
+		reply := c.do("SCAN", cursor, "COUNT", "5").([]interface{})
+		cursor = string(reply[0].([]byte))
+		_, err := strconv.ParseUint(cursor, 10, 64)
+		require.NoError(t, err)
+		seen += len(reply[1].([]interface{}))
+		if cursor == "0" {
+			break
+		}
+	}
+	require.Equal(t, 20, seen)
+
+	// 超出保存上限后，最早的游标被淘汰
+	reply := c.do("SCAN", "0", "COUNT", "1").([]interface{})
+	first := string(reply[0].([]byte))
+	for i := 0; i < maxCursors; i++ {
+		c.do("SCAN", "0", "COUNT", "1")
+	}
+	requireError(t, c.do("SCAN", first), "ERR invalid cursor")
+}
+
+func TestServerMulti(t *testing.T) {
+	server, db := newTestServer(t)
+	c := newTestClient(t, server)
+
+	require.Equal(t, "OK", c.do("SET", "a", "1"))
+	require.Equal(t, "OK", c.do("MULTI"))
+	require.Equal(t, "QUEUED", c.do("SET", "b", "2", "EX", "100"))
+	require.Equal(t, "QUEUED", c.do("GET", "b"))
+	require.Equal(t, "QUEUED", c.do("DEL", "a"))
+	require.Equal(t, "QUEUED", c.do("EXISTS", "a", "b"))
+	require.Equal(t, "QUEUED", c.do("SET", "b", "3", "NX"))
+	// 事务提交前其他连接看不到事务中的写入
+	other := newTestClient(t, server)
+	require.Nil(t, other.do("GET", "b"))
+	require.Equal(t, []interface{}{"OK", []byte("2"), int64(1), int64(1), nil}, c.do("EXEC"))
+	require.Nil(t, other.do("GET", "a"))
+	require.Equal(t, []byte("2"), other.do("GET", "b"))
+	ttl, err := db.TTL([]byte("b"))
+	require.NoError(t, err)
+	require.True(t, ttl > 99*time.Second)
+
+	// DISCARD
+	require.Equal(t, "OK", c.do("MULTI"))
+	require.Equal(t, "QUEUED", c.do("SET", "c", "1"))
+	require.Equal(t, "OK", c.do("DISCARD"))
+	require.Nil(t, c.do("GET", "c"))
+
+	// 排队时出错的事务在EXEC时被丢弃
+	require.Equal(t, "OK", c.do("MULTI"))
+	requireError(t, c.do("MULTI"), "ERR MULTI calls can not be nested")
+	require.Equal(t, "QUEUED", c.do("SET", "c", "1"))
+	requireError(t, c.do("SET", "c"), "ERR wrong number of arguments for 'set' command")
+	requireError(t, c.do("EXEC"), "EXECABORT Transaction discarded because of previous errors.")
+	require.Nil(t, c.do("GET", "c"))
+
+	requireError(t, c.do("EXEC"), "ERR EXEC without MULTI")
+	requireError(t, c.do("DISCARD"), "ERR DISCARD without MULTI")
+}
+
+func TestServerPipeline(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := newTestClient(t, server)
+
+	for i := 0; i < 100; i++ {
+		c.send("SET", strconv.Itoa(i), strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		require.Equal(t, "OK", c.read())
+	}
+	for i := 0; i < 100; i++ {
+		c.send("GET", strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		require.Equal(t, []byte(strconv.Itoa(i)), c.read())
+	}
+
+	// 协议错误时回复错误并关闭连接
+	_, err := c.conn.Write([]byte("*1\r\n+GET\r\n"))
+	require.NoError(t, err)
+	requireError(t, c.read(), "ERR Protocol error")
+	_, err = c.br.ReadByte()
+	require.Error(t, err)
+}
+
+func TestServerReadOnly(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := bitcaskkv.Open(bitcaskkv.WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("name"), []byte("jahoon")))
+	require.NoError(t, db.Close())
+
+	server, _ := newTestServer(t, bitcaskkv.WithDBDirPath(dirPath), bitcaskkv.WithReadOnly())
+	c := newTestClient(t, server)
+	require.Equal(t, []byte("jahoon"), c.do("GET", "name"))
+	requireError(t, c.do("SET", "name", "other"), "READONLY You can't write against a read only database.")
+}
+
+func TestServerDataStructures(t *testing.T) {
+	server, _ := newTestServer(t)
+	c := newTestClient(t, server)
+
+	require.Equal(t, int64(2), c.do("HSET", "user", "name", "jahoon", "age", "18"))
+	require.Equal(t, int64(0), c.do("HSET", "user", "age", "19"))
+	require.Equal(t, []byte("19"), c.do("HGET", "user", "age"))
+	require.Nil(t, c.do("HGET", "user", "missing"))
+	require.Equal(t, int64(1), c.do("HDEL", "user", "age", "missing"))
+	requireError(t, c.do("HSET", "user", "name"), "ERR wrong number of arguments for 'hset' command")
+
+	require.Equal(t, int64(2), c.do("SADD", "tags", "a", "b", "a"))
+	require.Equal(t, int64(1), c.do("SISMEMBER", "tags", "a"))
+	require.Equal(t, int64(1), c.do("SREM", "tags", "a", "c"))
+	require.Equal(t, int64(0), c.do("SISMEMBER", "tags", "a"))
+
+	require.Equal(t, int64(3), c.do("LPUSH", "queue", "a", "b", "c"))
+	require.Equal(t, []interface{}{[]byte("c"), []byte("b"), []byte("a")}, c.do("LRANGE", "queue", "0", "-1"))
+	require.Equal(t, []byte("a"), c.do("RPOP", "queue"))
+	require.Nil(t, c.do("RPOP", "missing"))
+
+	require.Equal(t, int64(3), c.do("ZADD", "rank", "1", "a", "2.5", "b", "-inf", "c"))
+	require.Equal(t, []byte("2.5"), c.do("ZSCORE", "rank", "b"))
+	require.Equal(t, []interface{}{[]byte("c"), []byte("-inf"), []byte("a"), []byte("1")},
+		c.do("ZRANGEBYSCORE", "rank", "-inf", "(2.5", "WITHSCORES"))
+	require.Equal(t, []interface{}{[]byte("a")}, c.do("ZRANGEBYSCORE", "rank", "0", "+inf", "LIMIT", "0", "1"))
+	requireError(t, c.do("ZADD", "rank", "x", "a"), "ERR value is not a valid float")
+	requireError(t, c.do("ZRANGEBYSCORE", "rank", "x", "1"), "ERR min or max is not a float")
+
+	// 类型检查
+	require.Equal(t, "OK", c.do("SET", "name", "jahoon"))
+	wrongType := "WRONGTYPE Operation against a key holding the wrong kind of value"
+	requireError(t, c.do("HGET", "name", "a"), wrongType)
+	requireError(t, c.do("GET", "user"), wrongType)
+	requireError(t, c.do("LPUSH", "tags", "a"), wrongType)
+	for key, typ := range map[string]string{"name": "string", "user": "hash", "tags": "set", "queue": "list", "rank": "zset", "missing": "none"} {
+		require.Equal(t, typ, c.do("TYPE", key))
+	}
+	require.Equal(t, int64(5), c.do("EXISTS", "name", "user", "tags", "queue", "rank", "missing"))
+
+	require.Len(t, c.do("KEYS", "*"), 5)
+	require.Equal(t, []interface{}{[]byte("tags")}, c.do("KEYS", "t*"))
+	reply := c.do("SCAN", "0", "TYPE", "hash").([]interface{})
+	require.Equal(t, []interface{}{[]byte("user")}, reply[1])
+	seen := 0
+	for cursor := "0"; ; {
+		reply := c.do("SCAN", cursor, "COUNT", "2").([]interface{})
+		seen += len(reply[1].([]interface{}))
+		if cursor = string(reply[0].([]byte)); cursor == "0" {
+			break
+		}
+	}
+	require.Equal(t, 5, seen)
+
+	// SET覆盖其他类型的key，DEL删除任意类型的key
+	require.Equal(t, "OK", c.do("SET", "user", "value"))
+	require.Equal(t, []byte("value"), c.do("GET", "user"))
+	require.Equal(t, int64(4), c.do("DEL", "user", "tags", "queue", "rank"))
+	require.Equal(t, []interface{}{[]byte("name")}, c.do("KEYS", "*"))
+
+	// 事务中后面的命令可以读取到前面命令的写入
+	require.Equal(t, "OK", c.do("MULTI"))
+	require.Equal(t, "QUEUED", c.do("ZADD", "rank", "1", "a", "2", "b"))
+	require.Equal(t, "QUEUED", c.do("ZRANGEBYSCORE", "rank", "-inf", "+inf"))
+	require.Equal(t, "QUEUED", c.do("HSET", "user", "name", "jahoon"))
+	require.Equal(t, "QUEUED", c.do("DEL", "user"))
+	require.Equal(t, "QUEUED", c.do("KEYS", "*"))
+	require.Equal(t, []interface{}{
+		int64(2),
+		[]interface{}{[]byte("a"), []byte("b")},
+		int64(1),
+		int64(1),
+		[]interface{}{[]byte("name"), []byte("rank")},
+	}, c.do("EXEC"))
+	require.Equal(t, []interface{}{[]byte("name"), []byte("rank")}, c.do("KEYS", "*"))
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbbd", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[abc]x", "bx", true},
+		{"[^abc]x", "bx", false},
+		{"[a-c]x", "cx", true},
+		{"[c-a]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"user:*:name", "user:1:name", true},
+		{"user:*:name", "user:1:age", false},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.match, matchPattern([]byte(test.pattern), []byte(test.key)), "%s %s", test.pattern, test.key)
+	}
+	require.Equal(t, []byte("user:"), patternPrefix([]byte("user:*")))
+	require.Equal(t, []byte("a*b"), patternPrefix([]byte(`a\*b?`)))
+}
@@ -0,0 +1,124 @@
+package redis
+
+// sadd 添加member，返回新增的member个数
+func (ctx *context) sadd(key []byte, members [][]byte) (int64, error) {
+	meta, err := ctx.getMeta(key, Set)
+	if err != nil {
+		return 0, err
+	}
+	var added int64
+	for _, member := range members {
+		memberKey := dataKey(key, member)
+		exists, err := ctx.exists(memberKey)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			continue
+		}
+		if err := ctx.put(memberKey, nil, 0); err != nil {
+			return 0, err
+		}
+		meta.size++
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, ctx.putMeta(key, meta)
+}
+
+func (ctx *context) sismember(key, member []byte) (bool, error) {
+	meta, err := ctx.getMeta(key, Set)
+	if err != nil || meta.size == 0 {
+		return false, err
+	}
+	return ctx.exists(dataKey(key, member))
+}
+
+// srem 删除member，返回实际删除的member个数
+func (ctx *context) srem(key []byte, members [][]byte) (int64, error) {
+	meta, err := ctx.getMeta(key, Set)
+	if err != nil || meta.size == 0 {
+		return 0, err
+	}
+	var deleted int64
+	for _, member := range members {
+		memberKey := dataKey(key, member)
+		exists, err := ctx.exists(memberKey)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			continue
+		}
+		if err := ctx.delete(memberKey); err != nil {
+			return 0, err
+		}
+		meta.size--
+		deleted++
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, ctx.putMeta(key, meta)
+}
+
+// SAdd 向set中添加member，返回member是否为新增的
+func (ds *DataStructure) SAdd(key, member []byte) (bool, error) {
+	var added int64
+	err := ds.update(func(ctx *context) (err error) {
+		added, err = ctx.sadd(key, [][]byte{member})
+		return err
+	})
+	return added > 0, err
+}
+
+// SIsMember 判断member是否存在于set中
+func (ds *DataStructure) SIsMember(key, member []byte) (bool, error) {
+	var exists bool
+	err := ds.view(func(ctx *context) (err error) {
+		exists, err = ctx.sismember(key, member)
+		return err
+	})
+	return exists, err
+}
+
+// SRem 删除set中的member，返回member是否存在
+func (ds *DataStructure) SRem(key, member []byte) (bool, error) {
+	var deleted int64
+	err := ds.update(func(ctx *context) (err error) {
+		deleted, err = ctx.srem(key, [][]byte{member})
+		return err
+	})
+	return deleted > 0, err
+}
+
+// saddCommand SADD key member [member ...]
+func saddCommand(ctx *context, args [][]byte) reply {
+	added, err := ctx.sadd(args[1], args[2:])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return integer(added)
+}
+
+func sismemberCommand(ctx *context, args [][]byte) reply {
+	exists, err := ctx.sismember(args[1], args[2])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	if exists {
+		return integer(1)
+	}
+	return integer(0)
+}
+
+// sremCommand SREM key member [member ...]
+func sremCommand(ctx *context, args [][]byte) reply {
+	deleted, err := ctx.srem(args[1], args[2:])
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return integer(deleted)
+}
@@ -0,0 +1,250 @@
+package redis
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+)
+
+// Hash、Set、List、ZSet每个key对应一条元数据，记录其类型、元素个数等信息，
+// 每个field、member、list元素分别存储为一个子key，修改时只需写入变化的子key及元数据，二者通过WriteBatch原子地提交
+//
+// 字符串的value直接存储在key下，元数据及子key存储在以0xff开头的key下，以0xff开头的字符串key增加前缀，避免与二者冲突：
+//
+//	字符串: key，以0xff开头时为 0xff 's' key
+//	元数据: 0xff 'm' key
+//	子key:  0xff 'd' len(key) key ...
+//	  Hash: ... field -> value
+//	  Set:  ... member -> 空
+//	  List: ... index(8字节大端) -> element
+//	  ZSet: ... 'm' member -> score，... 's' score(8字节，按大小排序) member -> 空
+
+var (
+	ErrWrongType    = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrInvalidScore = errors.New("the score is not a number")
+)
+
+// DataType key存储的数据类型
+type DataType byte
+
+const (
+	None DataType = iota
+	String
+	Hash
+	Set
+	List
+	ZSet
+)
+
+func (t DataType) String() string {
+	switch t {
+	case String:
+		return "string"
+	case Hash:
+		return "hash"
+	case Set:
+		return "set"
+	case List:
+		return "list"
+	case ZSet:
+		return "zset"
+	default:
+		return "none"
+	}
+}
+
+const reservedKeyByte = 0xff
+
+var (
+	metaKeyPrefix   = []byte{reservedKeyByte, 'm'}
+	dataKeyPrefix   = []byte{reservedKeyByte, 'd'}
+	stringKeyPrefix = []byte{reservedKeyByte, 's'}
+)
+
+// 新建list时head和tail的初始位置，两端均可以继续写入
+const initialListIndex = math.MaxUint64 / 2
+
+// metadata Hash、Set、List、ZSet的元数据
+type metadata struct {
+	dataType DataType
+	size     uint64
+	head     uint64 // list第一个元素的位置
+	tail     uint64 // list最后一个元素之后的位置
+}
+
+func encodeMetadata(meta *metadata) []byte {
+	buf := make([]byte, 1+3*binary.MaxVarintLen64)
+	buf[0] = byte(meta.dataType)
+	n := 1
+	n += binary.PutUvarint(buf[n:], meta.size)
+	if meta.dataType == List {
+		n += binary.PutUvarint(buf[n:], meta.head)
+		n += binary.PutUvarint(buf[n:], meta.tail)
+	}
+	return buf[:n]
+}
+
+func decodeMetadata(buf []byte) *metadata {
+	meta := &metadata{dataType: DataType(buf[0])}
+	n := 1
+	size, m := binary.Uvarint(buf[n:])
+	meta.size = size
+	n += m
+	if meta.dataType == List {
+		meta.head, m = binary.Uvarint(buf[n:])
+		n += m
+		meta.tail, _ = binary.Uvarint(buf[n:])
+	}
+	return meta
+}
+
+func metaKey(key []byte) []byte {
+	return append(append([]byte{}, metaKeyPrefix...), key...)
+}
+
+// dataKey 返回key所有子key的公共前缀，key的长度确保不同key的子key之间不会混淆
+func dataKey(key []byte, parts ...[]byte) []byte {
+	buf := make([]byte, len(dataKeyPrefix)+binary.MaxVarintLen64+len(key))
+	n := copy(buf, dataKeyPrefix)
+	n += binary.PutUvarint(buf[n:], uint64(len(key)))
+	n += copy(buf[n:], key)
+	buf = buf[:n]
+	for _, part := range parts {
+		buf = append(buf, part...)
+	}
+	return buf
+}
+
+func isReservedKey(key []byte) bool {
+	return len(key) > 0 && key[0] == reservedKeyByte
+}
+
+// stringKey 返回字符串实际存储的key
+func stringKey(key []byte) []byte {
+	if isReservedKey(key) {
+		return append(append([]byte{}, stringKeyPrefix...), key...)
+	}
+	return key
+}
+
+// getMeta 获取key的元数据，key不存在时返回dataType类型的空元数据，key存储其他类型的数据时返回ErrWrongType
+func (ctx *context) getMeta(key []byte, dataType DataType) (*metadata, error) {
+	buf, err := ctx.get(metaKey(key))
+	if err != nil && err != bitcaskkv.ErrKeyIsNotFound {
+		return nil, err
+	}
+	if err == nil {
+		meta := decodeMetadata(buf)
+		if meta.dataType != dataType {
+			return nil, ErrWrongType
+		}
+		return meta, nil
+	}
+	exists, err := ctx.exists(stringKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrWrongType
+	}
+	meta := &metadata{dataType: dataType}
+	if dataType == List {
+		meta.head, meta.tail = initialListIndex, initialListIndex
+	}
+	return meta, nil
+}
+
+// putMeta 写入元数据，元素个数为0时删除元数据
+func (ctx *context) putMeta(key []byte, meta *metadata) error {
+	if meta.size == 0 {
+		return ctx.delete(metaKey(key))
+	}
+	return ctx.put(metaKey(key), encodeMetadata(meta), 0)
+}
+
+// keyType 返回key存储的数据类型
+func (ctx *context) keyType(key []byte) (DataType, error) {
+	exists, err := ctx.exists(stringKey(key))
+	if err != nil || exists {
+		return String, err
+	}
+	buf, err := ctx.get(metaKey(key))
+	if err == bitcaskkv.ErrKeyIsNotFound {
+		return None, nil
+	}
+	if err != nil {
+		return None, err
+	}
+	return DataType(buf[0]), nil
+}
+
+// deleteKey 删除key及其所有子key，返回key是否存在
+func (ctx *context) deleteKey(key []byte) (bool, error) {
+	dataType, err := ctx.keyType(key)
+	if err != nil || dataType == None {
+		return false, err
+	}
+	if dataType == String {
+		return true, ctx.delete(stringKey(key))
+	}
+	ctx.scan(dataKey(key), nil, func(subKey []byte) bool {
+		err = ctx.delete(subKey)
+		return err == nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, ctx.delete(metaKey(key))
+}
+
+// DataStructure 基于DB实现的Hash、Set、List、ZSet数据结构，与Server使用同一个DB时应通过Server.DataStructure获取
+type DataStructure struct {
+	db *bitcaskkv.DB
+	// 需要先读取再写入的操作及读取多个key的操作执行期间独占，直接写入的操作之间可以并发
+	mu sync.RWMutex
+}
+
+func NewDataStructure(db *bitcaskkv.DB) *DataStructure {
+	return &DataStructure{db: db}
+}
+
+// update 独占执行fn，并提交fn中的所有写入
+func (ds *DataStructure) update(fn func(ctx *context) error) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ctx := newContext(nil, ds.db)
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	return ctx.commit()
+}
+
+// view 独占执行只读的fn，元数据与子key分多次读取，期间不能有其他写入
+func (ds *DataStructure) view(fn func(ctx *context) error) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return fn(newContext(nil, ds.db))
+}
+
+// Type 返回key存储的数据类型，key不存在时返回None
+func (ds *DataStructure) Type(key []byte) (DataType, error) {
+	var dataType DataType
+	err := ds.view(func(ctx *context) (err error) {
+		dataType, err = ctx.keyType(key)
+		return err
+	})
+	return dataType, err
+}
+
+// Del 删除任意类型的key，返回key是否存在
+func (ds *DataStructure) Del(key []byte) (bool, error) {
+	var deleted bool
+	err := ds.update(func(ctx *context) (err error) {
+		deleted, err = ctx.deleteKey(key)
+		return err
+	})
+	return deleted, err
+}
@@ -0,0 +1,268 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDataStructure(t *testing.T) (*DataStructure, *bitcaskkv.DB) {
+	db, err := bitcaskkv.Open(bitcaskkv.WithDBDirPath(t.TempDir()))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewDataStructure(db), db
+}
+
+func TestDataStructureHash(t *testing.T) {
+	ds, db := newTestDataStructure(t)
+	key := []byte("user")
+
+	added, err := ds.HSet(key, []byte("name"), []byte("jahoon"))
+	require.NoError(t, err)
+	require.True(t, added)
+	added, err = ds.HSet(key, []byte("name"), []byte("other"))
+	require.NoError(t, err)
+	require.False(t, added)
+	added, err = ds.HSet(key, []byte("empty"), nil)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	value, err := ds.HGet(key, []byte("name"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("other"), value)
+	value, err = ds.HGet(key, []byte("empty"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{}, value)
+	_, err = ds.HGet(key, []byte("age"))
+	require.ErrorIs(t, err, bitcaskkv.ErrKeyIsNotFound)
+	dataType, err := ds.Type(key)
+	require.NoError(t, err)
+	require.Equal(t, Hash, dataType)
+
+	deleted, err := ds.HDel(key, []byte("name"))
+	require.NoError(t, err)
+	require.True(t, deleted)
+	deleted, err = ds.HDel(key, []byte("name"))
+	require.NoError(t, err)
+	require.False(t, deleted)
+	deleted, err = ds.HDel(key, []byte("empty"))
+	require.NoError(t, err)
+	require.True(t, deleted)
+	// 最后一个field删除后key不再存在
+	dataType, err = ds.Type(key)
+	require.NoError(t, err)
+	require.Equal(t, None, dataType)
+	require.Empty(t, db.ListKeys(false))
+}
+
+func TestDataStructureSet(t *testing.T) {
+	ds, _ := newTestDataStructure(t)
+	key := []byte("tags")
+
+	added, err := ds.SAdd(key, []byte("a"))
+	require.NoError(t, err)
+	require.True(t, added)
+	added, err = ds.SAdd(key, []byte("a"))
+	require.NoError(t, err)
+	require.False(t, added)
+	_, err = ds.SAdd(key, []byte("b"))
+	require.NoError(t, err)
+
+	exists, err := ds.SIsMember(key, []byte("a"))
+	require.NoError(t, err)
+	require.True(t, exists)
+	exists, err = ds.SIsMember(key, []byte("c"))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	deleted, err := ds.SRem(key, []byte("a"))
+	require.NoError(t, err)
+	require.True(t, deleted)
+	exists, err = ds.SIsMember(key, []byte("a"))
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = ds.SIsMember([]byte("missing"), []byte("a"))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestDataStructureList(t *testing.T) {
+	ds, _ := newTestDataStructure(t)
+	key := []byte("queue")
+
+	for i, element := range []string{"a", "b", "c", "d"} {
+		size, err := ds.LPush(key, []byte(element))
+		require.NoError(t, err)
+		require.Equal(t, int64(i+1), size)
+	}
+	elements, err := ds.LRange(key, 0, -1)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("d"), []byte("c"), []byte("b"), []byte("a")}, elements)
+	elements, err = ds.LRange(key, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("c"), []byte("b")}, elements)
+	elements, err = ds.LRange(key, -2, 100)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("b"), []byte("a")}, elements)
+	elements, err = ds.LRange(key, 3, 1)
+	require.NoError(t, err)
+	require.Empty(t, elements)
+
+	for _, element := range []string{"a", "b", "c", "d"} {
+		value, err := ds.RPop(key)
+		require.NoError(t, err)
+		require.Equal(t, []byte(element), value)
+	}
+	value, err := ds.RPop(key)
+	require.NoError(t, err)
+	require.Nil(t, value)
+	elements, err = ds.LRange(key, 0, -1)
+	require.NoError(t, err)
+	require.Empty(t, elements)
+}
+
+func TestDataStructureListConcurrentReadWrite(t *testing.T) {
+	ds, _ := newTestDataStructure(t)
+	key := []byte("queue")
+	for i := 0; i < 10; i++ {
+		_, err := ds.LPush(key, []byte(strconv.Itoa(i)))
+		require.NoError(t, err)
+	}
+
+	// 写入者不断在头部插入并从尾部移除元素，读取者读取到的list长度始终为10或11
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 10; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, err := ds.LPush(key, []byte(strconv.Itoa(i)))
+			require.NoError(t, err)
+			_, err = ds.RPop(key)
+			require.NoError(t, err)
+		}
+	}()
+	for i := 0; i < 2000; i++ {
+		elements, err := ds.LRange(key, 0, -1)
+		require.NoError(t, err)
+		require.Contains(t, []int{10, 11}, len(elements))
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestDataStructureZSet(t *testing.T) {
+	ds, _ := newTestDataStructure(t)
+	key := []byte("rank")
+
+	for member, score := range map[string]float64{"a": 3, "b": -1.5, "c": 0, "d": 100, "e": math.Inf(-1)} {
+		added, err := ds.ZAdd(key, score, []byte(member))
+		require.NoError(t, err)
+		require.True(t, added)
+	}
+	// 更新score
+	added, err := ds.ZAdd(key, 2, []byte("d"))
+	require.NoError(t, err)
+	require.False(t, added)
+	_, err = ds.ZAdd(key, math.NaN(), []byte("f"))
+	require.ErrorIs(t, err, ErrInvalidScore)
+
+	score, err := ds.ZScore(key, []byte("d"))
+	require.NoError(t, err)
+	require.Equal(t, float64(2), score)
+	_, err = ds.ZScore(key, []byte("f"))
+	require.ErrorIs(t, err, bitcaskkv.ErrKeyIsNotFound)
+
+	members, err := ds.ZRangeByScore(key, -2, 2)
+	require.NoError(t, err)
+	require.Equal(t, []*ZMember{
+		{Member: []byte("b"), Score: -1.5},
+		{Member: []byte("c"), Score: 0},
+		{Member: []byte("d"), Score: 2},
+	}, members)
+	members, err = ds.ZRangeByScore(key, math.Inf(-1), math.Inf(1))
+	require.NoError(t, err)
+	require.Len(t, members, 5)
+	require.Equal(t, []byte("e"), members[0].Member)
+	require.Equal(t, []byte("a"), members[4].Member)
+}
+
+func TestDataStructureType(t *testing.T) {
+	ds, db := newTestDataStructure(t)
+
+	require.NoError(t, db.Put([]byte("name"), []byte("jahoon")))
+	_, err := ds.HSet([]byte("name"), []byte("a"), []byte("b"))
+	require.ErrorIs(t, err, ErrWrongType)
+	_, err = ds.SAdd([]byte("tags"), []byte("a"))
+	require.NoError(t, err)
+	_, err = ds.LPush([]byte("tags"), []byte("a"))
+	require.ErrorIs(t, err, ErrWrongType)
+	_, err = ds.ZScore([]byte("tags"), []byte("a"))
+	require.ErrorIs(t, err, ErrWrongType)
+	// 以0xff开头的key与元数据及子key互不影响
+	_, err = ds.HSet([]byte{0xff, 'm'}, []byte("a"), []byte("b"))
+	require.NoError(t, err)
+	value, err := ds.HGet([]byte{0xff, 'm'}, []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), value)
+	deleted, err := ds.Del([]byte{0xff, 'm'})
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	dataType, err := ds.Type([]byte("name"))
+	require.NoError(t, err)
+	require.Equal(t, String, dataType)
+
+	// 删除key时同时删除所有子key
+	for i := 0; i < 200; i++ {
+		_, err := ds.SAdd([]byte("tags"), []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	deleted, err = ds.Del([]byte("tags"))
+	require.NoError(t, err)
+	require.True(t, deleted)
+	require.Len(t, db.ListKeys(false), 1)
+	deleted, err = ds.Del([]byte("name"))
+	require.NoError(t, err)
+	require.True(t, deleted)
+	deleted, err = ds.Del([]byte("name"))
+	require.NoError(t, err)
+	require.False(t, deleted)
+}
+
+func TestDataStructureReopen(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := bitcaskkv.Open(bitcaskkv.WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	ds := NewDataStructure(db)
+	_, err = ds.HSet([]byte("user"), []byte("name"), []byte("jahoon"))
+	require.NoError(t, err)
+	_, err = ds.LPush([]byte("queue"), []byte("a"))
+	require.NoError(t, err)
+	_, err = ds.ZAdd([]byte("rank"), 1, []byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = bitcaskkv.Open(bitcaskkv.WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	defer db.Close()
+	ds = NewDataStructure(db)
+	value, err := ds.HGet([]byte("user"), []byte("name"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("jahoon"), value)
+	size, err := ds.LPush([]byte("queue"), []byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), size)
+	score, err := ds.ZScore([]byte("rank"), []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, float64(1), score)
+}
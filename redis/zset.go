@@ -0,0 +1,297 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+
+	bitcaskkv "github.com/GGjahon/bitcask-kv"
+)
+
+// ZMember sorted set中的member及其score
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+var (
+	zsetMemberPart = []byte{'m'}
+	zsetScorePart  = []byte{'s'}
+)
+
+func zsetMemberKey(key, member []byte) []byte {
+	return dataKey(key, zsetMemberPart, member)
+}
+
+func zsetScoreKey(key []byte, score float64, member []byte) []byte {
+	return dataKey(key, zsetScorePart, encodeSortableScore(score), member)
+}
+
+// encodeSortableScore 将score编码为8字节，编码结果的字节序与score的大小顺序一致
+func encodeSortableScore(score float64) []byte {
+	if score == 0 {
+		// -0与0视为同一个score
+		score = 0
+	}
+	bits := math.Float64bits(score)
+	if bits>>63 == 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+func decodeSortableScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits>>63 == 1 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+func encodeScore(score float64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(score))
+	return buf
+}
+
+func decodeScore(buf []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(buf))
+}
+
+// zadd 添加member或更新其score，返回新增的member个数
+func (ctx *context) zadd(key []byte, members []*ZMember) (int64, error) {
+	meta, err := ctx.getMeta(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	var added int64
+	for _, m := range members {
+		memberKey := zsetMemberKey(key, m.Member)
+		buf, err := ctx.get(memberKey)
+		switch {
+		case err == bitcaskkv.ErrKeyIsNotFound:
+			meta.size++
+			added++
+		case err != nil:
+			return 0, err
+		default:
+			oldScore := decodeScore(buf)
+			if oldScore == m.Score {
+				continue
+			}
+			if err := ctx.delete(zsetScoreKey(key, oldScore, m.Member)); err != nil {
+				return 0, err
+			}
+		}
+		if err := ctx.put(memberKey, encodeScore(m.Score), 0); err != nil {
+			return 0, err
+		}
+		if err := ctx.put(zsetScoreKey(key, m.Score, m.Member), nil, 0); err != nil {
+			return 0, err
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, ctx.putMeta(key, meta)
+}
+
+func (ctx *context) zscore(key, member []byte) (float64, error) {
+	meta, err := ctx.getMeta(key, ZSet)
+	if err != nil {
+		return 0, err
+	}
+	if meta.size == 0 {
+		return 0, bitcaskkv.ErrKeyIsNotFound
+	}
+	buf, err := ctx.get(zsetMemberKey(key, member))
+	if err != nil {
+		return 0, err
+	}
+	return decodeScore(buf), nil
+}
+
+// scoreRange score的范围，exclusive表示不包含边界
+type scoreRange struct {
+	min, max                   float64
+	minExclusive, maxExclusive bool
+}
+
+func (r *scoreRange) contains(score float64) bool {
+	return (score > r.min || (!r.minExclusive && score == r.min)) &&
+		(score < r.max || (!r.maxExclusive && score == r.max))
+}
+
+// zrangeByScore 按score从小到大返回score在范围内的member
+func (ctx *context) zrangeByScore(key []byte, r *scoreRange) ([]*ZMember, error) {
+	meta, err := ctx.getMeta(key, ZSet)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*ZMember, 0)
+	if meta.size == 0 {
+		return members, nil
+	}
+	prefix := dataKey(key, zsetScorePart)
+	ctx.scan(prefix, append(bytes.Clone(prefix), encodeSortableScore(r.min)...), func(scoreKey []byte) bool {
+		score := decodeSortableScore(scoreKey[len(prefix) : len(prefix)+8])
+		if score > r.max {
+			return false
+		}
+		if r.contains(score) {
+			members = append(members, &ZMember{Member: scoreKey[len(prefix)+8:], Score: score})
+		}
+		return true
+	})
+	return members, nil
+}
+
+// ZAdd 向sorted set中添加member或更新其score，返回member是否为新增的
+func (ds *DataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	if math.IsNaN(score) {
+		return false, ErrInvalidScore
+	}
+	var added int64
+	err := ds.update(func(ctx *context) (err error) {
+		added, err = ctx.zadd(key, []*ZMember{{Member: member, Score: score}})
+		return err
+	})
+	return added > 0, err
+}
+
+// ZScore 返回sorted set中member的score，不存在时返回ErrKeyIsNotFound
+func (ds *DataStructure) ZScore(key, member []byte) (float64, error) {
+	var score float64
+	err := ds.view(func(ctx *context) (err error) {
+		score, err = ctx.zscore(key, member)
+		return err
+	})
+	return score, err
+}
+
+// ZRangeByScore 按score从小到大返回score在[min, max]范围内的member
+func (ds *DataStructure) ZRangeByScore(key []byte, min, max float64) ([]*ZMember, error) {
+	var members []*ZMember
+	err := ds.view(func(ctx *context) (err error) {
+		members, err = ctx.zrangeByScore(key, &scoreRange{min: min, max: max})
+		return err
+	})
+	return members, err
+}
+
+func parseScore(arg []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	return score, err == nil && !math.IsNaN(score)
+}
+
+// parseScoreBound 解析score范围的边界，以(开头表示不包含该边界
+func parseScoreBound(arg []byte) (float64, bool, bool) {
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, ok := parseScore(arg)
+	return score, exclusive, ok
+}
+
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	default:
+		return strconv.AppendFloat(nil, score, 'g', 17, 64)
+	}
+}
+
+// zaddCommand ZADD key score member [score member ...]
+func zaddCommand(ctx *context, args [][]byte) reply {
+	if len(args)%2 != 0 {
+		return syntaxErrReply
+	}
+	members := make([]*ZMember, 0, (len(args)-2)/2)
+	for i := 2; i < len(args); i += 2 {
+		score, ok := parseScore(args[i])
+		if !ok {
+			return errorReply("ERR value is not a valid float")
+		}
+		members = append(members, &ZMember{Member: args[i+1], Score: score})
+	}
+	added, err := ctx.zadd(args[1], members)
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return integer(added)
+}
+
+func zscoreCommand(ctx *context, args [][]byte) reply {
+	score, err := ctx.zscore(args[1], args[2])
+	if err == bitcaskkv.ErrKeyIsNotFound {
+		return nilReply
+	}
+	if err != nil {
+		return errorFromDB(err)
+	}
+	return bulkString(formatScore(score))
+}
+
+// zrangeByScoreCommand ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func zrangeByScoreCommand(ctx *context, args [][]byte) reply {
+	r := &scoreRange{}
+	var minOk, maxOk bool
+	r.min, r.minExclusive, minOk = parseScoreBound(args[2])
+	r.max, r.maxExclusive, maxOk = parseScoreBound(args[3])
+	if !minOk || !maxOk {
+		return errorReply("ERR min or max is not a float")
+	}
+	var withScores bool
+	var offset, count int64 = 0, -1
+	for i := 4; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return syntaxErrReply
+			}
+			var offsetOk, countOk bool
+			offset, offsetOk = parseInt(args[i+1])
+			count, countOk = parseInt(args[i+2])
+			if !offsetOk || !countOk {
+				return notIntegerReply
+			}
+			i += 2
+		default:
+			return syntaxErrReply
+		}
+	}
+	members, err := ctx.zrangeByScore(args[1], r)
+	if err != nil {
+		return errorFromDB(err)
+	}
+	if offset < 0 || offset >= int64(len(members)) {
+		members = nil
+	} else {
+		members = members[offset:]
+	}
+	if count >= 0 && count < int64(len(members)) {
+		members = members[:count]
+	}
+	replies := make(array, 0, len(members))
+	for _, m := range members {
+		replies = append(replies, bulkString(m.Member))
+		if withScores {
+			replies = append(replies, bulkString(formatScore(m.Score)))
+		}
+	}
+	return replies
+}
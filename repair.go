@@ -0,0 +1,485 @@
+package bitcaskkv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/utils"
+)
+
+const (
+	// 修复时重写数据文件使用的临时文件后缀
+	repairTempFileSuffix = ".repair"
+	// 修复时每次复制的数据大小
+	repairBufSize = 1024 * 1024
+	// B+树索引文件，其中的数据位置在修复后失效
+	bptreeIndexFileName = "bptree-index"
+)
+
+// QuarantinedRange 数据文件中无法读取而被移动到隔离文件中的一段数据
+type QuarantinedRange struct {
+	Fid              uint32
+	Offset           int64 // 在原数据文件中的位置
+	Size             int64
+	QuarantineOffset int64 // 在隔离文件中的位置
+}
+
+// LostKey 因数据损坏而丢失的key
+type LostKey struct {
+	Key    []byte
+	Fid    uint32
+	Offset int64 // 丢失的数据在原数据文件中的位置
+	// 丢失的是删除标记，key可能恢复为删除前的值
+	Deleted bool
+	// key是否来自hint文件，为false时key从损坏的数据中解析得到，可能并不准确
+	FromHint bool
+}
+
+// RepairReport 修复的结果
+type RepairReport struct {
+	RepairedFiles []uint32 // 被修复的数据文件
+	Quarantined   []*QuarantinedRange
+	// 没有被之后的写入覆盖的丢失数据，之后被删除的key也可能包含在内
+	LostKeys []*LostKey
+	// 因损坏或数据位置失效而删除的文件
+	RemovedFiles []string
+}
+
+func (r *RepairReport) String() string {
+	var b strings.Builder
+	for _, fid := range r.RepairedFiles {
+		fmt.Fprintf(&b, "repaired %s\n", filepath.Base(data.GetDataFileName("", fid)))
+	}
+	for _, q := range r.Quarantined {
+		fmt.Fprintf(&b, "quarantined %s offset=%d size=%d -> %s offset=%d\n", filepath.Base(data.GetDataFileName("", q.Fid)),
+			q.Offset, q.Size, filepath.Base(data.GetQuarantineFileName("", q.Fid)), q.QuarantineOffset)
+	}
+	for _, lost := range r.LostKeys {
+		fmt.Fprintf(&b, "lost key=%q %s offset=%d deleted=%t from_hint=%t\n", lost.Key,
+			filepath.Base(data.GetDataFileName("", lost.Fid)), lost.Offset, lost.Deleted, lost.FromHint)
+	}
+	for _, fileName := range r.RemovedFiles {
+		fmt.Fprintf(&b, "removed %s\n", fileName)
+	}
+	return b.String()
+}
+
+// lostCandidate 可能丢失的数据，修复后key的位置不在after之后时视为丢失
+type lostCandidate struct {
+	lost  *LostKey
+	after int64 // 修复后数据文件中位于丢失数据之后的第一个位置
+}
+
+type repairer struct {
+	db         *DB // 只用于读取目录下的文件，并未打开
+	report     *RepairReport
+	candidates []*lostCandidate
+}
+
+// Repair 修复dirPath下存在损坏数据的数据文件，使db可以重新打开，opts为平时打开db使用的配置，执行期间db不能被打开。
+// 修复时跳过无法读取的数据，从之后第一条crc校验通过的记录继续读取，无法读取的数据移动到数据文件对应的隔离文件中，
+// 并删除因数据位置改变而失效的hint文件、索引快照及B+树索引。之后重新打开db重建索引及hint文件，
+// 检查丢失的数据是否已被之后的写入覆盖，修复结果同时追加写入目录下的修复报告文件
+func Repair(dirPath string, opts ...DBOption) (*RepairReport, error) {
+	var options Options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	options.DirPath = dirPath
+	repaireDB(&options)
+	if err := checkEncryptionKey(options.EncryptionKey); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dirPath); err != nil {
+		return nil, err
+	}
+	r := &repairer{db: &DB{Options: options}, report: &RepairReport{}}
+	if err := r.repairFiles(); err != nil {
+		return nil, err
+	}
+	// 修复期间不进行merge及blob gc，以免数据位置在检查丢失的key之前发生变化
+	db, err := Open(append(opts, WithDBDirPath(dirPath), WithDBMergeRatio(0), WithDBBlobGCRatio(0))...)
+	if err != nil {
+		return nil, err
+	}
+	r.checkLostKeys(db)
+	if err := r.writeReport(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	// 关闭时等待后台生成的hint文件写入完成
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+	return r.report, nil
+}
+
+// repairFiles 持有目录锁修复所有数据文件，并删除失效的文件
+func (r *repairer) repairFiles() error {
+	fileLock, err := fio.NewFileLock(filepath.Join(r.db.DirPath, fileLockName), false)
+	if err != nil {
+		return err
+	}
+	defer fileLock.Unlock()
+	locked, err := fileLock.TryLock()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return ErrDatabaseIsUsing
+	}
+
+	fileIds, err := r.dataFileIds()
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		if err := r.repairDataFile(fid); err != nil {
+			return err
+		}
+	}
+
+	var removedFiles []string
+	// merge生成的hint文件及merge完成标志文件损坏时无法打开db，删除后会从各数据文件中加载索引
+	if len(r.report.RepairedFiles) > 0 || !r.mergeFilesReadable() {
+		removedFiles = append(removedFiles, data.HintFileName, data.MergeFinishedFileName)
+	}
+	if len(r.report.RepairedFiles) > 0 {
+		removedFiles = append(removedFiles, data.IndexSnapshotFileName, bptreeIndexFileName)
+	}
+	for _, fileName := range removedFiles {
+		filePath := filepath.Join(r.db.DirPath, fileName)
+		if _, err := os.Stat(filePath); err != nil {
+			continue
+		}
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+		r.report.RemovedFiles = append(r.report.RemovedFiles, fileName)
+	}
+	return utils.SyncDir(r.db.DirPath)
+}
+
+func (r *repairer) dataFileIds() ([]uint32, error) {
+	entries, err := os.ReadDir(r.db.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []int
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+			if err != nil {
+				return nil, ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Ints(fileIds)
+	fids := make([]uint32, len(fileIds))
+	for i, fid := range fileIds {
+		fids[i] = uint32(fid)
+	}
+	return fids, nil
+}
+
+// mergeFilesReadable 判断merge完成标志文件及merge生成的hint文件能否完整读取
+func (r *repairer) mergeFilesReadable() bool {
+	if _, err := os.Stat(filepath.Join(r.db.DirPath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		return true
+	}
+	if _, err := r.db.getNoMergeFileId(r.db.DirPath); err != nil {
+		return false
+	}
+	if _, err := r.db.getMergedFileNum(r.db.DirPath); err != nil {
+		return false
+	}
+	return r.db.foreachHintRecord(r.db.DirPath, fio.StandardFIO, func([]byte, *data.LogRecordPos) {}) == nil
+}
+
+// repairDataFile 找出数据文件中无法读取的数据，将其移动到隔离文件中，并使用剩余的数据重写数据文件
+func (r *repairer) repairDataFile(fid uint32) error {
+	dataFile, err := data.OpenDataFile(r.db.DirPath, fid, fio.StandardFIO, r.db.ioOptions()...)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	ranges, err := scanDataFile(dataFile, size)
+	if err != nil || len(ranges) == 0 {
+		return err
+	}
+	r.findLostKeys(dataFile, ranges)
+	// 先持久化隔离的数据，再替换数据文件
+	if err := r.quarantine(dataFile, ranges); err != nil {
+		return err
+	}
+	if err := r.rewriteDataFile(dataFile, size, ranges); err != nil {
+		return err
+	}
+	// 数据文件中的数据位置已经改变，hint文件失效
+	if err := os.RemoveAll(data.GetDataHintFileName(r.db.DirPath, fid)); err != nil {
+		return err
+	}
+	r.report.RepairedFiles = append(r.report.RepairedFiles, fid)
+	r.report.Quarantined = append(r.report.Quarantined, ranges...)
+	return nil
+}
+
+// scanDataFile 扫描数据文件，返回其中所有无法读取的数据范围
+func scanDataFile(dataFile *data.DataFile, size int64) ([]*QuarantinedRange, error) {
+	var ranges []*QuarantinedRange
+	var offset int64 = 0
+	for offset < size {
+		recordSize, err := readValidRecord(dataFile, offset)
+		if err != nil {
+			return nil, err
+		}
+		if recordSize > 0 {
+			offset += recordSize
+			continue
+		}
+		// 逐字节向后查找下一条可以读取的记录
+		start := offset
+		for offset++; offset < size; offset++ {
+			if recordSize, err = readValidRecord(dataFile, offset); err != nil {
+				return nil, err
+			}
+			if recordSize > 0 {
+				break
+			}
+		}
+		ranges = append(ranges, &QuarantinedRange{Fid: dataFile.FileID, Offset: start, Size: offset - start})
+	}
+	return ranges, nil
+}
+
+// readValidRecord 读取offset处的记录，返回其大小，记录损坏时返回0
+func readValidRecord(dataFile *data.DataFile, offset int64) (int64, error) {
+	encLogRecord, size, logRecordHeader, err := dataFile.Get(offset)
+	if err == nil {
+		_, err = data.DecodeLogRecord(encLogRecord, logRecordHeader)
+	}
+	switch {
+	case err == nil:
+		return size, nil
+	case isCorruptedRecord(err):
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
+
+// findLostKeys 找出无法读取的数据中包含的key，优先从数据文件的hint文件中查找，
+// hint文件不存在时尝试从每段损坏数据开头的记录中解析key
+func (r *repairer) findLostKeys(dataFile *data.DataFile, ranges []*QuarantinedRange) {
+	var entries []*hintEntry
+	if _, err := os.Stat(data.GetDataHintFileName(r.db.DirPath, dataFile.FileID)); err == nil {
+		// hint文件与数据文件不一致时无法使用
+		entries, _ = r.db.readDataHintEntries(dataFile)
+	}
+	if entries == nil {
+		for _, qr := range ranges {
+			encLogRecord, _, logRecordHeader, err := dataFile.Get(qr.Offset)
+			if err != nil || logRecordHeader.Type() > data.LogRecordBlob || logRecordHeader.Type() == data.LogRecordTxnFinished {
+				continue
+			}
+			key, seqNo := ParseLogRecordKey(data.DecodeLogRecordKey(encLogRecord, logRecordHeader))
+			// 事务中的数据只有读取到事务完成标志后才会生效，无法确认其是否已经提交
+			if seqNo != nonTransactionSeqNo {
+				continue
+			}
+			r.addCandidate(&LostKey{
+				Key:     append([]byte{}, key...),
+				Fid:     dataFile.FileID,
+				Offset:  qr.Offset,
+				Deleted: logRecordHeader.Type() == data.LogRecordDeleted,
+			}, ranges)
+		}
+		return
+	}
+
+	// 事务完成标志丢失时，同一事务中的所有数据都不再生效
+	lostTxns := make(map[uint64]struct{})
+	for _, entry := range entries {
+		if _, seqNo := ParseLogRecordKey(entry.record.Key); entry.record.Type == data.LogRecordTxnFinished &&
+			inQuarantinedRange(ranges, entry.pos.Offset) {
+			lostTxns[seqNo] = struct{}{}
+		}
+	}
+	for _, entry := range entries {
+		key, seqNo := ParseLogRecordKey(entry.record.Key)
+		if entry.record.Type == data.LogRecordTxnFinished {
+			continue
+		}
+		if _, ok := lostTxns[seqNo]; !ok && !inQuarantinedRange(ranges, entry.pos.Offset) {
+			continue
+		}
+		r.addCandidate(&LostKey{
+			Key:      key,
+			Fid:      dataFile.FileID,
+			Offset:   entry.pos.Offset,
+			Deleted:  entry.record.Type == data.LogRecordDeleted,
+			FromHint: true,
+		}, ranges)
+	}
+}
+
+func inQuarantinedRange(ranges []*QuarantinedRange, offset int64) bool {
+	for _, qr := range ranges {
+		if offset >= qr.Offset && offset < qr.Offset+qr.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// addCandidate 记录可能丢失的数据，并计算修复后位于其之后的第一个位置
+func (r *repairer) addCandidate(lost *LostKey, ranges []*QuarantinedRange) {
+	var removed int64
+	after := int64(-1)
+	for _, qr := range ranges {
+		if lost.Offset >= qr.Offset+qr.Size {
+			removed += qr.Size
+			continue
+		}
+		// 被隔离的数据之后的第一条记录修复后位于该段数据原来的起始位置
+		if lost.Offset >= qr.Offset {
+			after = qr.Offset - removed
+		}
+		break
+	}
+	if after < 0 {
+		after = lost.Offset - removed + 1
+	}
+	r.candidates = append(r.candidates, &lostCandidate{lost: lost, after: after})
+}
+
+// quarantine 将无法读取的数据追加写入数据文件对应的隔离文件，其中无法解密的部分以0填充
+func (r *repairer) quarantine(dataFile *data.DataFile, ranges []*QuarantinedRange) error {
+	quarantineFile, err := fio.NewIoManager(data.GetQuarantineFileName(r.db.DirPath, dataFile.FileID), fio.StandardFIO, r.db.ioOptions()...)
+	if err != nil {
+		return err
+	}
+	defer quarantineFile.Close()
+	offset, err := quarantineFile.Size()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, repairBufSize)
+	for _, qr := range ranges {
+		qr.QuarantineOffset = offset
+		for copied := int64(0); copied < qr.Size; {
+			n := int64(len(buf))
+			if n > qr.Size-copied {
+				n = qr.Size - copied
+			}
+			if _, err := dataFile.IoManager.Read(buf[:n], qr.Offset+copied); err != nil {
+				for i := range buf[:n] {
+					buf[i] = 0
+				}
+			}
+			if _, err := quarantineFile.Write(buf[:n]); err != nil {
+				return err
+			}
+			copied += n
+		}
+		offset += qr.Size
+	}
+	return quarantineFile.Sync()
+}
+
+// rewriteDataFile 将数据文件中除ranges之外的数据写入临时文件，之后替换原数据文件
+func (r *repairer) rewriteDataFile(dataFile *data.DataFile, size int64, ranges []*QuarantinedRange) error {
+	fileName := data.GetDataFileName(r.db.DirPath, dataFile.FileID)
+	tempFileName := fileName + repairTempFileSuffix
+	// 清理上次执行中断时遗留的临时文件
+	if err := os.RemoveAll(tempFileName); err != nil {
+		return err
+	}
+	tempFile, err := fio.NewIoManager(tempFileName, fio.StandardFIO, append(r.db.ioOptions(), fio.WithFrameFileName(filepath.Base(fileName)))...)
+	if err != nil {
+		return err
+	}
+	defer tempFile.Close()
+	buf := make([]byte, repairBufSize)
+	copyRange := func(start, end int64) error {
+		for start < end {
+			n := int64(len(buf))
+			if n > end-start {
+				n = end - start
+			}
+			if _, err := dataFile.IoManager.Read(buf[:n], start); err != nil {
+				return err
+			}
+			if _, err := tempFile.Write(buf[:n]); err != nil {
+				return err
+			}
+			start += n
+		}
+		return nil
+	}
+	var offset int64 = 0
+	for _, qr := range ranges {
+		if err := copyRange(offset, qr.Offset); err != nil {
+			return err
+		}
+		offset = qr.Offset + qr.Size
+	}
+	if err := copyRange(offset, size); err != nil {
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tempFileName, fileName)
+}
+
+// checkLostKeys 修复后重新打开db，key已被之后的写入覆盖的数据不算丢失
+func (r *repairer) checkLostKeys(db *DB) {
+	lostKeys := make(map[string]int)
+	for _, candidate := range r.candidates {
+		lost := candidate.lost
+		pos := db.index.Get(lost.Key)
+		if lost.Deleted && pos == nil {
+			continue
+		}
+		if pos != nil && (pos.Fid > lost.Fid || (pos.Fid == lost.Fid && pos.Offset >= candidate.after)) {
+			continue
+		}
+		// 同一个key只保留最后丢失的数据
+		if i, ok := lostKeys[string(lost.Key)]; ok {
+			r.report.LostKeys[i] = lost
+			continue
+		}
+		lostKeys[string(lost.Key)] = len(r.report.LostKeys)
+		r.report.LostKeys = append(r.report.LostKeys, lost)
+	}
+}
+
+// writeReport 将修复结果追加写入修复报告文件，没有任何修复时不写入
+func (r *repairer) writeReport() error {
+	if len(r.report.RepairedFiles) == 0 && len(r.report.RemovedFiles) == 0 {
+		return nil
+	}
+	reportFile, err := fio.NewIoManager(filepath.Join(r.db.DirPath, data.RepairReportFileName), fio.StandardFIO, r.db.ioOptions()...)
+	if err != nil {
+		return err
+	}
+	defer reportFile.Close()
+	content := fmt.Sprintf("repair at %s\n%s", time.Now().Format(time.RFC3339), r.report)
+	if _, err := reportFile.Write([]byte(content)); err != nil {
+		return err
+	}
+	return reportFile.Sync()
+}
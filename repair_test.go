@@ -0,0 +1,160 @@
+package bitcaskkv
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	pos := db.index.Get(utils.GetRandomKey(10))
+	_, err = Repair(dirPath, opts...)
+	require.Equal(t, ErrDatabaseIsUsing, err)
+	require.NoError(t, db.Close())
+
+	// 没有损坏的数据时不做任何修改
+	report, err := Repair(dirPath, opts...)
+	require.NoError(t, err)
+	require.Equal(t, &RepairReport{}, report)
+	_, err = os.Stat(filepath.Join(dirPath, data.RepairReportFileName))
+	require.True(t, os.IsNotExist(err))
+
+	// 修改已封存数据文件中一条数据的value，并删除索引快照及hint文件，启动时需要读取该数据文件
+	fileName := data.GetDataFileName(dirPath, pos.Fid)
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	buf[pos.Offset+int64(pos.Size)-1]++
+	require.NoError(t, os.WriteFile(fileName, buf, fio.FilePerm))
+	require.NoError(t, os.Remove(filepath.Join(dirPath, data.IndexSnapshotFileName)))
+	require.NoError(t, os.Remove(data.GetDataHintFileName(dirPath, pos.Fid)))
+	_, err = Open(opts...)
+	require.ErrorIs(t, err, data.ErrorInvalidCRC)
+
+	report, err = Repair(dirPath, opts...)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{pos.Fid}, report.RepairedFiles)
+	require.Equal(t, []*QuarantinedRange{{Fid: pos.Fid, Offset: pos.Offset, Size: int64(pos.Size)}}, report.Quarantined)
+	require.Equal(t, []*LostKey{{Key: utils.GetRandomKey(10), Fid: pos.Fid, Offset: pos.Offset}}, report.LostKeys)
+	quarantined, err := os.ReadFile(data.GetQuarantineFileName(dirPath, pos.Fid))
+	require.NoError(t, err)
+	require.Equal(t, buf[pos.Offset:pos.Offset+int64(pos.Size)], quarantined)
+	_, err = os.Stat(filepath.Join(dirPath, data.RepairReportFileName))
+	require.NoError(t, err)
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Get(utils.GetRandomKey(10))
+	require.Equal(t, ErrKeyIsNotFound, err)
+	for _, i := range []int{9, 11, 199} {
+		_, err = db.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+	}
+	verifyReport, err := db.Verify(context.Background())
+	require.NoError(t, err)
+	require.True(t, verifyReport.OK(), "%v", verifyReport.Problems)
+	require.Equal(t, 199, verifyReport.IndexEntries)
+}
+
+func TestRepairTxnFinLost(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024), WithDBEncryptionKey([]byte("0123456789abcdef"))}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Put([]byte("txn"), []byte("value")))
+	require.NoError(t, wb.Put([]byte("a"), []byte("value")))
+	require.NoError(t, wb.Commit())
+	pos := db.index.Get([]byte("a"))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	// key a之后被覆盖，事务中的数据丢失后依然可以读取到新的value
+	require.NoError(t, db.Put([]byte("a"), []byte("new-value")))
+	require.NoError(t, db.Close())
+
+	// 修改事务完成标志的crc，hint文件中依然记录了事务中的数据
+	fileName := data.GetDataFileName(dirPath, pos.Fid)
+	dataFile, err := data.OpenDataFile(dirPath, pos.Fid, fio.StandardFIO, encryptionIOOptions(db.EncryptionKey)...)
+	require.NoError(t, err)
+	finOffset := pos.Offset + int64(pos.Size)
+	_, finSize, _, err := dataFile.Get(finOffset)
+	require.NoError(t, err)
+	crc := make([]byte, 1)
+	_, err = dataFile.IoManager.Read(crc, finOffset)
+	require.NoError(t, err)
+	require.NoError(t, dataFile.Close())
+	require.NoError(t, rewriteEncryptedByte(fileName, finOffset, crc[0]+1, db.EncryptionKey))
+	_, err = os.Stat(data.GetDataHintFileName(dirPath, pos.Fid))
+	require.NoError(t, err)
+
+	report, err := Repair(dirPath, opts...)
+	require.NoError(t, err)
+	require.Equal(t, []*QuarantinedRange{{Fid: pos.Fid, Offset: finOffset, Size: finSize}}, report.Quarantined)
+	require.Len(t, report.LostKeys, 1)
+	require.Equal(t, []byte("txn"), report.LostKeys[0].Key)
+	require.True(t, report.LostKeys[0].FromHint)
+	require.Contains(t, report.RemovedFiles, data.IndexSnapshotFileName)
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Get([]byte("txn"))
+	require.Equal(t, ErrKeyIsNotFound, err)
+	value, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("new-value"), value)
+	// 重新打开时为修复后的数据文件生成了新的hint文件
+	_, err = os.Stat(data.GetDataHintFileName(dirPath, pos.Fid))
+	require.NoError(t, err)
+	verifyReport, err := db.Verify(context.Background())
+	require.NoError(t, err)
+	require.True(t, verifyReport.OK(), "%v", verifyReport.Problems)
+}
+
+// rewriteEncryptedByte 使用加密的IO修改文件中offset处的一个字节
+func rewriteEncryptedByte(fileName string, offset int64, b byte, key []byte) error {
+	src, err := fio.NewIoManager(fileName, fio.StandardFIO, encryptionIOOptions(key)...)
+	if err != nil {
+		return err
+	}
+	size, err := src.Size()
+	if err != nil {
+		_ = src.Close()
+		return err
+	}
+	buf := make([]byte, size)
+	if _, err := src.Read(buf, 0); err != nil {
+		_ = src.Close()
+		return err
+	}
+	if err := src.Close(); err != nil {
+		return err
+	}
+	buf[offset] = b
+	if err := os.Remove(fileName); err != nil {
+		return err
+	}
+	dst, err := fio.NewIoManager(fileName, fio.StandardFIO, encryptionIOOptions(key)...)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err := dst.Write(buf); err != nil {
+		return err
+	}
+	return dst.Sync()
+}
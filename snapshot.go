@@ -0,0 +1,152 @@
+package bitcaskkv
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/index"
+)
+
+// Snapshot db在某一时刻的只读视图，快照创建之后的写入对其不可见
+// 快照持有创建时所有数据文件的引用，在Release之前这些文件不会被关闭
+type Snapshot struct {
+	mu         *sync.RWMutex
+	db         *DB
+	index      index.Index
+	activeFile *data.DataFile
+	olderFiles map[uint32]*data.DataFile
+	blobFiles  map[uint32]*data.DataFile
+	released   bool
+}
+
+// Snapshot 创建db当前时刻的快照，使用完成后需要调用Release释放
+func (db *DB) Snapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snap := db.pinDataFiles()
+	snap.index = db.index.Snapshot()
+	return snap
+}
+
+// pinDataFiles 持有当前所有数据文件的引用，在返回的快照释放前，merge不会关闭这些文件，调用方需持有db.mu
+func (db *DB) pinDataFiles() *Snapshot {
+	olderFiles := make(map[uint32]*data.DataFile, len(db.olderFiles))
+	for fid, dataFile := range db.olderFiles {
+		olderFiles[fid] = dataFile
+	}
+	blobFiles := make(map[uint32]*data.DataFile, len(db.blobFiles))
+	for fid, blobFile := range db.blobFiles {
+		blobFiles[fid] = blobFile
+	}
+	db.snapshotNum++
+	return &Snapshot{
+		mu:         new(sync.RWMutex),
+		db:         db,
+		activeFile: db.activeFile,
+		olderFiles: olderFiles,
+		blobFiles:  blobFiles,
+	}
+}
+
+// Get 读取快照创建时key对应的value
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos.ExpireAt, time.Now().UnixNano()) {
+		return nil, ErrKeyIsNotFound
+	}
+	return s.getLogRecordValue(logRecordPos)
+}
+
+// NewIterator 创建遍历快照数据的迭代器，快照释放后创建的迭代器不包含任何数据
+func (s *Snapshot) NewIterator(opts ...IterOption) *Iterator {
+	iterator := &Iterator{
+		Options: IterOptions{
+			Prefix:  []byte(""),
+			Reverse: false,
+		},
+		db:   s.db,
+		snap: s,
+	}
+	for _, opt := range opts {
+		opt(&iterator.Options)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// 快照释放后不再持有数据文件，返回的迭代器从一开始即无效
+	if s.released {
+		iterator.indexIter = index.NewBTree().Iterator(iterator.Options.Reverse)
+		return iterator
+	}
+	iterator.indexIter = s.index.Iterator(iterator.Options.Reverse)
+	return iterator
+}
+
+// Fold 遍历快照中的所有数据
+func (s *Snapshot) Fold(ff FoldFunc) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return ErrSnapshotReleased
+	}
+	iterator := s.index.Iterator(false)
+	defer iterator.Close()
+	now := time.Now().UnixNano()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if isExpired(iterator.Value().ExpireAt, now) {
+			continue
+		}
+		value, err := s.getLogRecordValue(iterator.Value())
+		if err != nil {
+			if err == ErrKeyIsNotFound {
+				continue
+			}
+			return err
+		}
+		if !ff(iterator.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Release 释放快照，释放后快照不可再使用
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.index = nil
+	s.olderFiles = nil
+	s.blobFiles = nil
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.snapshotNum--
+	//所有快照释放后，merge替换下来的旧数据文件不会再被读取，可以关闭
+	if s.db.snapshotNum == 0 {
+		_ = s.db.closeObsoleteFiles()
+	}
+}
+
+func (s *Snapshot) getLogRecordValue(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	var dataFile *data.DataFile
+	if s.activeFile != nil && logRecordPos.Fid == s.activeFile.FileID {
+		dataFile = s.activeFile
+	} else {
+		dataFile = s.olderFiles[logRecordPos.Fid]
+	}
+	return readLogRecordValue(dataFile, s.blobFiles, logRecordPos)
+}
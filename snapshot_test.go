@@ -0,0 +1,107 @@
+package bitcaskkv
+
+import (
+	"testing"
+
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	db, err := Open(WithDBDirPath(t.TempDir()), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	defer db.Close()
+
+	values := make([][]byte, 100)
+	for i := 0; i < 100; i++ {
+		values[i] = utils.GetRandomValue(64)
+		require.NoError(t, db.Put(utils.GetRandomKey(i), values[i]))
+	}
+	snap := db.Snapshot()
+
+	// 快照创建之后的写入、删除对快照不可见
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), []byte("new value")))
+	}
+	for i := 50; i < 60; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	require.NoError(t, db.Put(utils.GetRandomKey(100), []byte("new key")))
+
+	for i := 0; i < 100; i++ {
+		val, err := snap.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+		require.Equal(t, values[i], val)
+	}
+	_, err = snap.Get(utils.GetRandomKey(100))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+
+	iter := snap.NewIterator()
+	var idx int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		require.Equal(t, utils.GetRandomKey(idx), iter.Key())
+		val, err := iter.Value()
+		require.NoError(t, err)
+		require.Equal(t, values[idx], val)
+		idx++
+	}
+	iter.Close()
+	require.Equal(t, 100, idx)
+
+	var foldNum int
+	err = snap.Fold(func(key []byte, value []byte) bool {
+		foldNum++
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 100, foldNum)
+
+	// db本身可以读取到最新的数据
+	val, err := db.Get(utils.GetRandomKey(0))
+	require.NoError(t, err)
+	require.Equal(t, []byte("new value"), val)
+	require.Equal(t, 1, db.snapshotNum)
+
+	snap.Release()
+	require.Equal(t, 0, db.snapshotNum)
+	_, err = snap.Get(utils.GetRandomKey(0))
+	require.ErrorIs(t, err, ErrSnapshotReleased)
+	iter = snap.NewIterator()
+	iter.Rewind()
+	require.False(t, iter.Valid())
+	iter.Seek(utils.GetRandomKey(0))
+	require.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestSnapshotAfterDBClose(t *testing.T) {
+	db, err := Open(WithDBDirPath(t.TempDir()), WithDBMaxDataFileSize(4*1024), WithDBBlobThreshold(128))
+	require.NoError(t, err)
+	values := make([][]byte, 100)
+	for i := 0; i < 100; i++ {
+		values[i] = utils.GetRandomValue(64 + i%2*256)
+		require.NoError(t, db.Put(utils.GetRandomKey(i), values[i]))
+	}
+	snap := db.Snapshot()
+	require.NoError(t, db.Close())
+
+	// db关闭后，快照释放前仍可以读取数据
+	for i := 0; i < 100; i++ {
+		val, err := snap.Get(utils.GetRandomKey(i))
+		require.NoError(t, err)
+		require.Equal(t, values[i], val)
+	}
+	iter := snap.NewIterator()
+	var idx int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		require.NoError(t, err)
+		require.Equal(t, values[idx], val)
+		idx++
+	}
+	iter.Close()
+	require.Equal(t, 100, idx)
+
+	snap.Release()
+	require.Empty(t, db.obsoleteFiles)
+}
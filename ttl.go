@@ -0,0 +1,98 @@
+package bitcaskkv
+
+import (
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+)
+
+// NoExpireTTL 表示key未设置过期时间
+const NoExpireTTL time.Duration = -1
+
+// PutWithTTL 写入key - value，并在ttl时间后过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// Expire 为已存在的key设置过期时间
+func (db *DB) Expire(key []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.resetExpire(key, time.Now().Add(ttl).UnixNano())
+}
+
+// Persist 移除key的过期时间，使其永不过期
+func (db *DB) Persist(key []byte) error {
+	return db.resetExpire(key, 0)
+}
+
+// TTL 获取key的剩余存活时间，若key未设置过期时间，返回NoExpireTTL
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now().UnixNano()
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos.ExpireAt, now) {
+		return 0, ErrKeyIsNotFound
+	}
+	if logRecordPos.ExpireAt == 0 {
+		return NoExpireTTL, nil
+	}
+	return time.Duration(logRecordPos.ExpireAt - now), nil
+}
+
+// resetExpire 以新的过期时间重新写入key当前的value
+func (db *DB) resetExpire(key []byte, expireAt int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.ReadOnly {
+		return ErrReadOnly
+	}
+	//读取旧值和写入新记录需要在同一把锁内完成，避免覆盖并发写入的数据
+	return db.write(db.SyncWrites, func() error {
+		logRecordPos := db.getIndexPos(key)
+		if logRecordPos == nil || isExpired(logRecordPos.ExpireAt, time.Now().UnixNano()) {
+			return ErrKeyIsNotFound
+		}
+		if logRecordPos.ExpireAt == expireAt {
+			return nil
+		}
+		value, err := db.getLogRecordValue(logRecordPos)
+		if err != nil {
+			return err
+		}
+		pos, err := db.appendLogRecord(&data.LogRecord{
+			Key:         logRecordKeyWithSeq(key, nonTransactionSeqNo),
+			Value:       value,
+			Type:        data.LogRecordNormal,
+			ExpireAt:    expireAt,
+			Compression: db.Compression,
+		})
+		if err != nil {
+			return err
+		}
+		oldPositions, err := db.applyToIndex([][]byte{key}, []*data.LogRecordPos{pos}, pos)
+		if err != nil {
+			return err
+		}
+		if oldPositions[0] != nil {
+			db.addReclaimSize(oldPositions[0])
+		}
+		db.oracle.track(key)
+		return nil
+	})
+}
+
+// isExpired 判断过期时间在now时刻是否已经到达，expireAt为0表示永不过期
+func isExpired(expireAt int64, now int64) bool {
+	return expireAt > 0 && expireAt <= now
+}
@@ -0,0 +1,163 @@
+package bitcaskkv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutWithTTL(t *testing.T) {
+	db, err := Open(WithDBDirPath(t.TempDir()))
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.PutWithTTL(utils.GetRandomKey(1), utils.GetRandomValue(10), 0)
+	require.ErrorIs(t, err, ErrInvalidTTL)
+
+	require.NoError(t, db.PutWithTTL(utils.GetRandomKey(1), utils.GetRandomValue(10), 100*time.Millisecond))
+	require.NoError(t, db.Put(utils.GetRandomKey(2), utils.GetRandomValue(10)))
+
+	val, err := db.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.Len(t, val, 10)
+	ttl, err := db.TTL(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.True(t, ttl > 0 && ttl <= 100*time.Millisecond)
+	ttl, err = db.TTL(utils.GetRandomKey(2))
+	require.NoError(t, err)
+	require.Equal(t, NoExpireTTL, ttl)
+
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetRandomKey(1))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	_, err = db.TTL(utils.GetRandomKey(1))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	require.Len(t, db.ListKeys(false), 1)
+
+	iter := db.NewIterator()
+	defer iter.Close()
+	var keys [][]byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	require.Equal(t, [][]byte{utils.GetRandomKey(2)}, keys)
+
+	var foldKeys int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		foldKeys++
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, foldKeys)
+}
+
+func TestExpireAndPersist(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+
+	err = db.Expire(utils.GetRandomKey(1), time.Second)
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+
+	value := utils.GetRandomValue(10)
+	require.NoError(t, db.Put(utils.GetRandomKey(1), value))
+	require.NoError(t, db.Expire(utils.GetRandomKey(1), time.Hour))
+	ttl, err := db.TTL(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.True(t, ttl > 59*time.Minute)
+
+	require.NoError(t, db.Persist(utils.GetRandomKey(1)))
+	ttl, err = db.TTL(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.Equal(t, NoExpireTTL, ttl)
+
+	require.NoError(t, db.Expire(utils.GetRandomKey(1), 50*time.Millisecond))
+	require.NoError(t, db.PutWithTTL(utils.GetRandomKey(2), value, time.Hour))
+	require.NoError(t, db.Close())
+
+	// 重启后过期时间依然有效
+	time.Sleep(100 * time.Millisecond)
+	db, err = Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Get(utils.GetRandomKey(1))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	require.Equal(t, 1, db.index.Size())
+	ttl, err = db.TTL(utils.GetRandomKey(2))
+	require.NoError(t, err)
+	require.True(t, ttl > 59*time.Minute)
+	val, err := db.Get(utils.GetRandomKey(2))
+	require.NoError(t, err)
+	require.Equal(t, value, val)
+}
+
+func TestWriteBatchPutWithTTL(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+
+	wb := db.NewWriteBatch()
+	require.ErrorIs(t, wb.PutWithTTL(utils.GetRandomKey(1), utils.GetRandomValue(10), 0), ErrInvalidTTL)
+	require.NoError(t, wb.PutWithTTL(utils.GetRandomKey(1), utils.GetRandomValue(10), 50*time.Millisecond))
+	require.NoError(t, wb.PutWithTTL(utils.GetRandomKey(2), utils.GetRandomValue(10), time.Hour))
+	require.NoError(t, wb.Commit())
+	ttl, err := db.TTL(utils.GetRandomKey(2))
+	require.NoError(t, err)
+	require.True(t, ttl > 59*time.Minute)
+
+	// 事务读取自身暂存的数据时同样判断是否过期
+	txn := db.NewTxn()
+	defer txn.Discard()
+	require.NoError(t, txn.PutWithTTL(utils.GetRandomKey(3), utils.GetRandomValue(10), 50*time.Millisecond))
+	_, err = txn.Get(utils.GetRandomKey(3))
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = txn.Get(utils.GetRandomKey(3))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	txn.Discard()
+	_, err = db.Get(utils.GetRandomKey(1))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	require.NoError(t, db.Close())
+
+	// 重启后事务中写入的过期时间依然有效
+	db, err = Open(WithDBDirPath(dirPath))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Get(utils.GetRandomKey(1))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	ttl, err = db.TTL(utils.GetRandomKey(2))
+	require.NoError(t, err)
+	require.True(t, ttl > 59*time.Minute)
+}
+
+func TestMergeDropExpiredKeys(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			err = db.PutWithTTL(utils.GetRandomKey(i), utils.GetRandomValue(64), 50*time.Millisecond)
+		} else {
+			err = db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64))
+		}
+		require.NoError(t, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	db, err = Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024))
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, 100, db.index.Size())
+	for i := 0; i < 200; i++ {
+		_, err := db.Get(utils.GetRandomKey(i))
+		if i%2 == 0 {
+			require.ErrorIs(t, err, ErrKeyIsNotFound)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package bitcaskkv
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+)
+
+// Txn 基于WriteBatch实现的乐观读写事务
+// 事务内的写入先暂存于WriteBatch中，读取时优先读取自身暂存的数据；
+// 提交时若事务读取过的key在事务开始后被修改过，则提交失败，返回ErrTxnConflict
+type Txn struct {
+	mu      *sync.Mutex
+	db      *DB
+	batch   *WriteBatch
+	readTs  uint64              //事务开始时的版本号
+	readSet map[string]struct{} //事务读取过的key
+	done    bool
+}
+
+// NewTxn 开始一个新的事务，事务结束时必须调用Commit或Discard，Commit之后调用Discard没有任何影响，
+// 通常在创建后立即defer Discard：
+//
+//	txn := db.NewTxn()
+//	defer txn.Discard()
+//
+// 未结束的事务会阻止清理其开始后提交的写入记录，记录数超过maxTxnCommittedWrites后，
+// 最早的记录被丢弃，依赖这些记录的事务提交时返回ErrTxnConflict
+func (db *DB) NewTxn(opts ...WriteBatchOption) *Txn {
+	txn := &Txn{
+		mu:      new(sync.Mutex),
+		db:      db,
+		batch:   db.NewWriteBatch(opts...),
+		readSet: make(map[string]struct{}),
+	}
+	db.mu.Lock()
+	txn.readTs = db.oracle.begin()
+	db.mu.Unlock()
+	return txn
+}
+
+// Get 读取key对应的value，优先读取事务内暂存的数据
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return nil, ErrTxnClosed
+	}
+
+	txn.batch.mu.RLock()
+	logRecord := txn.batch.pendingWrites[string(key)]
+	txn.batch.mu.RUnlock()
+	if logRecord != nil {
+		if logRecord.Type == data.LogRecordDeleted || isExpired(logRecord.ExpireAt, time.Now().UnixNano()) {
+			return nil, ErrKeyIsNotFound
+		}
+		return logRecord.Value, nil
+	}
+
+	//记录读取过的key，不论其是否存在
+	txn.readSet[string(key)] = struct{}{}
+	return txn.db.Get(key)
+}
+
+// Put 将key - value暂存至事务中
+func (txn *Txn) Put(key []byte, value []byte) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnClosed
+	}
+	return txn.batch.Put(key, value)
+}
+
+// PutWithTTL 将key - value暂存至事务中，提交后在ttl时间后过期
+func (txn *Txn) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnClosed
+	}
+	return txn.batch.PutWithTTL(key, value, ttl)
+}
+
+// Delete 将删除key的操作暂存至事务中
+func (txn *Txn) Delete(key []byte) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnClosed
+	}
+	return txn.batch.Delete(key)
+}
+
+// Commit 检测读取过的key是否被修改，无冲突时提交事务内的所有写入
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnClosed
+	}
+	txn.done = true
+
+	txn.batch.mu.Lock()
+	defer txn.batch.mu.Unlock()
+	if uint(len(txn.batch.pendingWrites)) > txn.batch.options.MaxBatchNum {
+		txn.discard()
+		return ErrExceedMaxBatchNum
+	}
+
+	//冲突检测与写入需要在同一把锁内完成
+	return txn.db.write(txn.batch.needSync(), func() error {
+		defer txn.db.oracle.finish(txn.readTs)
+		if txn.db.oracle.hasConflict(txn.readTs, txn.readSet) {
+			return ErrTxnConflict
+		}
+		if len(txn.batch.pendingWrites) == 0 {
+			return nil
+		}
+		return txn.batch.commit()
+	})
+}
+
+// Discard 放弃事务内的所有写入
+func (txn *Txn) Discard() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return
+	}
+	txn.done = true
+	txn.discard()
+}
+
+func (txn *Txn) discard() {
+	txn.db.mu.Lock()
+	txn.db.oracle.finish(txn.readTs)
+	txn.db.mu.Unlock()
+}
+
+// 最多保留的写入记录数，避免未结束的事务使记录无限增长
+const maxTxnCommittedWrites = 100000
+
+// txnOracle 记录在活跃事务开始后提交的写入，用于事务提交时的冲突检测，所有方法均需在持有db.mu时调用
+type txnOracle struct {
+	//每次写入提交后递增的版本号
+	version uint64
+	//活跃事务开始时的版本号 -> 该版本号下的活跃事务数量
+	active map[uint64]int
+	//活跃事务开始后提交的写入
+	committed []committedWrite
+	//已丢弃的写入记录中最大的版本号，在此之前开始的事务无法进行冲突检测
+	dropped uint64
+}
+
+type committedWrite struct {
+	version uint64
+	keys    []string
+}
+
+func newTxnOracle() *txnOracle {
+	return &txnOracle{
+		active: make(map[uint64]int),
+	}
+}
+
+// begin 注册一个新的活跃事务，返回其开始时的版本号
+func (o *txnOracle) begin() uint64 {
+	o.active[o.version]++
+	return o.version
+}
+
+// track 记录一次写入提交，没有活跃事务时无需记录
+func (o *txnOracle) track(keys ...[]byte) {
+	o.version++
+	if len(o.active) == 0 {
+		return
+	}
+	write := committedWrite{
+		version: o.version,
+		keys:    make([]string, len(keys)),
+	}
+	for i, key := range keys {
+		write.keys[i] = string(key)
+	}
+	o.committed = append(o.committed, write)
+	if n := len(o.committed) - maxTxnCommittedWrites; n > 0 {
+		o.dropped = o.committed[n-1].version
+		o.committed = o.committed[n:]
+	}
+}
+
+// hasConflict 判断readSet中的key是否在readTs之后被修改过
+func (o *txnOracle) hasConflict(readTs uint64, readSet map[string]struct{}) bool {
+	//事务开始后的部分写入记录已被丢弃，无法确认读取过的key是否被修改
+	if readTs < o.dropped {
+		return len(readSet) > 0
+	}
+	for _, write := range o.committed {
+		if write.version <= readTs {
+			continue
+		}
+		for _, key := range write.keys {
+			if _, ok := readSet[key]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// finish 注销活跃事务，并清理不再被任何活跃事务需要的写入记录
+func (o *txnOracle) finish(readTs uint64) {
+	if o.active[readTs]--; o.active[readTs] <= 0 {
+		delete(o.active, readTs)
+	}
+	if len(o.active) == 0 {
+		o.committed = nil
+		return
+	}
+	var minReadTs uint64 = o.version
+	for ts := range o.active {
+		if ts < minReadTs {
+			minReadTs = ts
+		}
+	}
+	var idx int
+	for idx < len(o.committed) && o.committed[idx].version <= minReadTs {
+		idx++
+	}
+	o.committed = o.committed[idx:]
+}
@@ -0,0 +1,181 @@
+package bitcaskkv
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnReadOwnWrites(t *testing.T) {
+	db, err := Open(WithDBDirPath(t.TempDir()))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Put(utils.GetRandomKey(1), []byte("1")))
+
+	txn := db.NewTxn()
+	defer txn.Discard()
+	val, err := txn.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), val)
+
+	require.NoError(t, txn.Put(utils.GetRandomKey(2), []byte("2")))
+	require.NoError(t, txn.Delete(utils.GetRandomKey(1)))
+	val, err = txn.Get(utils.GetRandomKey(2))
+	require.NoError(t, err)
+	require.Equal(t, []byte("2"), val)
+	_, err = txn.Get(utils.GetRandomKey(1))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+
+	// 提交前其他读取者看不到事务内的写入
+	_, err = db.Get(utils.GetRandomKey(2))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+
+	require.NoError(t, txn.Commit())
+	val, err = db.Get(utils.GetRandomKey(2))
+	require.NoError(t, err)
+	require.Equal(t, []byte("2"), val)
+	_, err = db.Get(utils.GetRandomKey(1))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+
+	require.ErrorIs(t, txn.Commit(), ErrTxnClosed)
+	require.ErrorIs(t, txn.Put(utils.GetRandomKey(3), []byte("3")), ErrTxnClosed)
+}
+
+func TestTxnConflict(t *testing.T) {
+	db, err := Open(WithDBDirPath(t.TempDir()))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Put(utils.GetRandomKey(1), []byte("1")))
+
+	// 读取过的key被修改，提交失败
+	txn := db.NewTxn()
+	defer txn.Discard()
+	_, err = txn.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.NoError(t, txn.Put(utils.GetRandomKey(2), []byte("2")))
+	require.NoError(t, db.Put(utils.GetRandomKey(1), []byte("11")))
+	require.ErrorIs(t, txn.Commit(), ErrTxnConflict)
+	_, err = db.Get(utils.GetRandomKey(2))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+
+	// 读取时不存在的key在事务开始后被写入，同样视为冲突
+	txn = db.NewTxn()
+	defer txn.Discard()
+	require.NoError(t, db.Put(utils.GetRandomKey(3), []byte("3")))
+	_, err = txn.Get(utils.GetRandomKey(3))
+	require.NoError(t, err)
+	require.NoError(t, txn.Put(utils.GetRandomKey(4), []byte("4")))
+	require.ErrorIs(t, txn.Commit(), ErrTxnConflict)
+
+	// 仅修改了未读取过的key，提交成功
+	txn = db.NewTxn()
+	defer txn.Discard()
+	_, err = txn.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.NoError(t, txn.Put(utils.GetRandomKey(1), []byte("111")))
+	require.NoError(t, db.Put(utils.GetRandomKey(5), []byte("5")))
+	require.NoError(t, txn.Commit())
+	val, err := db.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("111"), val)
+
+	// 放弃的事务不会写入任何数据
+	txn = db.NewTxn()
+	defer txn.Discard()
+	require.NoError(t, txn.Put(utils.GetRandomKey(6), []byte("6")))
+	txn.Discard()
+	_, err = db.Get(utils.GetRandomKey(6))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	require.Empty(t, db.oracle.active)
+	require.Empty(t, db.oracle.committed)
+}
+
+func TestTxnLeakedWithoutDiscard(t *testing.T) {
+	db, err := Open(WithDBDirPath(t.TempDir()))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// 未调用Commit或Discard的事务阻止清理写入记录，但记录数不会超过上限
+	leaked := db.NewTxn()
+	_, err = leaked.Get(utils.GetRandomKey(0))
+	require.ErrorIs(t, err, ErrKeyIsNotFound)
+	for i := 0; i < maxTxnCommittedWrites+10; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i%100), []byte("value")))
+	}
+	db.mu.Lock()
+	require.Len(t, db.oracle.committed, maxTxnCommittedWrites)
+	db.mu.Unlock()
+
+	// 之后开始的事务不受影响，依赖已丢弃记录的事务提交失败
+	txn := db.NewTxn()
+	defer txn.Discard()
+	_, err = txn.Get(utils.GetRandomKey(1))
+	require.NoError(t, err)
+	require.NoError(t, txn.Put(utils.GetRandomKey(1), []byte("new-value")))
+	require.NoError(t, txn.Commit())
+	require.NoError(t, leaked.Put(utils.GetRandomKey(2), []byte("new-value")))
+	require.ErrorIs(t, leaked.Commit(), ErrTxnConflict)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	require.Empty(t, db.oracle.active)
+	require.Empty(t, db.oracle.committed)
+}
+
+func TestTxnConcurrentTransfer(t *testing.T) {
+	db, err := Open(WithDBDirPath(t.TempDir()))
+	require.NoError(t, err)
+	defer db.Close()
+	from, to := []byte("account-a"), []byte("account-b")
+	require.NoError(t, db.Put(from, []byte("1000")))
+	require.NoError(t, db.Put(to, []byte("0")))
+
+	transfer := func() error {
+		txn := db.NewTxn()
+		defer txn.Discard()
+		fromVal, err := txn.Get(from)
+		if err != nil {
+			return err
+		}
+		toVal, err := txn.Get(to)
+		if err != nil {
+			return err
+		}
+		fromBalance, _ := strconv.Atoi(string(fromVal))
+		toBalance, _ := strconv.Atoi(string(toVal))
+		if err := txn.Put(from, []byte(strconv.Itoa(fromBalance-1))); err != nil {
+			return err
+		}
+		if err := txn.Put(to, []byte(strconv.Itoa(toBalance+1))); err != nil {
+			return err
+		}
+		return txn.Commit()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; {
+				err := transfer()
+				if err == ErrTxnConflict {
+					continue
+				}
+				require.NoError(t, err)
+				n++
+			}
+		}()
+	}
+	wg.Wait()
+
+	fromVal, err := db.Get(from)
+	require.NoError(t, err)
+	toVal, err := db.Get(to)
+	require.NoError(t, err)
+	require.Equal(t, "800", string(fromVal))
+	require.Equal(t, "200", string(toVal))
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DirSize 获取目录下所有文件的总大小
+func DirSize(dirPath string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dirPath, func(_ string, info fs.FileInfo, err error) error {
+		if err != nil {
+			// 遍历期间被删除或重命名的文件(如后台写入的临时文件)直接忽略
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// SyncDir 持久化目录本身，保证目录内新建、重命名或删除的文件在宕机后依然可见
+func SyncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// AvailableDiskSize 获取目录所在磁盘的剩余可用空间大小
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dirPath, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSize(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-kv-dirsize")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	size, err := DirSize(dir)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), size)
+
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "a"), GetRandomValue(100), 0644))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "sub"), os.ModePerm))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "sub", "b"), GetRandomValue(28), 0644))
+
+	size, err = DirSize(dir)
+	require.Nil(t, err)
+	require.Equal(t, int64(128), size)
+}
+
+func TestAvailableDiskSize(t *testing.T) {
+	size, err := AvailableDiskSize(os.TempDir())
+	require.Nil(t, err)
+	require.Greater(t, size, uint64(0))
+
+	_, err = AvailableDiskSize(filepath.Join(os.TempDir(), "bitcask-kv-not-exist"))
+	require.NotNil(t, err)
+}
+
+func TestSyncDir(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "a"), GetRandomValue(100), 0644))
+	require.Nil(t, SyncDir(dir))
+	require.NotNil(t, SyncDir(filepath.Join(dir, "not-exist")))
+}
@@ -0,0 +1,495 @@
+package bitcaskkv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+)
+
+// VerifyProblem 校验发现的一处问题
+type VerifyProblem struct {
+	FileName string // 问题所在的文件
+	Offset   int64  // 问题数据在文件中的位置，为-1时表示整个文件
+	Key      []byte // 问题数据对应的key，数据无法解码时为nil
+	Err      error
+}
+
+func (p *VerifyProblem) String() string {
+	if p.Key == nil {
+		return fmt.Sprintf("%s offset=%d: %v", p.FileName, p.Offset, p.Err)
+	}
+	return fmt.Sprintf("%s offset=%d key=%q: %v", p.FileName, p.Offset, p.Key, p.Err)
+}
+
+// VerifyReport 校验的结果
+type VerifyReport struct {
+	Files        int // 校验的文件数量
+	Records      int // 校验的记录数量
+	IndexEntries int // 校验的索引数量
+	Problems     []*VerifyProblem
+}
+
+// OK 是否没有发现任何问题
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// verifier 一次校验过程中的状态
+type verifier struct {
+	ctx    context.Context
+	db     *DB
+	snap   *Snapshot
+	report *VerifyReport
+	// 活跃文件中参与校验的数据的结束位置，之后写入的数据不做校验
+	activeEnd int64
+	// 读取到事务完成标志的事务序列号
+	finishedTxns map[uint64]struct{}
+	// 校验数据文件时发现损坏的记录，以及因header损坏而无法继续读取的位置
+	corruptedRecords map[recordLocation]struct{}
+	unreadableFrom   map[uint32]int64
+}
+
+type recordLocation struct {
+	fid    uint32
+	offset int64
+}
+
+// Verify 校验数据目录中所有文件的完整性，用于在读取之前尽早发现磁盘上损坏的数据：
+// 校验数据文件、hint文件、merge完成标志文件及seqNo文件中每条记录的crc和header，
+// 校验每个索引都指向一条未被删除且key相同的数据，并且通过事务写入的数据都存在事务完成标志
+// 校验基于调用时刻的数据文件和索引，期间db可以正常读写，但不能进行merge
+func (db *DB) Verify(ctx context.Context) (*VerifyReport, error) {
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return nil, ErrMErgeIsProgress
+	}
+	// merge会替换数据文件及hint文件，校验期间不允许进行merge
+	db.isMerging = true
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+	snap := db.pinDataFiles()
+	snap.index = db.index.Snapshot()
+	v := &verifier{
+		ctx:              ctx,
+		db:               db,
+		snap:             snap,
+		report:           &VerifyReport{},
+		finishedTxns:     make(map[uint64]struct{}),
+		corruptedRecords: make(map[recordLocation]struct{}),
+		unreadableFrom:   make(map[uint32]int64),
+	}
+	if db.activeFile != nil {
+		v.activeEnd = db.activeFile.WriteOff
+	}
+	db.mu.Unlock()
+	defer snap.Release()
+
+	if err := v.verifyDataFiles(); err != nil {
+		return nil, err
+	}
+	if err := v.verifyMergeFiles(); err != nil {
+		return nil, err
+	}
+	if err := v.verifyIndex(); err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+func (v *verifier) addProblem(fileName string, offset int64, key []byte, err error) {
+	v.report.Problems = append(v.report.Problems, &VerifyProblem{
+		FileName: filepath.Base(fileName),
+		Offset:   offset,
+		Key:      key,
+		Err:      err,
+	})
+}
+
+// dataFile 返回快照中的数据文件，不存在时返回nil
+func (v *verifier) dataFile(fid uint32) *data.DataFile {
+	if v.snap.activeFile != nil && fid == v.snap.activeFile.FileID {
+		return v.snap.activeFile
+	}
+	return v.snap.olderFiles[fid]
+}
+
+// verifyDataFiles 按文件id依次校验所有数据文件及已封存数据文件的hint文件
+func (v *verifier) verifyDataFiles() error {
+	var fids []uint32
+	for fid := range v.snap.olderFiles {
+		fids = append(fids, fid)
+	}
+	if v.snap.activeFile != nil {
+		fids = append(fids, v.snap.activeFile.FileID)
+	}
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+
+	for _, fid := range fids {
+		dataFile := v.dataFile(fid)
+		end := v.activeEnd
+		if dataFile != v.snap.activeFile {
+			size, err := dataFile.IoManager.Size()
+			if err != nil {
+				return err
+			}
+			end = size
+		}
+		entries, ok, err := v.verifyDataFile(dataFile, end)
+		if err != nil {
+			return err
+		}
+		// 数据文件本身损坏时hint文件无法与其对比，问题已经记录
+		if ok && dataFile != v.snap.activeFile {
+			if err := v.verifyDataHint(dataFile, entries); err != nil {
+				return err
+			}
+		}
+	}
+	return v.verifyOrphanHints()
+}
+
+// verifyDataFile 校验数据文件中[0, end)范围内的所有记录，返回其中的每条记录，以及是否所有记录都完好
+func (v *verifier) verifyDataFile(dataFile *data.DataFile, end int64) ([]*hintEntry, bool, error) {
+	v.report.Files++
+	fileName := data.GetDataFileName(v.db.DirPath, dataFile.FileID)
+	var entries []*hintEntry
+	var offset int64 = 0
+	ok := true
+	for offset < end {
+		if err := v.ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		encLogRecord, size, logRecordHeader, err := dataFile.Get(offset)
+		if err == io.EOF {
+			// 文件末尾之前读取到空数据
+			err = ErrDataDirectoryCorrupted
+		}
+		if err != nil {
+			// header损坏时无法确定下一条记录的位置，不再继续读取该文件
+			v.addProblem(fileName, offset, nil, err)
+			v.unreadableFrom[dataFile.FileID] = offset
+			return nil, false, nil
+		}
+		v.report.Records++
+		logRecord, err := data.DecodeLogRecord(encLogRecord, logRecordHeader)
+		if err == nil && logRecord.Type > data.LogRecordBlob {
+			err = ErrUnknownRecordType
+		}
+		if err != nil {
+			// header完好，跳过该条记录继续校验之后的记录
+			v.addProblem(fileName, offset, nil, err)
+			v.corruptedRecords[recordLocation{fid: dataFile.FileID, offset: offset}] = struct{}{}
+			ok = false
+			offset += size
+			continue
+		}
+		if logRecord.Type == data.LogRecordTxnFinished {
+			_, seqNo := ParseLogRecordKey(logRecord.Key)
+			v.finishedTxns[seqNo] = struct{}{}
+		}
+		pos := newLogRecordPos(dataFile.FileID, offset, size, logRecord)
+		logRecord.Value = nil
+		entries = append(entries, &hintEntry{record: logRecord, pos: pos})
+		offset += size
+	}
+	return entries, ok, nil
+}
+
+// verifyDataHint 校验hint文件与数据文件中的记录一一对应
+func (v *verifier) verifyDataHint(dataFile *data.DataFile, entries []*hintEntry) error {
+	hintFileName := data.GetDataHintFileName(v.db.DirPath, dataFile.FileID)
+	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+		return nil
+	}
+	v.report.Files++
+	hintFile, err := data.OpenDataHintFile(v.db.DirPath, dataFile.FileID, v.db.loadIOType(), v.db.ioOptions()...)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	var i int
+	var offset int64 = 0
+	for {
+		if err := v.ctx.Err(); err != nil {
+			return err
+		}
+		encHintRecord, size, hintRecordHeader, err := hintFile.Get(offset)
+		if err == io.EOF {
+			if err := checkDataFileEnd(hintFile, offset); err != nil {
+				v.addProblem(hintFileName, offset, nil, err)
+				return nil
+			}
+			break
+		}
+		if err != nil {
+			v.addProblem(hintFileName, offset, nil, err)
+			return nil
+		}
+		v.report.Records++
+		hintRecord, err := data.DecodeLogRecord(encHintRecord, hintRecordHeader)
+		if err != nil {
+			v.addProblem(hintFileName, offset, nil, err)
+			return nil
+		}
+		realKey, _ := ParseLogRecordKey(hintRecord.Key)
+		if i >= len(entries) || !sameHintEntry(entries[i], hintRecord) {
+			v.addProblem(hintFileName, offset, realKey, ErrHintMismatch)
+			return nil
+		}
+		i++
+		offset += size
+	}
+	// hint文件需要覆盖数据文件中的所有记录
+	if i < len(entries) {
+		v.addProblem(hintFileName, offset, nil, ErrHintMismatch)
+	}
+	return nil
+}
+
+func sameHintEntry(entry *hintEntry, hintRecord *data.LogRecord) bool {
+	pos := data.DecCodeLogRecordPos(hintRecord.Value)
+	return bytes.Equal(entry.record.Key, hintRecord.Key) && entry.record.Type == hintRecord.Type && *entry.pos == *pos
+}
+
+// verifyOrphanHints 没有对应数据文件的hint文件
+func (v *verifier) verifyOrphanHints() error {
+	entries, err := os.ReadDir(v.db.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataHintFileSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataHintFileSuffix))
+		if err != nil || v.dataFile(uint32(fid)) == nil {
+			v.addProblem(entry.Name(), -1, nil, ErrHintMismatch)
+		}
+	}
+	return nil
+}
+
+// verifyMergeFiles 校验merge完成标志文件、merge生成的hint文件及seqNo文件
+func (v *verifier) verifyMergeFiles() error {
+	noMergeFileId, ok := v.verifyMergeFinishedFile()
+	if ok {
+		if err := v.verifyHintFile(noMergeFileId); err != nil {
+			return err
+		}
+	}
+	fileName := filepath.Join(v.db.DirPath, data.SeqNoFileName)
+	if _, err := os.Stat(fileName); err == nil {
+		v.readNumberRecords(fileName, data.OpenSeqNoFile, 1)
+	}
+	return nil
+}
+
+// verifyMergeFinishedFile 校验merge完成标志文件，返回其中记录的未参与merge的第一个文件id，以及hint文件是否需要校验
+func (v *verifier) verifyMergeFinishedFile() (uint32, bool) {
+	fileName := filepath.Join(v.db.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return 0, false
+	}
+	// 依次记录了未参与merge的第一个文件id及merge后的数据文件数量，旧版本只记录了前者
+	numbers, ok := v.readNumberRecords(fileName, data.OpenMergeFinishedFile, 2)
+	if !ok {
+		return 0, false
+	}
+	noMergeFileId := numbers[0]
+	// 活跃文件一定未参与merge
+	if (v.snap.activeFile != nil && int64(v.snap.activeFile.FileID) < noMergeFileId) ||
+		(len(numbers) == 2 && numbers[1] > noMergeFileId) {
+		v.addProblem(fileName, 0, nil, ErrDataDirectoryCorrupted)
+		return 0, false
+	}
+	return uint32(noMergeFileId), true
+}
+
+// readNumberRecords 读取文件中最多maxNum条value为数字的记录，存在问题时返回false
+func (v *verifier) readNumberRecords(fileName string,
+	open func(dirpath string, ioType fio.FileIOType, opts ...fio.IOOption) (*data.DataFile, error), maxNum int) ([]int64, bool) {
+	v.report.Files++
+	dataFile, err := open(v.db.DirPath, v.db.loadIOType(), v.db.ioOptions()...)
+	if err != nil {
+		v.addProblem(fileName, -1, nil, err)
+		return nil, false
+	}
+	defer dataFile.Close()
+
+	var numbers []int64
+	var offset int64 = 0
+	for len(numbers) < maxNum {
+		encLogRecord, size, logRecordHeader, err := dataFile.Get(offset)
+		if err == io.EOF && len(numbers) > 0 {
+			break
+		}
+		var logRecord *data.LogRecord
+		if err == nil {
+			v.report.Records++
+			logRecord, err = data.DecodeLogRecord(encLogRecord, logRecordHeader)
+		}
+		var number int64
+		if err == nil {
+			if number, err = strconv.ParseInt(string(logRecord.Value), 10, 64); err != nil {
+				err = ErrDataDirectoryCorrupted
+			}
+		}
+		if err != nil {
+			v.addProblem(fileName, offset, nil, err)
+			return nil, false
+		}
+		numbers = append(numbers, number)
+		offset += size
+	}
+	return numbers, true
+}
+
+// verifyHintFile 校验merge生成的hint文件，其中的每条索引都应指向merge后数据文件中的一条数据
+func (v *verifier) verifyHintFile(noMergeFileId uint32) error {
+	fileName := filepath.Join(v.db.DirPath, data.HintFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	v.report.Files++
+	hintFile, err := data.OpenHintFile(v.db.DirPath, v.db.loadIOType(), v.db.ioOptions()...)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+
+	var offset int64 = 0
+	for {
+		if err := v.ctx.Err(); err != nil {
+			return err
+		}
+		encPosRecord, size, posRecordHeader, err := hintFile.Get(offset)
+		if err == io.EOF {
+			if err := checkDataFileEnd(hintFile, offset); err != nil {
+				v.addProblem(fileName, offset, nil, err)
+			}
+			return nil
+		}
+		if err != nil {
+			v.addProblem(fileName, offset, nil, err)
+			return nil
+		}
+		v.report.Records++
+		posRecord, err := data.DecodeLogRecord(encPosRecord, posRecordHeader)
+		if err != nil {
+			v.addProblem(fileName, offset, nil, err)
+			return nil
+		}
+		if !v.matchHintRecord(posRecord.Key, data.DecCodeLogRecordPos(posRecord.Value), noMergeFileId) {
+			v.addProblem(fileName, offset, posRecord.Key, ErrHintMismatch)
+		}
+		offset += size
+	}
+}
+
+// matchHintRecord 判断merge生成的hint文件中的索引是否指向merge后数据文件中key相同的数据
+// 数据本身损坏时已在校验数据文件时记录，不再重复记录
+func (v *verifier) matchHintRecord(key []byte, pos *data.LogRecordPos, noMergeFileId uint32) bool {
+	dataFile := v.dataFile(pos.Fid)
+	if pos.Fid >= noMergeFileId || dataFile == nil {
+		return false
+	}
+	encLogRecord, _, logRecordHeader, err := dataFile.Get(pos.Offset)
+	var logRecord *data.LogRecord
+	if err == nil {
+		logRecord, err = data.DecodeLogRecordWithoutValue(encLogRecord, logRecordHeader)
+	}
+	if err != nil {
+		if from, ok := v.unreadableFrom[pos.Fid]; ok && pos.Offset >= from {
+			return true
+		}
+		_, ok := v.corruptedRecords[recordLocation{fid: pos.Fid, offset: pos.Offset}]
+		return ok
+	}
+	realKey, _ := ParseLogRecordKey(logRecord.Key)
+	return logRecord.Type != data.LogRecordDeleted && bytes.Equal(realKey, key)
+}
+
+// verifyIndex 校验内存索引中的每一条索引
+func (v *verifier) verifyIndex() error {
+	iterator := v.snap.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err := v.ctx.Err(); err != nil {
+			return err
+		}
+		v.report.IndexEntries++
+		v.verifyIndexEntry(iterator.Key(), iterator.Value())
+	}
+	return nil
+}
+
+// verifyIndexEntry 校验pos指向一条key相同且未被删除的数据，value存储在blob文件中时同时校验blob记录
+func (v *verifier) verifyIndexEntry(key []byte, pos *data.LogRecordPos) {
+	fileName := data.GetDataFileName(v.db.DirPath, pos.Fid)
+	dataFile := v.dataFile(pos.Fid)
+	if dataFile == nil {
+		v.addProblem(fileName, pos.Offset, key, ErrDataFileNotFound)
+		return
+	}
+	encLogRecord, size, logRecordHeader, err := dataFile.Get(pos.Offset)
+	var logRecord *data.LogRecord
+	if err == nil {
+		logRecord, err = data.DecodeLogRecord(encLogRecord, logRecordHeader)
+	}
+	if err != nil {
+		v.addProblem(fileName, pos.Offset, key, err)
+		return
+	}
+	realKey, seqNo := ParseLogRecordKey(logRecord.Key)
+	if logRecord.Type == data.LogRecordDeleted || logRecord.Type == data.LogRecordTxnFinished ||
+		!bytes.Equal(realKey, key) || (pos.Size > 0 && int64(pos.Size) != size) {
+		v.addProblem(fileName, pos.Offset, key, ErrIndexMismatch)
+		return
+	}
+	// 事务中的数据只有在读取到事务完成标志后才会加载到索引中
+	if seqNo != nonTransactionSeqNo {
+		if _, ok := v.finishedTxns[seqNo]; !ok {
+			v.addProblem(fileName, pos.Offset, key, ErrTxnFinMissing)
+		}
+	}
+	if logRecord.Type == data.LogRecordBlob {
+		v.verifyBlobRecord(key, data.DecodeBlobPointer(logRecord.Value))
+	}
+}
+
+// verifyBlobRecord 校验blob文件中的value
+// blob文件中可能残留写入不完整的记录，只能按照索引中的位置读取，无法顺序校验整个文件
+func (v *verifier) verifyBlobRecord(key []byte, ptr *data.BlobPointer) {
+	fileName := data.GetBlobFileName(v.db.DirPath, ptr.Fid)
+	blobFile := v.snap.blobFiles[ptr.Fid]
+	if blobFile == nil {
+		v.addProblem(fileName, ptr.Offset, key, ErrDataFileNotFound)
+		return
+	}
+	encBlobRecord, size, blobRecordHeader, err := blobFile.Get(ptr.Offset)
+	var blobRecord *data.LogRecord
+	if err == nil {
+		blobRecord, err = data.DecodeLogRecord(encBlobRecord, blobRecordHeader)
+	}
+	if err != nil {
+		v.addProblem(fileName, ptr.Offset, key, err)
+		return
+	}
+	if realKey, _ := ParseLogRecordKey(blobRecord.Key); !bytes.Equal(realKey, key) || int64(ptr.Size) != size {
+		v.addProblem(fileName, ptr.Offset, key, ErrIndexMismatch)
+	}
+}
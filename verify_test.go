@@ -0,0 +1,116 @@
+package bitcaskkv
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GGjahon/bitcask-kv/data"
+	"github.com/GGjahon/bitcask-kv/fio"
+	"github.com/GGjahon/bitcask-kv/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	dirPath := t.TempDir()
+	db, err := Open(WithDBDirPath(dirPath), WithDBMaxDataFileSize(4*1024), WithDBBlobThreshold(512))
+	require.NoError(t, err)
+	defer db.Close()
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Delete(utils.GetRandomKey(i)))
+	}
+	require.NoError(t, db.Merge())
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Put([]byte("txn"), []byte("value")))
+	require.NoError(t, wb.Delete(utils.GetRandomKey(100)))
+	require.NoError(t, wb.Commit())
+	require.NoError(t, db.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour))
+	require.NoError(t, db.Put([]byte("blob"), utils.GetRandomValue(1024)))
+
+	report, err := db.Verify(context.Background())
+	require.NoError(t, err)
+	require.True(t, report.OK(), "%v", report.Problems)
+	require.Equal(t, db.index.Size(), report.IndexEntries)
+	require.Greater(t, report.Files, len(db.olderFiles))
+	require.Greater(t, report.Records, 200)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.Verify(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestVerifyCorruptedRecord(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	pos := db.index.Get(utils.GetRandomKey(10))
+	require.NoError(t, db.Close())
+
+	// 修改已封存数据文件中一条数据的value，索引从索引快照中加载，启动时不会发现
+	fileName := data.GetDataFileName(dirPath, pos.Fid)
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	buf[pos.Offset+int64(pos.Size)-1]++
+	require.NoError(t, os.WriteFile(fileName, buf, fio.FilePerm))
+
+	db, err = Open(append(opts, WithReadOnly())...)
+	require.NoError(t, err)
+	defer db.Close()
+	report, err := db.Verify(context.Background())
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 2)
+	require.Equal(t, &VerifyProblem{
+		FileName: filepath.Base(fileName),
+		Offset:   pos.Offset,
+		Err:      data.ErrorInvalidCRC,
+	}, report.Problems[0])
+	require.Equal(t, &VerifyProblem{
+		FileName: filepath.Base(fileName),
+		Offset:   pos.Offset,
+		Key:      utils.GetRandomKey(10),
+		Err:      data.ErrorInvalidCRC,
+	}, report.Problems[1])
+}
+
+func TestVerifyTxnFinMissing(t *testing.T) {
+	dirPath := t.TempDir()
+	opts := []DBOption{WithDBDirPath(dirPath), WithDBMaxDataFileSize(4 * 1024)}
+	db, err := Open(opts...)
+	require.NoError(t, err)
+	wb := db.NewWriteBatch()
+	require.NoError(t, wb.Put([]byte("txn"), []byte("value")))
+	require.NoError(t, wb.Commit())
+	pos := db.index.Get([]byte("txn"))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put(utils.GetRandomKey(i), utils.GetRandomValue(64)))
+	}
+	require.NoError(t, db.Close())
+
+	// 修改事务完成标志的crc，事务完成标志紧跟在事务的数据之后
+	fileName := data.GetDataFileName(dirPath, pos.Fid)
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	finOffset := pos.Offset + int64(pos.Size)
+	buf[finOffset]++
+	require.NoError(t, os.WriteFile(fileName, buf, fio.FilePerm))
+
+	db, err = Open(opts...)
+	require.NoError(t, err)
+	defer db.Close()
+	report, err := db.Verify(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []*VerifyProblem{
+		{FileName: filepath.Base(fileName), Offset: finOffset, Err: data.ErrorInvalidCRC},
+		{FileName: filepath.Base(fileName), Offset: pos.Offset, Key: []byte("txn"), Err: ErrTxnFinMissing},
+	}, report.Problems)
+}